@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/seabfh/kv-squirrel/internal/ratelimit"
+	"github.com/seabfh/kv-squirrel/internal/runstatus"
 )
 
 type GeneratorConfig struct {
@@ -25,6 +28,9 @@ type GeneratorConfig struct {
 	SetSize     int
 	HashFields  int
 	ZSetMembers int
+	RateLimits  ratelimit.Limits
+	RateControl string
+	MaxFailures runstatus.FailureLimit
 }
 
 var (
@@ -50,7 +56,8 @@ func main() {
 
 	// Test connection
 	if err := client.Ping(ctx).Err(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		log.Printf("Failed to connect to Redis: %v", err)
+		os.Exit(runstatus.ExitConnection)
 	}
 
 	log.Printf("✓ Connected to Redis cluster:  %v\n", config.Addrs)
@@ -58,6 +65,12 @@ func main() {
 
 	rand.Seed(time.Now().UnixNano())
 
+	limiter := ratelimit.New(config.RateLimits)
+	if config.RateControl != "" || config.RateLimits != (ratelimit.Limits{}) {
+		log.Printf("Rate limits: %s\n", config.RateLimits)
+		go ratelimit.Watch(ctx, limiter, config.RateControl)
+	}
+
 	generated := 0
 	failed := 0
 	startTime := time.Now()
@@ -71,24 +84,43 @@ func main() {
 
 		// Randomly select a data type
 		dataType := config.DataTypes[rand.Intn(len(config.DataTypes))]
+		key := fmt.Sprintf("%s:%s:%d", config.KeyPrefix, dataType, i)
+
+		node := ""
+		if master, err := client.MasterForKey(ctx, key); err == nil {
+			node = master.Options().Addr
+		}
+		if err := limiter.Wait(ctx, node, 1, 0); err != nil {
+			log.Fatalf("Rate limiter failed: %v", err)
+		}
 
+		var size int
 		var err error
 		switch dataType {
 		case "string":
-			err = generateString(ctx, client, config, i)
+			size, err = generateString(ctx, client, config, key, i)
 		case "list":
-			err = generateList(ctx, client, config, i)
+			size, err = generateList(ctx, client, config, key)
 		case "set":
-			err = generateSet(ctx, client, config, i)
+			size, err = generateSet(ctx, client, config, key)
 		case "hash":
-			err = generateHash(ctx, client, config, i)
+			size, err = generateHash(ctx, client, config, key, i)
 		case "zset":
-			err = generateZSet(ctx, client, config, i)
+			size, err = generateZSet(ctx, client, config, key)
+		}
+
+		// The value size is only known once it has been generated
+		if err := limiter.Wait(ctx, node, 0, size); err != nil {
+			log.Fatalf("Rate limiter failed: %v", err)
 		}
 
 		if err != nil {
 			log.Printf("⚠ Failed to generate key %d: %v\n", i, err)
 			failed++
+			if err := config.MaxFailures.Check(failed, config.Count); err != nil {
+				log.Printf("✗ Generation aborted: %v\n", err)
+				os.Exit(runstatus.ExitAborted)
+			}
 		} else {
 			generated++
 		}
@@ -99,6 +131,10 @@ func main() {
 	log.Printf("  Successfully generated: %d keys\n", generated)
 	log.Printf("  Failed:  %d keys\n", failed)
 	log.Printf("  Average rate: %.0f keys/sec\n", float64(generated)/elapsed.Seconds())
+
+	if failed > 0 {
+		os.Exit(runstatus.ExitPartial)
+	}
 }
 
 func parseFlags() *GeneratorConfig {
@@ -116,6 +152,12 @@ func parseFlags() *GeneratorConfig {
 	flag.IntVar(&config.SetSize, "set-size", 10, "Number of elements in sets")
 	flag.IntVar(&config.HashFields, "hash-fields", 5, "Number of fields in hashes")
 	flag.IntVar(&config.ZSetMembers, "zset-members", 10, "Number of members in sorted sets")
+	flag.Float64Var(&config.RateLimits.KeysPerSec, "rate-keys", 0, "Maximum keys per second across all nodes (0 = unlimited)")
+	flag.Float64Var(&config.RateLimits.BytesPerSec, "rate-bytes", 0, "Maximum bytes per second across all nodes (0 = unlimited)")
+	flag.Float64Var(&config.RateLimits.NodeKeysPerSec, "node-rate-keys", 0, "Maximum keys per second per node (0 = unlimited)")
+	flag.Float64Var(&config.RateLimits.NodeBytesPerSec, "node-rate-bytes", 0, "Maximum bytes per second per node (0 = unlimited)")
+	flag.StringVar(&config.RateControl, "rate-control", "", "Rate limit control file, reloaded on change or SIGHUP")
+	flag.Var(&config.MaxFailures, "max-failures", "Abort once more keys failed than this count or percentage, e.g. 100 or 5% (default: never)")
 
 	flag.Parse()
 
@@ -144,9 +186,7 @@ func parseAddresses(input string) []string {
 	return result
 }
 
-func generateString(ctx context.Context, client redis.UniversalClient, config *GeneratorConfig, index int) error {
-	key := fmt.Sprintf("%s:string:%d", config.KeyPrefix, index)
-
+func generateString(ctx context.Context, client redis.UniversalClient, config *GeneratorConfig, key string, index int) (int, error) {
 	// Generate different types of string data
 	var value string
 	switch rand.Intn(5) {
@@ -169,52 +209,52 @@ func generateString(ctx context.Context, client redis.UniversalClient, config *G
 	}
 
 	ttl := randomTTL(config)
-	return client.Set(ctx, key, value, ttl).Err()
+	return len(key) + len(value), client.Set(ctx, key, value, ttl).Err()
 }
 
-func generateList(ctx context.Context, client redis.UniversalClient, config *GeneratorConfig, index int) error {
-	key := fmt.Sprintf("%s:list:%d", config.KeyPrefix, index)
-
+func generateList(ctx context.Context, client redis.UniversalClient, config *GeneratorConfig, key string) (int, error) {
 	// Generate list items
+	size := len(key)
 	items := make([]interface{}, config.ListSize)
 	for i := 0; i < config.ListSize; i++ {
-		items[i] = fmt.Sprintf("item_%d_%s", i, randomString(10))
+		item := fmt.Sprintf("item_%d_%s", i, randomString(10))
+		items[i] = item
+		size += len(item)
 	}
 
 	if err := client.RPush(ctx, key, items...).Err(); err != nil {
-		return err
+		return size, err
 	}
 
 	ttl := randomTTL(config)
 	if ttl > 0 {
-		return client.Expire(ctx, key, ttl).Err()
+		return size, client.Expire(ctx, key, ttl).Err()
 	}
-	return nil
+	return size, nil
 }
 
-func generateSet(ctx context.Context, client redis.UniversalClient, config *GeneratorConfig, index int) error {
-	key := fmt.Sprintf("%s:set:%d", config.KeyPrefix, index)
-
+func generateSet(ctx context.Context, client redis.UniversalClient, config *GeneratorConfig, key string) (int, error) {
 	// Generate unique set members
+	size := len(key)
 	members := make([]interface{}, config.SetSize)
 	for i := 0; i < config.SetSize; i++ {
-		members[i] = fmt.Sprintf("%s_%d", products[rand.Intn(len(products))], rand.Intn(1000))
+		member := fmt.Sprintf("%s_%d", products[rand.Intn(len(products))], rand.Intn(1000))
+		members[i] = member
+		size += len(member)
 	}
 
 	if err := client.SAdd(ctx, key, members...).Err(); err != nil {
-		return err
+		return size, err
 	}
 
 	ttl := randomTTL(config)
 	if ttl > 0 {
-		return client.Expire(ctx, key, ttl).Err()
+		return size, client.Expire(ctx, key, ttl).Err()
 	}
-	return nil
+	return size, nil
 }
 
-func generateHash(ctx context.Context, client redis.UniversalClient, config *GeneratorConfig, index int) error {
-	key := fmt.Sprintf("%s:hash:%d", config.KeyPrefix, index)
-
+func generateHash(ctx context.Context, client redis.UniversalClient, config *GeneratorConfig, key string, index int) (int, error) {
 	// Generate hash fields
 	fields := make(map[string]interface{})
 
@@ -239,38 +279,44 @@ func generateHash(ctx context.Context, client redis.UniversalClient, config *Gen
 		}
 	}
 
+	size := len(key)
+	for field, value := range fields {
+		size += len(field) + len(fmt.Sprint(value))
+	}
+
 	if err := client.HSet(ctx, key, fields).Err(); err != nil {
-		return err
+		return size, err
 	}
 
 	ttl := randomTTL(config)
 	if ttl > 0 {
-		return client.Expire(ctx, key, ttl).Err()
+		return size, client.Expire(ctx, key, ttl).Err()
 	}
-	return nil
+	return size, nil
 }
 
-func generateZSet(ctx context.Context, client redis.UniversalClient, config *GeneratorConfig, index int) error {
-	key := fmt.Sprintf("%s:zset:%d", config.KeyPrefix, index)
-
+func generateZSet(ctx context.Context, client redis.UniversalClient, config *GeneratorConfig, key string) (int, error) {
 	// Generate sorted set members with scores
+	size := len(key)
 	members := make([]redis.Z, config.ZSetMembers)
 	for i := 0; i < config.ZSetMembers; i++ {
+		member := fmt.Sprintf("%s:%d", randomString(10), i)
 		members[i] = redis.Z{
 			Score:  float64(rand.Intn(1000)),
-			Member: fmt.Sprintf("%s:%d", randomString(10), i),
+			Member: member,
 		}
+		size += len(member) + 8
 	}
 
 	if err := client.ZAdd(ctx, key, members...).Err(); err != nil {
-		return err
+		return size, err
 	}
 
 	ttl := randomTTL(config)
 	if ttl > 0 {
-		return client.Expire(ctx, key, ttl).Err()
+		return size, client.Expire(ctx, key, ttl).Err()
 	}
-	return nil
+	return size, nil
 }
 
 func randomString(length int) string {
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Checkpoint records where an interrupted import stopped
+type Checkpoint struct {
+	InputFile   string    `json:"input_file"`
+	RetryReport string    `json:"retry_report,omitempty"` // -retry-from report filtering the input
+	NextIndex   int       `json:"next_index"`             // First record not yet imported, in ImportOptions.Skip units
+	Imported    int       `json:"imported"`
+	Failed      int       `json:"failed"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// checkpointPath returns the checkpoint file used for an import
+func checkpointPath(config *Config) string {
+	if config.Checkpoint != "" {
+		return config.Checkpoint
+	}
+	switch {
+	case config.InputFile == "-":
+		return "stdin.checkpoint"
+	case strings.Contains(config.InputFile, "://"):
+		// Remote dumps are checkpointed in the working directory, named
+		// after their bucket or host and path
+		u, err := url.Parse(config.InputFile)
+		if err != nil || u.Host == "" {
+			return "import.checkpoint"
+		}
+		name := u.Host
+		if object := strings.Trim(path.Clean("/"+u.Path), "/"); object != "" {
+			name += "-" + strings.ReplaceAll(object, "/", "-")
+		}
+		return name + ".checkpoint"
+	}
+	return config.InputFile + ".checkpoint"
+}
+
+// saveCheckpoint writes a checkpoint atomically
+func saveCheckpoint(path string, checkpoint *Checkpoint) error {
+	checkpoint.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// loadCheckpoint reads the checkpoint of an earlier import of the same input,
+// filtered by the same failure report. Records are skipped by index, so both
+// must match for the import to continue where it stopped.
+func loadCheckpoint(path string, config *Config) (*Checkpoint, error) {
+	if config.RetryReport != "" && config.RetryReport == config.ReportFile {
+		return nil, fmt.Errorf("cannot resume a retry whose -retry-from report %s was replaced by its -failure-report", config.RetryReport)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	if checkpoint.InputFile != config.InputFile {
+		return nil, fmt.Errorf("checkpoint %s belongs to %s, not %s", path, checkpoint.InputFile, config.InputFile)
+	}
+	if checkpoint.RetryReport != config.RetryReport {
+		return nil, fmt.Errorf("checkpoint %s was written with -retry-from %q, not %q", path, checkpoint.RetryReport, config.RetryReport)
+	}
+	return &checkpoint, nil
+}
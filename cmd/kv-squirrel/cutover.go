@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/seabfh/kv-squirrel/squirrel"
+)
+
+// cutover pauses writes on the source once a running sync has caught up and
+// verifies the target, so that clients can be switched over
+func cutover(ctx context.Context, config *Config) error {
+	if config.Drain >= config.Pause {
+		return fmt.Errorf("-drain-timeout (%s) must be shorter than -pause-timeout (%s)", config.Drain, config.Pause)
+	}
+
+	sourceClient, err := connect(ctx, "source", config.SourceAddrs, config.SourceUser, config.SourcePass, false)
+	if err != nil {
+		return err
+	}
+	defer sourceClient.Close()
+
+	targetClient, err := connect(ctx, "target", config.TargetAddrs, config.TargetUser, config.TargetPass, false)
+	if err != nil {
+		return err
+	}
+	defer targetClient.Close()
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	report, err := squirrel.Cutover(ctx, sourceClient, targetClient, squirrel.CutoverOptions{
+		PauseTimeout: config.Pause,
+		DrainTimeout: config.Drain,
+		Samples:      config.Samples,
+		Pattern:      config.Pattern,
+		Lag: func(ctx context.Context) (squirrel.SyncStatus, error) {
+			return fetchStatus(ctx, client, config.StatusURL)
+		},
+		Logf: log.Printf,
+	})
+	for _, mismatch := range report.Mismatches {
+		log.Printf("  ✗ %s\n", mismatch)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("✓ Cutover verified: %d keys, %d sampled keys match\n", report.TargetKeys, report.Sampled)
+	log.Printf("✓ Switch clients to the target now, writes on the source stay paused until %s\n", report.PausedUntil.Format(time.RFC3339))
+	return nil
+}
+
+// fetchStatus reads the status served by a sync with -status-addr
+func fetchStatus(ctx context.Context, client *http.Client, url string) (squirrel.SyncStatus, error) {
+	var status squirrel.SyncStatus
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return status, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, fmt.Errorf("failed to decode status: %w", err)
+	}
+	return status, nil
+}
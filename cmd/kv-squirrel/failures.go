@@ -0,0 +1,32 @@
+package main
+
+import "github.com/seabfh/kv-squirrel/squirrel"
+
+// failedKeyReader yields the keys of a reader that failed to import according to a report
+type failedKeyReader struct {
+	squirrel.KeyReader
+	failed map[string]bool
+}
+
+// newFailedKeyReader filters r by the keys that failed to import according to a report
+func newFailedKeyReader(r squirrel.KeyReader, reportPath string) (*failedKeyReader, error) {
+	keys, err := squirrel.LoadFailedKeys(reportPath, squirrel.PhaseImport)
+	if err != nil {
+		return nil, err
+	}
+	failed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		failed[key] = true
+	}
+	return &failedKeyReader{KeyReader: r, failed: failed}, nil
+}
+
+// Next returns the next failed key, or io.EOF after the last one
+func (f *failedKeyReader) Next() (*squirrel.KeyData, error) {
+	for {
+		keyData, err := f.KeyReader.Next()
+		if err != nil || f.failed[keyData.Key] {
+			return keyData, err
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/seabfh/kv-squirrel/squirrel"
+	"github.com/seabfh/kv-squirrel/storage"
+)
+
+// Dump formats
+const (
+	formatJSON = "json"
+	formatRDB  = "rdb"
+	formatRESP = "resp" // Commands for redis-cli --pipe
+)
+
+// inputFormat returns the format of the import input, guessed from its name unless -format is set
+func inputFormat(config *Config) string {
+	if config.Format != "" {
+		return config.Format
+	}
+	return formatOf(strings.Split(config.InputFile, ",")[0])
+}
+
+// outputFormat returns the format of the export output, guessed from its name unless -format is set
+func outputFormat(config *Config) string {
+	if config.Format != "" {
+		return config.Format
+	}
+	return formatOf(config.OutputFile)
+}
+
+// formatOf guesses the format of a dump location from its extension
+func formatOf(location string) string {
+	location, _, _ = strings.Cut(location, "?")
+	switch ext := path.Ext(location); {
+	case strings.EqualFold(ext, ".rdb"):
+		return formatRDB
+	case strings.EqualFold(ext, ".resp"):
+		return formatRESP
+	default:
+		return formatJSON
+	}
+}
+
+// dumpOutput receives exported keys in one of the dump formats
+type dumpOutput interface {
+	squirrel.KeyWriter
+	// Close completes the output; meta is only kept by JSON dumps. Formats
+	// that cannot mark a dump partial discard it instead.
+	Close(meta squirrel.DumpMetadata) error
+}
+
+// errIncomplete aborts the output of an export that did not complete
+var errIncomplete = errors.New("export did not complete")
+
+// createOutput creates the export output in the format of config
+func createOutput(ctx context.Context, config *Config) (dumpOutput, error) {
+	format := outputFormat(config)
+	if format == formatRDB {
+		return createRDBOutput(ctx, config)
+	}
+	if config.SlotLayout != "" {
+		return nil, fmt.Errorf("-slot-layout needs -format rdb")
+	}
+	if format == formatRESP {
+		file, err := storage.Create(ctx, config.OutputFile)
+		if err != nil {
+			return nil, err
+		}
+		return &respOutput{RESPWriter: squirrel.NewRESPWriter(file), file: file}, nil
+	}
+
+	file, err := storage.Create(ctx, config.OutputFile)
+	if err != nil {
+		return nil, err
+	}
+	dump, err := squirrel.NewDumpWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &jsonOutput{DumpWriter: dump, file: file}, nil
+}
+
+// jsonOutput writes a JSON dump
+type jsonOutput struct {
+	*squirrel.DumpWriter
+	file io.WriteCloser
+}
+
+func (o *jsonOutput) Close(meta squirrel.DumpMetadata) error {
+	if err := o.DumpWriter.Close(meta); err != nil {
+		storage.Abort(o.file, err)
+		return err
+	}
+	return o.file.Close()
+}
+
+// respOutput writes the commands recreating the keys
+type respOutput struct {
+	*squirrel.RESPWriter
+	file io.WriteCloser
+}
+
+func (o *respOutput) Close(meta squirrel.DumpMetadata) error {
+	if meta.Partial {
+		log.Printf("⚠ Discarding the output: RESP files cannot be marked partial\n")
+		storage.Abort(o.file, errIncomplete)
+		return nil
+	}
+	if err := o.RESPWriter.Close(); err != nil {
+		storage.Abort(o.file, err)
+		return err
+	}
+	return o.file.Close()
+}
+
+// rdbOutput writes an RDB file, or one per shard of a slot layout
+type rdbOutput struct {
+	squirrel.KeyWriter
+	writers []*squirrel.RDBWriter
+	files   []io.WriteCloser
+}
+
+// createRDBOutput creates the RDB file, or with -slot-layout one file per shard
+func createRDBOutput(ctx context.Context, config *Config) (*rdbOutput, error) {
+	locations := []string{config.OutputFile}
+	var layout [][]squirrel.SlotRange
+	if config.SlotLayout != "" {
+		var err error
+		if layout, err = squirrel.ParseSlotLayout(config.SlotLayout); err != nil {
+			return nil, err
+		}
+		if config.OutputFile == "-" {
+			return nil, fmt.Errorf("-slot-layout writes one file per shard and cannot write to stdout")
+		}
+		locations = make([]string, len(layout))
+		for i := range layout {
+			locations[i] = storage.ShardLocation(config.OutputFile, i)
+		}
+	}
+
+	output := &rdbOutput{}
+	var writers []squirrel.KeyWriter
+	for _, location := range locations {
+		file, err := storage.Create(ctx, location)
+		if err != nil {
+			output.abort(err)
+			return nil, err
+		}
+		writer := squirrel.NewRDBWriter(file)
+		output.files = append(output.files, file)
+		output.writers = append(output.writers, writer)
+		writers = append(writers, writer)
+	}
+
+	if layout == nil {
+		output.KeyWriter = writers[0]
+		return output, nil
+	}
+	sharded, err := squirrel.NewShardWriter(layout, writers)
+	if err != nil {
+		output.abort(err)
+		return nil, err
+	}
+	for i, location := range locations {
+		log.Printf("  Shard %d: %s\n", i, displayLocation(location))
+	}
+	output.KeyWriter = sharded
+	return output, nil
+}
+
+func (o *rdbOutput) Close(meta squirrel.DumpMetadata) error {
+	if meta.Partial {
+		log.Printf("⚠ Discarding the output: RDB files cannot be marked partial\n")
+		o.abort(errIncomplete)
+		return nil
+	}
+	for _, writer := range o.writers {
+		if err := writer.Close(); err != nil {
+			o.abort(err)
+			return err
+		}
+	}
+	// Shards completed so far stay, there is no taking them back
+	for i, file := range o.files {
+		if err := file.Close(); err != nil {
+			for _, file := range o.files[i+1:] {
+				storage.Abort(file, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// abort discards the files without completing them
+func (o *rdbOutput) abort(err error) {
+	for _, file := range o.files {
+		storage.Abort(file, err)
+	}
+}
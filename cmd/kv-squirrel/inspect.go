@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/seabfh/kv-squirrel/rdb"
+	"github.com/seabfh/kv-squirrel/squirrel"
+	"github.com/seabfh/kv-squirrel/storage"
+)
+
+// inspectedKey is the JSON line inspect prints per key
+type inspectedKey struct {
+	Key      string      `json:"key"`
+	Type     string      `json:"type"`
+	TTL      int64       `json:"ttl_ms"` // -1 without expiry
+	Value    interface{} `json:"value"`
+	DumpSize int         `json:"dump_size,omitempty"` // Bytes of the DUMP payload the value was decoded from
+	Deleted  bool        `json:"deleted,omitempty"`
+	Chunk    int         `json:"chunk,omitempty"` // Record of a collection exported in chunks
+	More     bool        `json:"more,omitempty"`
+}
+
+// inspectKeys prints the keys of a dump as JSON lines on stdout, decoding
+// DUMP payloads into values without a server
+func inspectKeys(ctx context.Context, config *Config) error {
+	format := inputFormat(config)
+	var readers []squirrel.KeyReader
+	for _, location := range strings.Split(config.InputFile, ",") {
+		location = strings.TrimSpace(location)
+		if location == "" {
+			continue
+		}
+		input, err := storage.Open(ctx, location)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+
+		var reader squirrel.KeyReader
+		switch format {
+		case formatRDB:
+			reader, err = squirrel.NewRDBReader(input, squirrel.RDBOptions{Pattern: config.Pattern, DB: config.DB, Logical: true})
+		case formatRESP:
+			reader = squirrel.NewRESPReader(input)
+		default:
+			reader, err = squirrel.NewDumpReader(input)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", location, err)
+		}
+		readers = append(readers, reader)
+	}
+
+	keys := squirrel.NewMultiReader(readers...)
+	output := json.NewEncoder(os.Stdout)
+	var inspected, corrupt, undecoded, failed int
+	for ctx.Err() == nil {
+		keyData, err := keys.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !squirrel.MatchPattern(config.Pattern, keyData.Key) {
+			continue
+		}
+
+		if err := keyData.Err(); err != nil {
+			log.Printf("  ⚠ Malformed record of key %s: %v\n", keyData.Key, err)
+			corrupt++
+			continue
+		}
+
+		line := inspectedKey{Key: keyData.Key, Type: keyData.Type, TTL: -1, Value: keyData.Value, Deleted: keyData.Deleted,
+			Chunk: keyData.Chunk, More: keyData.More}
+		if keyData.TTL > 0 {
+			line.TTL = keyData.TTL.Milliseconds()
+		}
+		if len(keyData.Dump) > 0 {
+			line.DumpSize = len(keyData.Dump)
+			keyType, value, err := squirrel.DecodeDump(keyData.Dump)
+			var unsupported *rdb.UnsupportedTypeError
+			switch {
+			case errors.As(err, &unsupported):
+				undecoded++
+			case err != nil:
+				log.Printf("  ⚠ Corrupt DUMP payload of key %s: %v\n", keyData.Key, err)
+				corrupt++
+				continue
+			default:
+				line.Type, line.Value = keyType, value
+			}
+		}
+		value, err := squirrel.LogicalValue(line.Type, line.Value)
+		if err == nil {
+			line.Value = value
+			err = output.Encode(line)
+		}
+		if err != nil {
+			log.Printf("  ⚠ Failed to print key %s: %v\n", keyData.Key, err)
+			failed++
+			continue
+		}
+		inspected++
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("✓ Inspected: %d keys\n", inspected)
+	if undecoded > 0 {
+		log.Printf("  Module values left undecoded: %d keys\n", undecoded)
+	}
+	if failed > 0 {
+		log.Printf("⚠ Not printable as JSON: %d keys\n", failed)
+	}
+	if corrupt > 0 {
+		return fmt.Errorf("%d keys have corrupt DUMP payloads or malformed records: %w", corrupt, squirrel.ErrPartial)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d keys could not be printed: %w", failed, squirrel.ErrPartial)
+	}
+	return nil
+}
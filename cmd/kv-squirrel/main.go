@@ -2,26 +2,21 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/seabfh/kv-squirrel/internal/ratelimit"
+	"github.com/seabfh/kv-squirrel/internal/runstatus"
+	"github.com/seabfh/kv-squirrel/squirrel"
 )
 
-// KeyData represents a Redis key with all its metadata
-type KeyData struct {
-	Key   string        `json:"key"`
-	Type  string        `json:"type"`
-	TTL   time.Duration `json:"ttl"`
-	Value interface{}   `json:"value"`
-	Dump  []byte        `json:"dump"` // Using DUMP for complex types
-}
-
 // Config holds the tool configuration
 type Config struct {
 	SourceAddrs []string
@@ -33,33 +28,159 @@ type Config struct {
 	Pattern     string
 	OutputFile  string
 	InputFile   string
+	Mode        string // export, import, migrate, sync, cutover or inspect
+	Migrate     bool   // Copy from source to target without a file
 	BatchSize   int64
 	UseRDBDump  bool // Use DUMP/RESTORE for accurate replication
+	FromReplica bool // Scan and read from replicas instead of masters
+	Consistent  bool // Re-export keys changed during the export
+	Configure   bool // Enable keyspace notifications for a consistent export
+	RateLimits  ratelimit.Limits
+	RateControl string // File holding rate limits that can change while running
+	Adaptive    bool   // Throttle based on source/target health
+	Health      squirrel.HealthThresholds
+	ReportFile  string // Failure report written during the run
+	RetryReport string // Failure report whose keys are re-run instead of scanning
+	Retry       squirrel.RetryPolicy
+	MaxFailures runstatus.FailureLimit // Abort once more keys than this failed
+	Checkpoint  string                 // Where an interrupted import records its progress
+	Resume      bool                   // Continue an import from its checkpoint
+	StatusAddr  string                 // Where a sync serves its status
+	SyncWorkers int
+	SyncEngine  string        // keyspace or psync
+	Topology    time.Duration // How often a sync rediscovers source masters
+	StatusURL   string        // Status endpoint of the sync a cutover waits for
+	Pause       time.Duration // How long a cutover pauses writes on the source
+	Drain       time.Duration // How long a cutover waits for the sync to catch up
+	Samples     int           // Keys whose values a cutover compares
+	Transport   string        // restore or migrate
+	MigrateSize int           // Keys per MIGRATE command
+	MigrateWait time.Duration // MIGRATE timeout between source and target
+	Format      string        // Dump format: json or rdb, guessed from the file name when empty
+	Conflict    string        // What an import does with keys that already exist
+	DB          int           // Database of RDB files that is imported
+	SlotLayout  string        // Shards of RDB exports, one file each
+	ChunkSize   int64         // Elements per record of collections exported by type
+	MaxDumpSize int64         // Bigger keys are moved by type in chunks
 }
 
+// Run modes, also accepted as the first argument
+const (
+	modeExport  = "export"
+	modeImport  = "import"
+	modeMigrate = "migrate"
+	modeSync    = "sync"
+	modeCutover = "cutover"
+	modeInspect = "inspect"
+)
+
 func main() {
+	// Logs always go to stderr, stdout only carries a dump written to -output -
+	log.SetOutput(os.Stderr)
+
 	config := parseFlags()
 
-	if config.InputFile == "" {
-		// Export mode
+	// SIGINT/SIGTERM cancel the run; in-flight keys are finished first
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Println("⚠ Interrupt received, finishing in-flight keys (press Ctrl+C again to force quit)")
+	}()
+
+	limiter := ratelimit.New(config.RateLimits)
+	// Limits are adjusted by signal only when configured, so that SIGHUP
+	// otherwise still stops the process
+	if config.RateControl != "" || config.RateLimits != (ratelimit.Limits{}) {
+		log.Printf("Rate limits: %s\n", config.RateLimits)
+		go ratelimit.Watch(ctx, limiter, config.RateControl)
+	}
+
+	switch config.Mode {
+	case modeMigrate:
+		log.Println("=== Migrate Mode ===")
+		if err := migrateKeys(ctx, config, limiter); err != nil {
+			exit("Migration", err)
+		}
+		log.Println("✓ Migration completed successfully")
+	case modeSync:
+		log.Println("=== Sync Mode ===")
+		if err := syncKeys(ctx, config, limiter); err != nil {
+			exit("Sync", err)
+		}
+		log.Println("✓ Sync stopped")
+	case modeCutover:
+		log.Println("=== Cutover Mode ===")
+		if err := cutover(ctx, config); err != nil {
+			exit("Cutover", err)
+		}
+	case modeInspect:
+		log.Println("=== Inspect Mode ===")
+		if err := inspectKeys(ctx, config); err != nil {
+			exit("Inspect", err)
+		}
+	case modeExport:
 		log.Println("=== Export Mode ===")
-		if err := exportKeys(config); err != nil {
-			log.Fatalf("Export failed: %v", err)
+		if err := exportKeys(ctx, config, limiter); err != nil {
+			exit("Export", err)
 		}
-		log.Printf("✓ Export completed successfully to %s\n", config.OutputFile)
-	} else {
-		// Import mode
+		log.Printf("✓ Export completed successfully to %s\n", displayLocation(config.OutputFile))
+	default:
 		log.Println("=== Import Mode ===")
-		if err := importKeys(config); err != nil {
-			log.Fatalf("Import failed: %v", err)
+		if err := importKeys(ctx, config, limiter); err != nil {
+			exit("Import", err)
 		}
 		log.Println("✓ Import completed successfully")
 	}
 }
 
+// exit logs the outcome of a failed or partial run and exits with its exit code
+func exit(mode string, err error) {
+	code := runstatus.ExitCode(err)
+	switch code {
+	case runstatus.ExitInterrupted:
+		log.Printf("⚠ %s interrupted\n", mode)
+	case runstatus.ExitPartial:
+		log.Printf("⚠ %s completed with failures: %v\n", mode, err)
+	case runstatus.ExitAborted:
+		log.Printf("✗ %s aborted: %v\n", mode, err)
+	default:
+		log.Printf("✗ %s failed: %v\n", mode, err)
+	}
+	os.Exit(code)
+}
+
+// displayLocation names a dump location in logs
+func displayLocation(location string) string {
+	switch location {
+	case "-":
+		return "stdout"
+	default:
+		return location
+	}
+}
+
 func parseFlags() *Config {
 	config := &Config{}
 
+	// The mode is either the first argument or, as before subcommands existed,
+	// implied by -migrate and -input
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case modeExport, modeImport, modeMigrate, modeSync, modeCutover, modeInspect:
+			config.Mode = args[0]
+			args = args[1:]
+		}
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [export|import|migrate|sync|cutover|inspect] [flags]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Without a command, -migrate selects migrate and -input selects import; otherwise keys are exported.")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+		flag.PrintDefaults()
+	}
+
 	// Source cluster flags
 	sourceAddrs := flag.String("source-addrs", "localhost:7000,localhost:7001", "Source cluster addresses (comma-separated)")
 	flag.StringVar(&config.SourceUser, "source-user", "", "Source cluster username (ACL)")
@@ -72,12 +193,89 @@ func parseFlags() *Config {
 
 	// Operation flags
 	flag.StringVar(&config.Pattern, "pattern", "*", "Key pattern to match (glob-style)")
-	flag.StringVar(&config.OutputFile, "output", "redis-dump.json", "Output file for export")
-	flag.StringVar(&config.InputFile, "input", "", "Input file for import (if set, runs import mode)")
+	flag.StringVar(&config.OutputFile, "output", "redis-dump.json", "Output file or URL for export (file path, - for stdout, s3://bucket/key)")
+	flag.StringVar(&config.InputFile, "input", "", "Input file or URL for import and inspect (file path, - for stdin, s3://bucket/key; selects import when no command is given)")
+	flag.StringVar(&config.Format, "format", "", "Dump format of -input or -output: json, rdb or resp (default: guessed from the *.rdb or *.resp extension, json otherwise)")
+	flag.StringVar(&config.SlotLayout, "slot-layout", "", "Write one RDB file per shard, e.g. 0-5460,5461-10922,10923-16383 (join ranges of a shard with +)")
+	flag.StringVar(&config.Conflict, "conflict", squirrel.ConflictReplace, "What import does with keys that already exist on the target: replace, skip or fail")
+	flag.IntVar(&config.DB, "db", 0, "Database of RDB files whose keys are imported")
+	flag.BoolVar(&config.Migrate, "migrate", false, "Copy keys from the source to the target cluster without an intermediate file")
 	flag.Int64Var(&config.BatchSize, "batch", 1000, "Batch size for scanning")
+	flag.Int64Var(&config.ChunkSize, "chunk-size", squirrel.DefaultChunkSize, "Elements per record of lists, sets, hashes, sorted sets and streams exported by type, with -use-dump=false or over -max-dump-size")
 	flag.BoolVar(&config.UseRDBDump, "use-dump", true, "Use DUMP/RESTORE commands (recommended)")
-
-	flag.Parse()
+	flag.Int64Var(&config.MaxDumpSize, "max-dump-size", squirrel.DefaultMaxDumpSize, "Move keys with bigger DUMP payloads (bytes) by type in chunks, as RESTORE rejects payloads over proto-max-bulk-len; 0 disables")
+	flag.StringVar(&config.Transport, "transport", squirrel.TransportRestore, "How migrate and sync copy keys: restore (DUMP/RESTORE through this process) or migrate (MIGRATE from the source masters to the target, falling back to restore)")
+	flag.IntVar(&config.MigrateSize, "migrate-batch", 100, "Keys per MIGRATE command with -transport migrate")
+	flag.DurationVar(&config.MigrateWait, "migrate-timeout", 5*time.Second, "Timeout of MIGRATE between source and target with -transport migrate")
+	flag.BoolVar(&config.FromReplica, "from-replicas", false, "Export from replicas, falling back to the master when a shard has no healthy replica")
+	flag.BoolVar(&config.Consistent, "consistent", false, "Watch keyspace events and re-export keys changed during the export, with tombstones for deleted keys")
+	flag.BoolVar(&config.Configure, "configure-notifications", false, "Enable keyspace notifications on masters for -consistent and restore the setting afterwards")
+
+	// Rate limit flags
+	flag.Float64Var(&config.RateLimits.KeysPerSec, "rate-keys", 0, "Maximum keys per second across all nodes (0 = unlimited)")
+	flag.Float64Var(&config.RateLimits.BytesPerSec, "rate-bytes", 0, "Maximum bytes per second across all nodes (0 = unlimited)")
+	flag.Float64Var(&config.RateLimits.NodeKeysPerSec, "node-rate-keys", 0, "Maximum keys per second per node (0 = unlimited)")
+	flag.Float64Var(&config.RateLimits.NodeBytesPerSec, "node-rate-bytes", 0, "Maximum bytes per second per node (0 = unlimited)")
+	flag.StringVar(&config.RateControl, "rate-control", "", "Rate limit control file, reloaded on change or SIGHUP")
+
+	// Adaptive throttling flags
+	flag.BoolVar(&config.Adaptive, "adaptive", false, "Slow down, pause or abort based on server health")
+	flag.DurationVar(&config.Health.Interval, "health-interval", time.Second, "How often server health is sampled")
+	flag.DurationVar(&config.Health.MaxLatency, "throttle-latency", 50*time.Millisecond, "Slow down when command latency is above (0 = off)")
+	flag.Int64Var(&config.Health.MaxOpsPerSec, "throttle-ops", 0, "Slow down when instantaneous_ops_per_sec is above (0 = off)")
+	flag.Int64Var(&config.Health.MaxReplLag, "throttle-repl-lag", 10*1024*1024, "Slow down when replication lag in bytes is above (0 = off)")
+	flag.Float64Var(&config.Health.PauseMemoryPct, "pause-memory-pct", 80, "Pause when target used_memory is above this percentage of maxmemory (0 = off)")
+	flag.DurationVar(&config.Health.MaxPause, "pause-memory-timeout", 10*time.Minute, "Abort when paused on target memory for longer than this (0 = wait forever)")
+	flag.Float64Var(&config.Health.AbortMemoryPct, "abort-memory-pct", 90, "Abort when target used_memory is above this percentage of maxmemory (0 = off)")
+
+	// Failure report flags
+	flag.StringVar(&config.ReportFile, "failure-report", "failures.jsonl", "File receiving one JSON line per failed key (replaced by every run, created only on failure)")
+	flag.StringVar(&config.RetryReport, "retry-from", "", "Failure report to retry: only its keys are exported or imported")
+
+	// Retry flags
+	flag.IntVar(&config.Retry.MaxAttempts, "max-attempts", 5, "Attempts per key for transient errors (TRYAGAIN, LOADING, CLUSTERDOWN, timeouts)")
+	flag.DurationVar(&config.Retry.BaseDelay, "retry-delay", 100*time.Millisecond, "Initial backoff delay between attempts")
+	flag.DurationVar(&config.Retry.MaxDelay, "retry-max-delay", 5*time.Second, "Maximum backoff delay between attempts")
+	flag.Var(&config.MaxFailures, "max-failures", "Abort once more keys failed than this count or percentage, e.g. 100 or 5% (default: never)")
+
+	// Sync flags
+	flag.StringVar(&config.StatusAddr, "status-addr", "", "Serve the sync status (queued changes, lag) as JSON on this address, e.g. :8080")
+	flag.StringVar(&config.SyncEngine, "sync-engine", "keyspace", "How sync follows changes: keyspace (notifications) or psync (act as a replica of every master)")
+	flag.IntVar(&config.SyncWorkers, "sync-workers", 4, "Changed keys applied concurrently by sync with -sync-engine keyspace")
+	flag.DurationVar(&config.Topology, "topology-interval", 30*time.Second, "How often sync rediscovers source masters to resubscribe after a failover")
+
+	// Cutover flags
+	flag.StringVar(&config.StatusURL, "status-url", "http://localhost:8080/status", "Status endpoint of the running sync (see -status-addr) that cutover waits for")
+	flag.DurationVar(&config.Pause, "pause-timeout", 30*time.Second, "How long cutover pauses writes on the source; the masters resume writes on their own afterwards")
+	flag.DurationVar(&config.Drain, "drain-timeout", 10*time.Second, "How long cutover waits for the sync to apply the last changes")
+	flag.IntVar(&config.Samples, "samples", 100, "Random keys whose values cutover compares between source and target")
+
+	// Interruption flags
+	flag.StringVar(&config.Checkpoint, "checkpoint", "", "Checkpoint file of an interrupted import (default: <input>.checkpoint)")
+	flag.BoolVar(&config.Resume, "resume", false, "Resume an import from its checkpoint")
+
+	flag.CommandLine.Parse(args)
+
+	switch {
+	case config.Transport != squirrel.TransportRestore && config.Transport != squirrel.TransportMigrate:
+		log.Fatalf("✗ Unknown -transport %q, expected restore or migrate", config.Transport)
+	case config.Format != "" && config.Format != formatJSON && config.Format != formatRDB && config.Format != formatRESP:
+		log.Fatalf("✗ Unknown -format %q, expected json, rdb or resp", config.Format)
+	case config.Conflict != squirrel.ConflictReplace && config.Conflict != squirrel.ConflictSkip && config.Conflict != squirrel.ConflictFail:
+		log.Fatalf("✗ Unknown -conflict %q, expected replace, skip or fail", config.Conflict)
+	case (config.Mode == modeImport || config.Mode == modeInspect) && config.InputFile == "":
+		log.Fatalf("✗ %s requires -input (a file, URL or - for stdin)", config.Mode)
+	case config.Mode != "" && flag.NArg() > 0:
+		log.Fatalf("✗ Unexpected arguments: %v", flag.Args())
+	case config.Mode == "" && flag.NArg() > 0:
+		log.Fatalf("✗ Unknown command %q, expected export, import, migrate, sync, cutover or inspect", flag.Arg(0))
+	case config.Mode == "" && config.Migrate:
+		config.Mode = modeMigrate
+	case config.Mode == "" && config.InputFile != "":
+		config.Mode = modeImport
+	case config.Mode == "":
+		config.Mode = modeExport
+	}
 
 	// Parse addresses
 	config.SourceAddrs = parseAddresses(*sourceAddrs)
@@ -105,347 +303,402 @@ func parseAddresses(addrs string) []string {
 	return result
 }
 
-// exportKeys scans the source cluster and exports matching keys
-func exportKeys(config *Config) error {
-	ctx := context.Background()
+// connect creates a cluster client and checks that the cluster is reachable
+func connect(ctx context.Context, name string, addrs []string, user, pass string, readOnly bool) (*redis.ClusterClient, error) {
+	return connectWith(ctx, name, clusterOptions(addrs, user, pass, readOnly))
+}
 
-	// Connect to source cluster
-	sourceClient := redis.NewClusterClient(&redis.ClusterOptions{
-		Addrs:        config.SourceAddrs,
-		Username:     config.SourceUser,
-		Password:     config.SourcePass,
+// clusterOptions returns the client options of a cluster
+func clusterOptions(addrs []string, user, pass string, readOnly bool) *redis.ClusterOptions {
+	return &redis.ClusterOptions{
+		Addrs:        addrs,
+		Username:     user,
+		Password:     pass,
+		ReadOnly:     readOnly,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
-	})
-	defer sourceClient.Close()
+	}
+}
+
+// connectWith creates a cluster client with opts and checks that the cluster is reachable
+func connectWith(ctx context.Context, name string, opts *redis.ClusterOptions) (*redis.ClusterClient, error) {
+	client := redis.NewClusterClient(opts)
 
 	// Test connection
-	if err := sourceClient.Ping(ctx).Err(); err != nil {
-		return fmt.Errorf("failed to connect to source cluster:  %w", err)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, &runstatus.ConnectionError{Cluster: name, Err: err}
 	}
 
-	log.Printf("✓ Connected to source cluster:   %v\n", config.SourceAddrs)
-	if config.SourceUser != "" {
-		log.Printf("  Using username: %s\n", config.SourceUser)
+	log.Printf("✓ Connected to %s cluster: %v\n", name, opts.Addrs)
+	if opts.Username != "" {
+		log.Printf("  Using username: %s\n", opts.Username)
 	}
+	return client, nil
+}
 
-	// Collect all keys from all master nodes using sync.Map
-	var allKeys sync.Map
-	var totalKeys int
-
-	err := sourceClient.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
-		log.Printf("Scanning master node:  %s\n", master.Options().Addr)
-
-		iter := master.Scan(ctx, 0, config.Pattern, config.BatchSize).Iterator()
-		nodeKeyCount := 0
+// runOptions builds the options shared by every mode
+func runOptions(config *Config, limiter *ratelimit.Limiter, gov *squirrel.Governor, report *squirrel.FailureReport) squirrel.RunOptions {
+	opts := squirrel.RunOptions{
+		Limiter:     limiter,
+		Retry:       config.Retry,
+		MaxFailures: config.MaxFailures,
+		Report:      report,
+		OnEvent:     logEvent,
+		Logf:        log.Printf,
+	}
+	if gov != nil {
+		opts.Throttle = gov
+	}
+	return opts
+}
 
-		for iter.Next(ctx) {
-			key := iter.Val()
+// logEvent logs the progress of a run
+func logEvent(event squirrel.Event) {
+	switch event.Type {
+	case squirrel.EventScanNode:
+		log.Printf("Scanning node:  %s\n", event.Node)
+	case squirrel.EventScanDone:
+		log.Printf("✓ Total unique keys found: %d\n", event.Total)
+	case squirrel.EventKeyFailed:
+		log.Printf("  ⚠ Failed to %s key %s: %v\n", event.Phase, event.Key, event.Err)
+	}
 
-			// LoadOrStore is atomic and returns true if the key was actually stored (was new)
-			if _, loaded := allKeys.LoadOrStore(key, true); !loaded {
-				nodeKeyCount++
-			}
+	switch event.Type {
+	case squirrel.EventKeyDone, squirrel.EventKeyFailed, squirrel.EventKeyExpired, squirrel.EventKeySkipped:
+		switch {
+		case event.Done%100 != 0:
+		case event.Total > 0:
+			log.Printf("  Progress: %d/%d keys\n", event.Done, event.Total)
+		default:
+			log.Printf("  Progress: %d keys\n", event.Done)
 		}
+	}
+}
 
-		if err := iter.Err(); err != nil {
-			return fmt.Errorf("scan error on %s:  %w", master.Options().Addr, err)
-		}
+// logSummary prints the usual end of run summary
+func logSummary(verb string, summary *squirrel.Summary, config *Config) {
+	log.Printf("✓ Successfully %s:   %d keys\n", verb, summary.Succeeded)
+	if summary.Expired > 0 {
+		log.Printf("  Expired before export: %d keys\n", summary.Expired)
+	}
+	if summary.Changed > 0 {
+		log.Printf("  Re-exported after changes: %d keys\n", summary.Changed)
+	}
+	if summary.Deleted > 0 {
+		log.Printf("  Deleted (tombstones): %d keys\n", summary.Deleted)
+	}
+	if summary.Skipped > 0 {
+		log.Printf("  Skipped, already on the target: %d keys\n", summary.Skipped)
+	}
+	if summary.Fallback > 0 {
+		log.Printf("  Written by type, DUMP payload rejected by the target: %d keys\n", summary.Fallback)
+	}
+	if summary.Chunked > 0 {
+		log.Printf("  Too big for DUMP/RESTORE, moved by type in chunks: %d keys\n", summary.Chunked)
+	}
+	if summary.Retries > 0 {
+		log.Printf("  Retried: %d keys (%d retries)\n", summary.RetriedKeys, summary.Retries)
+	}
+	if summary.Failed > 0 {
+		log.Printf("⚠ Failed:  %d keys (see %s)\n", summary.Failed, config.ReportFile)
+	}
+	if config.Transport == squirrel.TransportMigrate && summary.Native.Keys+summary.Restore.Keys > 0 {
+		log.Printf("  MIGRATE:      %d keys in %s (%.0f keys/s)\n", summary.Native.Keys,
+			summary.Native.Duration.Round(time.Millisecond), summary.Native.KeysPerSec())
+		log.Printf("  DUMP/RESTORE: %d keys, %d bytes in %s (%.0f keys/s)\n", summary.Restore.Keys, summary.Restore.Bytes,
+			summary.Restore.Duration.Round(time.Millisecond), summary.Restore.KeysPerSec())
+	}
+}
 
-		log.Printf("  Found %d keys on this node\n", nodeKeyCount)
+// startGovernor watches cluster health when adaptive throttling is enabled
+func startGovernor(ctx context.Context, config *Config, source, target redis.UniversalClient) *squirrel.Governor {
+	if !config.Adaptive {
 		return nil
-	})
+	}
+	gov := squirrel.NewGovernor(log.Printf)
+	if source != nil {
+		go gov.Watch(ctx, "source", source, false, config.Health)
+	}
+	if target != nil {
+		go gov.Watch(ctx, "target", target, true, config.Health)
+	}
+	return gov
+}
 
+// checkRDBVersion warns before a run when the target does not read the RDB
+// version of the DUMP payloads it receives; 0 means the version is unknown
+func checkRDBVersion(ctx context.Context, target redis.UniversalClient, payloadVersion int) {
+	targetVersion, err := squirrel.ServerRDBVersion(ctx, target)
 	if err != nil {
-		return fmt.Errorf("failed to scan cluster:  %w", err)
+		log.Printf("⚠ Cannot detect the RDB version of the target: %v\n", err)
+		return
 	}
+	if payloadVersion > targetVersion {
+		log.Printf("⚠ DUMP payloads have RDB version %d, the target reads up to %d: keys whose RESTORE fails are written by type instead (module types cannot be)\n",
+			payloadVersion, targetVersion)
+		return
+	}
+	log.Printf("✓ Target reads RDB versions up to %d\n", targetVersion)
+}
 
-	// Convert sync.Map to slice
-	keys := make([]string, 0)
-	allKeys.Range(func(key, value interface{}) bool {
-		keys = append(keys, key.(string))
-		totalKeys++
-		return true
-	})
-
-	log.Printf("✓ Total unique keys found: %d\n", totalKeys)
-
-	if len(keys) == 0 {
-		log.Println("⚠ No keys found matching pattern.  Nothing to export.")
-		return nil
+// checkSourceRDBVersion compares the RDB versions of the source and the target before a migration
+func checkSourceRDBVersion(ctx context.Context, source, target redis.UniversalClient) {
+	sourceVersion, err := squirrel.ServerRDBVersion(ctx, source)
+	if err != nil {
+		log.Printf("⚠ Cannot detect the RDB version of the source: %v\n", err)
 	}
+	checkRDBVersion(ctx, target, sourceVersion)
+}
 
-	// Export key data
-	keyDataList := make([]KeyData, 0, len(keys))
-	exported := 0
-	failed := 0
+// exportKeys scans the source cluster and exports matching keys.
+// When ctx is cancelled, the key in flight is finished and the output is
+// closed as a valid dump marked partial.
+func exportKeys(ctx context.Context, config *Config, limiter *ratelimit.Limiter) error {
+	if config.Consistent && outputFormat(config) == formatRDB {
+		// Keys re-exported after a change would appear twice
+		return fmt.Errorf("-consistent needs a JSON or RESP dump, RDB files cannot hold changed or deleted keys")
+	}
 
-	log.Println("Exporting key data...")
+	sourceClient, err := connect(ctx, "source", config.SourceAddrs, config.SourceUser, config.SourcePass, config.FromReplica)
+	if err != nil {
+		return err
+	}
+	defer sourceClient.Close()
 
-	for i, key := range keys {
-		if (i+1)%100 == 0 {
-			log.Printf("  Progress: %d/%d keys\n", i+1, len(keys))
-		}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	gov := startGovernor(ctx, config, sourceClient, nil)
 
-		keyData, err := exportKey(ctx, sourceClient, key, config.UseRDBDump)
+	var keys []string
+	if config.RetryReport != "" {
+		keys, err = squirrel.LoadFailedKeys(config.RetryReport, squirrel.PhaseExport)
 		if err != nil {
-			log.Printf("  ⚠ Failed to export key %s: %v\n", key, err)
-			failed++
-			continue
+			return err
 		}
-
-		keyDataList = append(keyDataList, *keyData)
-		exported++
+		log.Printf("✓ Retrying %d failed keys from %s\n", len(keys), config.RetryReport)
 	}
 
-	log.Printf("✓ Successfully exported:   %d keys\n", exported)
-	if failed > 0 {
-		log.Printf("⚠ Failed to export:  %d keys\n", failed)
+	// The output is completed even after an interrupt so that it holds a valid partial dump
+	output, err := createOutput(context.WithoutCancel(ctx), config)
+	if err != nil {
+		return err
 	}
+	meta := squirrel.DumpMetadata{Pattern: config.Pattern, StartedAt: time.Now()}
 
-	// Write to file
-	file, err := os.Create(config.OutputFile)
+	report, err := squirrel.NewFailureReport(config.ReportFile, false)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return err
 	}
-	defer file.Close()
+	defer report.Close()
+
+	exporter := squirrel.NewExporter(sourceClient, squirrel.ExportOptions{
+		RunOptions:   runOptions(config, limiter, gov, report),
+		Pattern:      config.Pattern,
+		BatchSize:    config.BatchSize,
+		UseDump:      config.UseRDBDump,
+		FromReplicas: config.FromReplica,
+		Keys:         keys,
+		ChunkSize:    config.ChunkSize,
+		MaxDumpSize:  config.MaxDumpSize,
+
+		Consistent:             config.Consistent,
+		ConfigureNotifications: config.Configure,
+	})
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
+	summary, runErr := exporter.Export(ctx, output)
 
-	if err := encoder.Encode(keyDataList); err != nil {
-		return fmt.Errorf("failed to write JSON:   %w", err)
+	if summary.Total == 0 && runErr == nil {
+		log.Println("⚠ No keys found matching pattern.  Nothing to export.")
+	}
+	if errors.Is(runErr, squirrel.ErrInterrupted) {
+		log.Printf("⚠ Interrupted after %d of %d keys, output is marked partial\n", summary.Processed, summary.Total)
+	}
+	logSummary("exported", summary, config)
+
+	// Close the dump, marking it partial unless the run completed
+	meta.Partial = runErr != nil && !errors.Is(runErr, squirrel.ErrPartial)
+	meta.FinishedAt = time.Now()
+	meta.Consistent = summary.Consistent
+	meta.ConsistentAt = summary.ConsistentAt
+	if summary.Consistent {
+		log.Printf("✓ Dump is consistent as of %s\n", summary.ConsistentAt.Format(time.RFC3339))
+	}
+	if err := output.Close(meta); err != nil && runErr == nil {
+		return err
 	}
+	return runErr
+}
 
-	return nil
+// importKeys reads from file and imports to target cluster.
+// When ctx is cancelled, the key in flight is finished and a checkpoint is
+// written so that the import can be resumed with -resume.
+func importKeys(ctx context.Context, config *Config, limiter *ratelimit.Limiter) error {
+	return importStream(ctx, config, inputFormat(config), limiter)
 }
 
-// exportKey exports a single key with all its data
-func exportKey(ctx context.Context, client redis.UniversalClient, key string, useDump bool) (*KeyData, error) {
-	keyData := &KeyData{
-		Key: key,
+// migrateKeys copies matching keys from the source to the target cluster
+func migrateKeys(ctx context.Context, config *Config, limiter *ratelimit.Limiter) error {
+	sourceClient, err := connect(ctx, "source", config.SourceAddrs, config.SourceUser, config.SourcePass, config.FromReplica)
+	if err != nil {
+		return err
 	}
+	defer sourceClient.Close()
 
-	// Get TTL
-	ttl, err := client.TTL(ctx, key).Result()
+	targetClient, err := connect(ctx, "target", config.TargetAddrs, config.TargetUser, config.TargetPass, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get TTL:   %w", err)
+		return err
 	}
-	keyData.TTL = ttl
+	defer targetClient.Close()
 
-	// Get type
-	keyType, err := client.Type(ctx, key).Result()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get type:  %w", err)
+	if config.UseRDBDump {
+		checkSourceRDBVersion(ctx, sourceClient, targetClient)
 	}
-	keyData.Type = keyType
 
-	if useDump {
-		// Use DUMP command for accurate serialization
-		dump, err := client.Dump(ctx, key).Result()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	gov := startGovernor(ctx, config, sourceClient, targetClient)
+
+	var keys []string
+	if config.RetryReport != "" {
+		keys, err = squirrel.LoadFailedKeys(config.RetryReport, squirrel.PhaseImport)
 		if err != nil {
-			return nil, fmt.Errorf("failed to dump key: %w", err)
+			return err
 		}
-		keyData.Dump = []byte(dump)
-	} else {
-		// Fallback: export by type (less reliable for complex types)
-		value, err := exportValueByType(ctx, client, key, keyType)
+		exportKeys, err := squirrel.LoadFailedKeys(config.RetryReport, squirrel.PhaseExport)
 		if err != nil {
-			return nil, fmt.Errorf("failed to export value:   %w", err)
+			return err
 		}
-		keyData.Value = value
+		keys = append(keys, exportKeys...)
+		log.Printf("✓ Retrying %d failed keys from %s\n", len(keys), config.RetryReport)
 	}
 
-	return keyData, nil
-}
-
-// exportValueByType exports value based on Redis type
-func exportValueByType(ctx context.Context, client redis.UniversalClient, key, keyType string) (interface{}, error) {
-	switch keyType {
-	case "string":
-		return client.Get(ctx, key).Result()
-
-	case "list":
-		return client.LRange(ctx, key, 0, -1).Result()
-
-	case "set":
-		return client.SMembers(ctx, key).Result()
-
-	case "zset":
-		return client.ZRangeWithScores(ctx, key, 0, -1).Result()
-
-	case "hash":
-		return client.HGetAll(ctx, key).Result()
+	report, err := squirrel.NewFailureReport(config.ReportFile, false)
+	if err != nil {
+		return err
+	}
+	defer report.Close()
+
+	migrator := squirrel.NewMigrator(sourceClient, targetClient, squirrel.MigrateOptions{
+		RunOptions:   runOptions(config, limiter, gov, report),
+		Pattern:      config.Pattern,
+		BatchSize:    config.BatchSize,
+		UseDump:      config.UseRDBDump,
+		FromReplicas: config.FromReplica,
+		Keys:         keys,
+		ChunkSize:    config.ChunkSize,
+		MaxDumpSize:  config.MaxDumpSize,
+
+		Transport:      config.Transport,
+		MigrateBatch:   config.MigrateSize,
+		MigrateTimeout: config.MigrateWait,
+	})
 
-	default:
-		return nil, fmt.Errorf("unsupported type:   %s", keyType)
+	summary, runErr := migrator.Migrate(ctx)
+	if errors.Is(runErr, squirrel.ErrInterrupted) {
+		log.Printf("⚠ Interrupted after %d of %d keys\n", summary.Processed, summary.Total)
 	}
+	logSummary("migrated", summary, config)
+	return runErr
 }
 
-// importKeys reads from file and imports to target cluster
-func importKeys(config *Config) error {
-	ctx := context.Background()
+// syncEngine is implemented by the keyspace notification and replication based syncers
+type syncEngine interface {
+	Run(ctx context.Context) (*squirrel.Summary, error)
+	Status() squirrel.SyncStatus
+}
 
-	// Read from file
-	file, err := os.Open(config.InputFile)
+// syncKeys copies matching keys and then applies changes on the source until interrupted
+func syncKeys(ctx context.Context, config *Config, limiter *ratelimit.Limiter) error {
+	sourceClient, err := connect(ctx, "source", config.SourceAddrs, config.SourceUser, config.SourcePass, false)
 	if err != nil {
-		return fmt.Errorf("failed to open input file: %w", err)
+		return err
 	}
-	defer file.Close()
+	defer sourceClient.Close()
 
-	var keyDataList []KeyData
-	if err := json.NewDecoder(file).Decode(&keyDataList); err != nil {
-		return fmt.Errorf("failed to parse JSON: %w", err)
+	targetOpts := clusterOptions(config.TargetAddrs, config.TargetUser, config.TargetPass, false)
+	if config.SyncEngine == "psync" {
+		// A replicated command that timed out may have been applied, so the
+		// client must not send it again on its own: -retry decides, also for
+		// MOVED and ASK, knowing which commands are safe to repeat
+		targetOpts.MaxRetries = -1
+		targetOpts.MaxRedirects = -1
 	}
-
-	log.Printf("✓ Loaded %d keys from %s\n", len(keyDataList), config.InputFile)
-
-	if len(keyDataList) == 0 {
-		log.Println("⚠ No keys to import")
-		return nil
+	targetClient, err := connectWith(ctx, "target", targetOpts)
+	if err != nil {
+		return err
 	}
-
-	// Connect to target cluster
-	targetClient := redis.NewClusterClient(&redis.ClusterOptions{
-		Addrs:        config.TargetAddrs,
-		Username:     config.TargetUser,
-		Password:     config.TargetPass,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-	})
 	defer targetClient.Close()
 
-	// Test connection
-	if err := targetClient.Ping(ctx).Err(); err != nil {
-		return fmt.Errorf("failed to connect to target cluster:  %w", err)
+	if config.UseRDBDump || config.SyncEngine == "psync" {
+		checkSourceRDBVersion(ctx, sourceClient, targetClient)
 	}
 
-	log.Printf("✓ Connected to target cluster: %v\n", config.TargetAddrs)
-	if config.TargetUser != "" {
-		log.Printf("  Using username: %s\n", config.TargetUser)
-	}
-
-	// Import keys
-	imported := 0
-	failed := 0
-
-	log.Println("Importing keys...")
-
-	for i, keyData := range keyDataList {
-		if (i+1)%100 == 0 {
-			log.Printf("  Progress: %d/%d keys\n", i+1, len(keyDataList))
-		}
-
-		if err := importKey(ctx, targetClient, &keyData, config.UseRDBDump); err != nil {
-			log.Printf("  ⚠ Failed to import key %s: %v\n", keyData.Key, err)
-			failed++
-			continue
-		}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	gov := startGovernor(ctx, config, sourceClient, targetClient)
 
-		imported++
+	report, err := squirrel.NewFailureReport(config.ReportFile, false)
+	if err != nil {
+		return err
 	}
-
-	log.Printf("✓ Successfully imported:   %d keys\n", imported)
-	if failed > 0 {
-		log.Printf("⚠ Failed to import:  %d keys\n", failed)
+	defer report.Close()
+
+	syncOpts := squirrel.SyncOptions{
+		MigrateOptions: squirrel.MigrateOptions{
+			RunOptions: runOptions(config, limiter, gov, report),
+			Pattern:    config.Pattern,
+			BatchSize:  config.BatchSize,
+			UseDump:    config.UseRDBDump,
+
+			Transport:      config.Transport,
+			MigrateBatch:   config.MigrateSize,
+			MigrateTimeout: config.MigrateWait,
+		},
+		ConfigureNotifications: config.Configure,
+		Workers:                config.SyncWorkers,
+		TopologyInterval:       config.Topology,
 	}
 
-	return nil
-}
-
-// importKey imports a single key
-func importKey(ctx context.Context, client redis.UniversalClient, keyData *KeyData, useDump bool) error {
-	if useDump && len(keyData.Dump) > 0 {
-		// Use RESTORE command
-		ttl := keyData.TTL
-		if ttl < 0 {
-			ttl = 0 // No expiration
-		}
-
-		return client.RestoreReplace(ctx, keyData.Key, ttl, string(keyData.Dump)).Err()
+	var syncer syncEngine
+	switch config.SyncEngine {
+	case "keyspace":
+		syncer = squirrel.NewSyncer(sourceClient, targetClient, syncOpts)
+	case "psync":
+		syncer = squirrel.NewReplicationSyncer(sourceClient, targetClient, syncOpts)
+	default:
+		return fmt.Errorf("unknown sync engine %q, expected keyspace or psync", config.SyncEngine)
 	}
 
-	// Fallback:  import by type
-	return importValueByType(ctx, client, keyData)
-}
-
-// importValueByType imports value based on Redis type
-func importValueByType(ctx context.Context, client redis.UniversalClient, keyData *KeyData) error {
-	key := keyData.Key
-
-	switch keyData.Type {
-	case "string":
-		val, ok := keyData.Value.(string)
-		if !ok {
-			return fmt.Errorf("invalid string value")
-		}
-		if err := client.Set(ctx, key, val, keyData.TTL).Err(); err != nil {
-			return err
-		}
-
-	case "list":
-		vals, ok := keyData.Value.([]interface{})
-		if !ok {
-			return fmt.Errorf("invalid list value")
-		}
-		for _, v := range vals {
-			if err := client.RPush(ctx, key, v).Err(); err != nil {
-				return err
-			}
-		}
-		if keyData.TTL > 0 {
-			client.Expire(ctx, key, keyData.TTL)
-		}
+	if config.StatusAddr != "" {
+		go serveStatus(ctx, config.StatusAddr, syncer)
+	}
 
-	case "set":
-		vals, ok := keyData.Value.([]interface{})
-		if !ok {
-			return fmt.Errorf("invalid set value")
-		}
-		for _, v := range vals {
-			if err := client.SAdd(ctx, key, v).Err(); err != nil {
-				return err
+	// Log the lag once a minute while streaming
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				status := syncer.Status()
+				if status.Phase == squirrel.SyncPhaseStreaming {
+					log.Printf("  Sync: %d applied, %d queued, %d bytes behind, oldest change %.1fs old\n", status.Applied, status.Queued, status.LagBytes, status.OldestAge)
+				}
 			}
 		}
-		if keyData.TTL > 0 {
-			client.Expire(ctx, key, keyData.TTL)
-		}
-
-	case "hash":
-		vals, ok := keyData.Value.(map[string]interface{})
-		if !ok {
-			return fmt.Errorf("invalid hash value")
-		}
-		if err := client.HSet(ctx, key, vals).Err(); err != nil {
-			return err
-		}
-		if keyData.TTL > 0 {
-			client.Expire(ctx, key, keyData.TTL)
-		}
+	}()
 
-	case "zset":
-		vals, ok := keyData.Value.([]interface{})
-		if !ok {
-			return fmt.Errorf("invalid zset value")
-		}
-		members := make([]redis.Z, 0, len(vals))
-		for _, v := range vals {
-			zval := v.(map[string]interface{})
-			members = append(members, redis.Z{
-				Score:  zval["Score"].(float64),
-				Member: zval["Member"],
-			})
-		}
-		if err := client.ZAdd(ctx, key, members...).Err(); err != nil {
-			return err
-		}
-		if keyData.TTL > 0 {
-			client.Expire(ctx, key, keyData.TTL)
-		}
-
-	default:
-		return fmt.Errorf("unsupported type:  %s", keyData.Type)
+	summary, runErr := syncer.Run(ctx)
+	status := syncer.Status()
+	log.Printf("✓ Applied changes: %d keys (%d deletions)\n", status.Applied, status.Deleted)
+	if status.Gaps > 0 {
+		log.Printf("⚠ Changes may have been missed %d times, run a migrate to reconcile\n", status.Gaps)
 	}
-
-	return nil
+	if summary.Failed > 0 {
+		log.Printf("⚠ Failed:  %d keys (see %s)\n", summary.Failed, config.ReportFile)
+	}
+	return runErr
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/seabfh/kv-squirrel/internal/ratelimit"
+	"github.com/seabfh/kv-squirrel/rdb"
+	"github.com/seabfh/kv-squirrel/squirrel"
+	"github.com/seabfh/kv-squirrel/storage"
+)
+
+// importStream streams the keys of one or more JSON dumps, RDB files, such as
+// the dump.rdb of every shard, or RESP command files into the target cluster.
+// Keys are read as they are imported, so the input is never held in memory.
+func importStream(ctx context.Context, config *Config, format string, limiter *ratelimit.Limiter) error {
+	var readers []squirrel.KeyReader
+	var rdbReaders []*squirrel.RDBReader
+	var dumpReaders []*squirrel.DumpReader
+	payloadVersion := 0
+	for _, location := range strings.Split(config.InputFile, ",") {
+		location = strings.TrimSpace(location)
+		if location == "" {
+			continue
+		}
+		input, err := storage.Open(ctx, location)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+
+		if format == formatRESP {
+			log.Printf("✓ Opened %s\n", displayLocation(location))
+			readers = append(readers, squirrel.NewRESPReader(input))
+			continue
+		}
+		if format == formatJSON {
+			reader, err := squirrel.NewDumpReader(input)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", location, err)
+			}
+			log.Printf("✓ Opened %s\n", displayLocation(location))
+
+			// The first key tells the RDB version of the payloads
+			first, err := reader.Next()
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("failed to read %s: %w", location, err)
+			}
+			if first != nil {
+				payloadVersion = max(payloadVersion, rdb.PayloadVersion(first.Dump))
+				readers = append(readers, squirrel.NewSliceReader([]squirrel.KeyData{*first}))
+			}
+			readers = append(readers, reader)
+			dumpReaders = append(dumpReaders, reader)
+			continue
+		}
+		reader, err := squirrel.NewRDBReader(input, squirrel.RDBOptions{
+			Pattern: config.Pattern,
+			DB:      config.DB,
+			Logical: !config.UseRDBDump,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", location, err)
+		}
+		log.Printf("✓ Opened %s (RDB version %d)\n", displayLocation(location), reader.Version())
+		readers = append(readers, reader)
+		rdbReaders = append(rdbReaders, reader)
+	}
+
+	var keys squirrel.KeyReader = squirrel.NewMultiReader(readers...)
+	if config.RetryReport != "" {
+		failed, err := newFailedKeyReader(keys, config.RetryReport)
+		if err != nil {
+			return err
+		}
+		log.Printf("✓ Retrying %d failed keys from %s\n", len(failed.failed), config.RetryReport)
+		keys = failed
+	}
+
+	checkpointFile := checkpointPath(config)
+	checkpoint := &Checkpoint{InputFile: config.InputFile, RetryReport: config.RetryReport}
+	if config.Resume {
+		var err error
+		checkpoint, err = loadCheckpoint(checkpointFile, config)
+		if err != nil {
+			return err
+		}
+		log.Printf("✓ Resuming from %s at record %d\n", checkpointFile, checkpoint.NextIndex)
+	}
+
+	targetClient, err := connect(ctx, "target", config.TargetAddrs, config.TargetUser, config.TargetPass, false)
+	if err != nil {
+		return err
+	}
+	defer targetClient.Close()
+
+	// RESTORE commands of a RESP file are replayed whatever -use-dump says.
+	// RDB files carry their version, RESP files only tell it key by key.
+	useDump := config.UseRDBDump || format == formatRESP
+	if useDump {
+		for _, reader := range rdbReaders {
+			payloadVersion = max(payloadVersion, reader.Version())
+		}
+		checkRDBVersion(ctx, targetClient, payloadVersion)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	gov := startGovernor(ctx, config, nil, targetClient)
+
+	report, err := squirrel.NewFailureReport(config.ReportFile, config.Resume)
+	if err != nil {
+		return err
+	}
+	defer report.Close()
+
+	importer := squirrel.NewImporter(targetClient, squirrel.ImportOptions{
+		RunOptions: runOptions(config, limiter, gov, report),
+		UseDump:    useDump,
+		Skip:       checkpoint.NextIndex,
+		Failed:     checkpoint.Failed,
+		Conflict:   config.Conflict,
+	})
+
+	log.Println("Importing keys...")
+	summary, runErr := importer.Import(ctx, keys)
+
+	if runErr != nil && !errors.Is(runErr, squirrel.ErrPartial) {
+		// Record where the import stopped so that it can be resumed
+		checkpoint.NextIndex += summary.Processed
+		checkpoint.Imported += summary.Succeeded
+		checkpoint.Failed += summary.Failed
+		if err := saveCheckpoint(checkpointFile, checkpoint); err != nil {
+			log.Printf("⚠ %v\n", err)
+		} else {
+			log.Printf("⚠ Stopped after record %d, checkpoint written to %s (rerun with -resume)\n", checkpoint.NextIndex, checkpointFile)
+		}
+	} else {
+		// A checkpoint left by an earlier run is stale once the input was imported
+		os.Remove(checkpointFile)
+	}
+
+	for _, reader := range dumpReaders {
+		if meta := reader.Metadata(); meta != nil && meta.Partial {
+			log.Printf("⚠ The input holds a partial dump: the export stopped early at %s\n", meta.FinishedAt.Format(time.RFC3339))
+		}
+	}
+	var filtered, expired int
+	for _, reader := range rdbReaders {
+		f, e := reader.Skipped()
+		filtered += f
+		expired += e
+	}
+	if filtered > 0 {
+		log.Printf("  Not matching -pattern or -db: %d keys\n", filtered)
+	}
+	if expired > 0 {
+		log.Printf("  Already expired in the RDB file: %d keys\n", expired)
+	}
+	logSummary("imported", summary, config)
+	return runErr
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// controlPollInterval is how often the control file is checked for changes
+const controlPollInterval = 2 * time.Second
+
+// Watch adjusts the limiter while a job is running until ctx is done.
+//
+// The control file is reloaded whenever it changes or SIGHUP is received.
+// SIGUSR1 halves and SIGUSR2 doubles all limits currently in effect. These
+// signals no longer stop the process while Watch runs.
+func Watch(ctx context.Context, l *Limiter, controlFile string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(controlPollInterval)
+	defer ticker.Stop()
+
+	var lastMod time.Time
+	reload := func(force bool) {
+		if controlFile == "" {
+			return
+		}
+		stat, err := os.Stat(controlFile)
+		if err != nil {
+			if force {
+				log.Printf("⚠ Failed to read rate control file: %v\n", err)
+			}
+			return
+		}
+		if !force && stat.ModTime().Equal(lastMod) {
+			return
+		}
+		lastMod = stat.ModTime()
+
+		limits, err := LoadControlFile(controlFile, l.Limits())
+		if err != nil {
+			log.Printf("⚠ Ignoring rate control file: %v\n", err)
+			return
+		}
+		l.SetLimits(limits)
+		log.Printf("Rate limits updated from %s: %s\n", controlFile, limits)
+	}
+
+	reload(false)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reload(false)
+		case sig := <-signals:
+			switch sig {
+			case syscall.SIGHUP:
+				reload(true)
+			case syscall.SIGUSR1:
+				l.SetLimits(l.Limits().Scale(0.5))
+				log.Printf("Rate limits halved: %s\n", l.Limits())
+			case syscall.SIGUSR2:
+				l.SetLimits(l.Limits().Scale(2))
+				log.Printf("Rate limits doubled: %s\n", l.Limits())
+			}
+		}
+	}
+}
+
+// LoadControlFile reads limits from a control file, starting from current.
+//
+// The file holds one "name = value" pair per line, for example:
+//
+//	keys-per-sec = 5000
+//	bytes-per-sec = 10485760
+//	node-keys-per-sec = 1000
+//	node-bytes-per-sec = 0
+//
+// Missing names keep their current value, 0 means unlimited and lines starting
+// with '#' are ignored.
+func LoadControlFile(path string, current Limits) (Limits, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return current, err
+	}
+	defer file.Close()
+
+	limits := current
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return current, fmt.Errorf("%s:%d: expected name = value", path, lineNo)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || rate < 0 {
+			return current, fmt.Errorf("%s:%d: invalid rate %q", path, lineNo, strings.TrimSpace(value))
+		}
+
+		switch strings.TrimSpace(name) {
+		case "keys-per-sec":
+			limits.KeysPerSec = rate
+		case "bytes-per-sec":
+			limits.BytesPerSec = rate
+		case "node-keys-per-sec":
+			limits.NodeKeysPerSec = rate
+		case "node-bytes-per-sec":
+			limits.NodeBytesPerSec = rate
+		default:
+			return current, fmt.Errorf("%s:%d: unknown limit %q", path, lineNo, strings.TrimSpace(name))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return current, err
+	}
+
+	return limits, nil
+}
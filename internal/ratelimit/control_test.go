@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadControlFile(t *testing.T) {
+	current := Limits{KeysPerSec: 100, BytesPerSec: 1000, NodeKeysPerSec: 10, NodeBytesPerSec: 100}
+	tests := []struct {
+		name    string
+		content string
+		want    Limits
+		err     bool
+	}{
+		{"empty", "", current, false},
+		{"all", "keys-per-sec = 5000\nbytes-per-sec=10485760\nnode-keys-per-sec = 1000\nnode-bytes-per-sec = 0\n",
+			Limits{KeysPerSec: 5000, BytesPerSec: 10485760, NodeKeysPerSec: 1000}, false},
+		{"some", "# Slow down\n\n  keys-per-sec = 50  \n", Limits{KeysPerSec: 50, BytesPerSec: 1000, NodeKeysPerSec: 10, NodeBytesPerSec: 100}, false},
+		{"fraction", "keys-per-sec = 0.5", Limits{KeysPerSec: 0.5, BytesPerSec: 1000, NodeKeysPerSec: 10, NodeBytesPerSec: 100}, false},
+		{"missing value", "keys-per-sec 50", current, true},
+		{"negative", "keys-per-sec = -1", current, true},
+		{"not a number", "keys-per-sec = fast", current, true},
+		{"unknown", "ops-per-sec = 1", current, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "limits")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadControlFile(path, current)
+			if (err != nil) != tt.err || got != tt.want {
+				t.Errorf("LoadControlFile = %+v, %v, want %+v (error: %v)", got, err, tt.want, tt.err)
+			}
+		})
+	}
+
+	if _, err := LoadControlFile(filepath.Join(t.TempDir(), "missing"), current); err == nil {
+		t.Error("LoadControlFile of a missing file succeeded, want an error")
+	}
+}
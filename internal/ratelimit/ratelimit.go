@@ -0,0 +1,184 @@
+// Package ratelimit implements token-bucket limits on keys/sec and bytes/sec,
+// applied globally and per node, that can be changed while a job is running.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limits holds the configured rates. A rate of zero means unlimited.
+type Limits struct {
+	KeysPerSec      float64
+	BytesPerSec     float64
+	NodeKeysPerSec  float64
+	NodeBytesPerSec float64
+}
+
+// String formats the limits for logging
+func (l Limits) String() string {
+	return fmt.Sprintf("keys/sec=%s bytes/sec=%s node-keys/sec=%s node-bytes/sec=%s",
+		formatRate(l.KeysPerSec), formatRate(l.BytesPerSec),
+		formatRate(l.NodeKeysPerSec), formatRate(l.NodeBytesPerSec))
+}
+
+// Scale returns the limits multiplied by factor
+func (l Limits) Scale(factor float64) Limits {
+	return Limits{
+		KeysPerSec:      l.KeysPerSec * factor,
+		BytesPerSec:     l.BytesPerSec * factor,
+		NodeKeysPerSec:  l.NodeKeysPerSec * factor,
+		NodeBytesPerSec: l.NodeBytesPerSec * factor,
+	}
+}
+
+func formatRate(rate float64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%.0f", rate)
+}
+
+// bucket is a token bucket holding at most one second worth of tokens.
+// Tokens may go negative so that costs only known after the fact (bytes read)
+// are paid back by delaying the following callers.
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, now time.Time) *bucket {
+	return &bucket{rate: rate, tokens: rate, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// reserve takes n tokens and returns how long the caller has to wait for them
+func (b *bucket) reserve(now time.Time, n float64) time.Duration {
+	if b.rate <= 0 || n <= 0 {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *bucket) setRate(now time.Time, rate float64) {
+	b.refill(now)
+	if b.rate <= 0 {
+		b.tokens = rate
+	}
+	b.rate = rate
+	b.last = now
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+}
+
+type nodeBuckets struct {
+	keys  *bucket
+	bytes *bucket
+}
+
+// Limiter enforces Limits. It is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	limits Limits
+	keys   *bucket
+	bytes  *bucket
+	nodes  map[string]*nodeBuckets
+}
+
+// New creates a limiter with the given limits
+func New(limits Limits) *Limiter {
+	now := time.Now()
+	return &Limiter{
+		limits: limits,
+		keys:   newBucket(limits.KeysPerSec, now),
+		bytes:  newBucket(limits.BytesPerSec, now),
+		nodes:  make(map[string]*nodeBuckets),
+	}
+}
+
+// Limits returns the limits currently in effect
+func (l *Limiter) Limits() Limits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limits
+}
+
+// SetLimits changes the limits of a running limiter
+func (l *Limiter) SetLimits(limits Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.limits = limits
+	l.keys.setRate(now, limits.KeysPerSec)
+	l.bytes.setRate(now, limits.BytesPerSec)
+	for _, node := range l.nodes {
+		node.keys.setRate(now, limits.NodeKeysPerSec)
+		node.bytes.setRate(now, limits.NodeBytesPerSec)
+	}
+}
+
+// Wait blocks until keys and bytes may be spent against node and the global limits.
+// An empty node only counts against the global limits.
+func (l *Limiter) Wait(ctx context.Context, node string, keys, bytes int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	wait := l.keys.reserve(now, float64(keys))
+	if d := l.bytes.reserve(now, float64(bytes)); d > wait {
+		wait = d
+	}
+	if node != "" {
+		nb, ok := l.nodes[node]
+		if !ok {
+			nb = &nodeBuckets{
+				keys:  newBucket(l.limits.NodeKeysPerSec, now),
+				bytes: newBucket(l.limits.NodeBytesPerSec, now),
+			}
+			l.nodes[node] = nb
+		}
+		if d := nb.keys.reserve(now, float64(keys)); d > wait {
+			wait = d
+		}
+		if d := nb.bytes.reserve(now, float64(bytes)); d > wait {
+			wait = d
+		}
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
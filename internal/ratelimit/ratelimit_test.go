@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name  string
+		rate  float64
+		after time.Duration // Since the previous reservation
+		n     float64
+		want  time.Duration
+	}{
+		{"unlimited", 0, 0, 1e9, 0},
+		{"within the burst", 10, 0, 10, 0},
+		{"over the burst", 10, 0, 15, 500 * time.Millisecond},
+		{"refilled", 10, time.Second, 10, 0},
+		{"refill capped at one second", 10, 10 * time.Second, 20, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBucket(tt.rate, start)
+			if tt.after > 0 {
+				// Empty the bucket first
+				b.reserve(start, tt.rate)
+			}
+			if got := b.reserve(start.Add(tt.after), tt.n); got != tt.want {
+				t.Errorf("reserve(%v) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucketDebt(t *testing.T) {
+	// Bytes read past the tokens delay the following callers
+	now := time.Now()
+	b := newBucket(100, now)
+	b.reserve(now, 300)
+	if got := b.reserve(now, 100); got != 3*time.Second {
+		t.Errorf("reserve after a debt of 200 = %v, want 3s", got)
+	}
+}
+
+func TestBucketSetRate(t *testing.T) {
+	now := time.Now()
+	b := newBucket(0, now)
+	b.setRate(now, 10)
+	if got := b.reserve(now, 10); got != 0 {
+		t.Errorf("reserve after limiting = %v, want a full burst", got)
+	}
+	b.setRate(now, 5)
+	if got := b.reserve(now, 5); got != time.Second {
+		t.Errorf("reserve after lowering the rate = %v, want 1s", got)
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	var nilLimiter *Limiter
+	if err := nilLimiter.Wait(context.Background(), "a", 1, 1); err != nil {
+		t.Errorf("Wait on a nil limiter = %v", err)
+	}
+
+	l := New(Limits{NodeKeysPerSec: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Every node has its own burst
+	for _, node := range []string{"a", "b", ""} {
+		if err := l.Wait(ctx, node, 1, 100); err != nil {
+			t.Errorf("Wait(%q) = %v, want no wait", node, err)
+		}
+	}
+	if err := l.Wait(ctx, "a", 1, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait over the node limit = %v, want %v", err, context.Canceled)
+	}
+
+	l.SetLimits(Limits{})
+	if err := l.Wait(ctx, "a", 1000, 0); err != nil {
+		t.Errorf("Wait after removing the limits = %v", err)
+	}
+}
+
+func TestLimitsScale(t *testing.T) {
+	got := Limits{KeysPerSec: 100, BytesPerSec: 0, NodeKeysPerSec: 10, NodeBytesPerSec: 4}.Scale(0.5)
+	want := Limits{KeysPerSec: 50, NodeKeysPerSec: 5, NodeBytesPerSec: 2}
+	if got != want {
+		t.Errorf("Scale(0.5) = %+v, want %+v", got, want)
+	}
+}
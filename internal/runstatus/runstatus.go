@@ -0,0 +1,131 @@
+// Package runstatus defines the exit codes of the kv-squirrel binaries and the
+// -max-failures threshold that decides when a run is aborted.
+package runstatus
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Exit codes shared by all binaries
+const (
+	ExitOK         = 0 // Every key was processed
+	ExitError      = 1 // Invalid usage or unexpected error
+	ExitPartial    = 2 // The run finished but some keys failed
+	ExitAborted    = 3 // The run was stopped by -max-failures or a hard limit
+	ExitConnection = 4 // A cluster could not be reached
+	// ExitInterrupted follows the shell convention of 128 + SIGINT
+	ExitInterrupted = 130
+)
+
+var (
+	// ErrPartial marks a run that finished with failed keys
+	ErrPartial = errors.New("some keys failed")
+
+	// ErrAborted marks a run that was stopped before finishing
+	ErrAborted = errors.New("run aborted")
+
+	// ErrInterrupted marks a run that was stopped by a signal
+	ErrInterrupted = errors.New("interrupted")
+)
+
+// ConnectionError is returned when a cluster cannot be reached
+type ConnectionError struct {
+	Cluster string
+	Err     error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("failed to connect to %s cluster: %v", e.Cluster, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode maps the error returned by a run to the process exit code
+func ExitCode(err error) int {
+	var connErr *ConnectionError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &connErr):
+		return ExitConnection
+	case errors.Is(err, ErrInterrupted):
+		return ExitInterrupted
+	case errors.Is(err, ErrAborted):
+		return ExitAborted
+	case errors.Is(err, ErrPartial):
+		return ExitPartial
+	default:
+		return ExitError
+	}
+}
+
+// FailureLimit is the -max-failures threshold: an absolute number of keys or a
+// percentage of all keys. The zero value never aborts; a limit of 0 that was
+// set aborts on the first failure.
+type FailureLimit struct {
+	Count   int
+	Percent float64
+	Limited bool // Whether a limit was set
+}
+
+// Set parses "100" or "5%", implementing flag.Value
+func (l *FailureLimit) Set(s string) error {
+	s = strings.TrimSpace(s)
+	*l = FailureLimit{}
+	if s == "" {
+		return nil
+	}
+
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		percent, err := strconv.ParseFloat(pct, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return fmt.Errorf("invalid percentage %q", s)
+		}
+		l.Percent, l.Limited = percent, true
+		return nil
+	}
+
+	count, err := strconv.Atoi(s)
+	if err != nil || count < 0 {
+		return fmt.Errorf("invalid failure count %q", s)
+	}
+	l.Count, l.Limited = count, true
+	return nil
+}
+
+// String formats the limit, implementing flag.Value
+func (l *FailureLimit) String() string {
+	switch {
+	case l == nil || !l.Limited:
+		return ""
+	case l.Percent > 0:
+		return strconv.FormatFloat(l.Percent, 'f', -1, 64) + "%"
+	default:
+		return strconv.Itoa(l.Count)
+	}
+}
+
+// Exceeded reports whether failed keys out of total break the limit
+func (l FailureLimit) Exceeded(failed, total int) bool {
+	switch {
+	case !l.Limited:
+		return false
+	case l.Percent > 0:
+		return total > 0 && float64(failed) > l.Percent*float64(total)/100
+	default:
+		return failed > l.Count
+	}
+}
+
+// Check returns an ErrAborted error once the limit is exceeded
+func (l FailureLimit) Check(failed, total int) error {
+	if !l.Exceeded(failed, total) {
+		return nil
+	}
+	return fmt.Errorf("%d of %d keys failed, exceeding -max-failures %s: %w", failed, total, l.String(), ErrAborted)
+}
@@ -0,0 +1,86 @@
+package runstatus
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestFailureLimit(t *testing.T) {
+	tests := []struct {
+		value  string
+		failed int
+		total  int
+		want   bool
+	}{
+		{"", 1000, 1000, false},
+		{"0", 0, 10, false},
+		{"0", 1, 10, true},
+		{"10", 10, 100, false},
+		{"10", 11, 100, true},
+		{"5%", 5, 100, false},
+		{"5%", 6, 100, true},
+		{"5%", 1, 0, false}, // The total is not known yet
+		{"0%", 1, 100, true},
+		{"100%", 100, 100, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d of %d", tt.value, tt.failed, tt.total), func(t *testing.T) {
+			var l FailureLimit
+			if err := l.Set(tt.value); err != nil {
+				t.Fatalf("Set(%q): %v", tt.value, err)
+			}
+			if got := l.Exceeded(tt.failed, tt.total); got != tt.want {
+				t.Errorf("Exceeded = %v, want %v", got, tt.want)
+			}
+			if err := l.Check(tt.failed, tt.total); (err != nil) != tt.want || err != nil && !errors.Is(err, ErrAborted) {
+				t.Errorf("Check = %v, want an ErrAborted error: %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailureLimitSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  FailureLimit
+		str   string
+		err   bool
+	}{
+		{"", FailureLimit{}, "", false},
+		{"0", FailureLimit{Limited: true}, "0", false},
+		{" 25 ", FailureLimit{Count: 25, Limited: true}, "25", false},
+		{"2.5%", FailureLimit{Percent: 2.5, Limited: true}, "2.5%", false},
+		{"-1", FailureLimit{}, "", true},
+		{"101%", FailureLimit{}, "", true},
+		{"many", FailureLimit{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			l := FailureLimit{Count: 7, Limited: true}
+			err := l.Set(tt.value)
+			if (err != nil) != tt.err || l != tt.want || l.String() != tt.str {
+				t.Errorf("Set(%q) = %+v (%q), %v, want %+v (%q)", tt.value, l, l.String(), err, tt.want, tt.str)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitOK},
+		{errors.New("bad flag"), ExitError},
+		{fmt.Errorf("3 keys failed: %w", ErrPartial), ExitPartial},
+		{fmt.Errorf("too many failures: %w", ErrAborted), ExitAborted},
+		{fmt.Errorf("stopped: %w", ErrInterrupted), ExitInterrupted},
+		{&ConnectionError{Cluster: "source", Err: errors.New("refused")}, ExitConnection},
+	}
+	for _, tt := range tests {
+		if got := ExitCode(tt.err); got != tt.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package rdb
+
+import "hash/crc64"
+
+// crcTable is the reflected Jones polynomial used by Redis for RDB files and
+// DUMP payloads
+var crcTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+// UpdateChecksum adds p to a Redis CRC64 checksum. Redis does not invert the
+// checksum before and after each update, unlike hash/crc64.
+func UpdateChecksum(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
+
+// Checksum returns the Redis CRC64 checksum of p
+func Checksum(p []byte) uint64 {
+	return UpdateChecksum(0, p)
+}
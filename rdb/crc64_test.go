@@ -0,0 +1,23 @@
+package rdb
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint64
+	}{
+		{"", 0},
+		// Check value of crc64.c in Redis
+		{"123456789", 0xe9c6d914c4b8d9ca},
+	}
+	for _, tt := range tests {
+		if got := Checksum([]byte(tt.data)); got != tt.want {
+			t.Errorf("Checksum(%q) = %#x, want %#x", tt.data, got, tt.want)
+		}
+	}
+
+	if got := UpdateChecksum(Checksum([]byte("1234")), []byte("56789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("UpdateChecksum in two parts = %#x, want %#x", got, uint64(0xe9c6d914c4b8d9ca))
+	}
+}
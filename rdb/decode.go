@@ -0,0 +1,362 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Value is a decoded value in the shape of its Redis type
+type Value struct {
+	Type     string            // string, list, set, zset, hash or stream, as returned by TYPE
+	String   string            // Value of a string
+	Elements []string          // Elements of a list in order, or members of a set
+	Fields   map[string]string // Fields of a hash
+	Members  []Member          // Members of a sorted set, in ZRANGE order
+	Stream   *Stream           // Entries and consumer groups of a stream
+}
+
+// Member is a member of a sorted set
+type Member struct {
+	Member string
+	Score  float64
+}
+
+// TypeName returns the name TYPE reports for keys of an RDB value type
+func TypeName(valueType byte) string {
+	switch valueType {
+	case TypeString:
+		return "string"
+	case TypeList, TypeListZiplist, TypeListQuicklist, TypeListQuicklist2:
+		return "list"
+	case TypeSet, TypeSetIntset, TypeSetListpack:
+		return "set"
+	case TypeZSet, TypeZSet2, TypeZSetZiplist, TypeZSetListpack:
+		return "zset"
+	case TypeHash, TypeHashZipmap, TypeHashZiplist, TypeHashListpack, TypeHashMetadataPreGA,
+		TypeHashListpackExPreGA, TypeHashMetadata, TypeHashListpackEx:
+		return "hash"
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		return "stream"
+	case TypeModule, TypeModule2:
+		return "module"
+	default:
+		return "unknown"
+	}
+}
+
+// Decode decodes the value of the entry
+func (e *Entry) Decode() (*Value, error) {
+	return DecodeValue(e.Type, e.Value)
+}
+
+// DecodeValue decodes a serialized value of the given type. Module values
+// return an *UnsupportedTypeError; field expirations of hashes are not kept.
+func DecodeValue(valueType byte, value []byte) (*Value, error) {
+	d := &Reader{r: bufio.NewReader(bytes.NewReader(value))}
+	v, err := d.readValue(valueType)
+	if err != nil {
+		return nil, d.unexpected(err)
+	}
+	if _, err := d.r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("rdb: trailing data after %s value", v.Type)
+	}
+	return v, nil
+}
+
+// DecodePayload decodes the value of a DUMP payload. Payloads whose checksum
+// does not match return ErrChecksum, payloads of an RDB version newer than
+// MaxVersion are rejected.
+func DecodePayload(payload []byte) (*Value, error) {
+	valueType, value, version, err := ParsePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	if version > MaxVersion {
+		return nil, fmt.Errorf("rdb: DUMP payload has RDB version %d, newer than %d", version, MaxVersion)
+	}
+	return DecodeValue(valueType, value)
+}
+
+// readValue decodes a serialized value of the given type
+func (d *Reader) readValue(valueType byte) (*Value, error) {
+	v := &Value{Type: TypeName(valueType)}
+	var err error
+
+	switch valueType {
+	case TypeString:
+		var s []byte
+		s, err = d.readString()
+		v.String = string(s)
+
+	case TypeList, TypeSet:
+		v.Elements, err = d.readStrings(1)
+
+	case TypeListZiplist:
+		v.Elements, err = d.readEncoded(ziplistEntries)
+
+	case TypeListQuicklist:
+		var n uint64
+		if n, err = d.readLength(); err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			entries, err := d.readEncoded(ziplistEntries)
+			if err != nil {
+				return nil, err
+			}
+			v.Elements = append(v.Elements, entries...)
+		}
+
+	case TypeListQuicklist2:
+		var n uint64
+		if n, err = d.readLength(); err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			container, err := d.readLength()
+			if err != nil {
+				return nil, err
+			}
+			if container == quicklistNodePlain {
+				element, err := d.readString()
+				if err != nil {
+					return nil, err
+				}
+				v.Elements = append(v.Elements, string(element))
+				continue
+			}
+			entries, err := d.readEncoded(listpackEntries)
+			if err != nil {
+				return nil, err
+			}
+			v.Elements = append(v.Elements, entries...)
+		}
+
+	case TypeSetIntset:
+		v.Elements, err = d.readEncoded(intsetEntries)
+
+	case TypeSetListpack:
+		v.Elements, err = d.readEncoded(listpackEntries)
+
+	case TypeZSet, TypeZSet2:
+		v.Members, err = d.readZSet(valueType)
+
+	case TypeZSetZiplist, TypeZSetListpack:
+		decode := listpackEntries
+		if valueType == TypeZSetZiplist {
+			decode = ziplistEntries
+		}
+		var entries []string
+		if entries, err = d.readEncoded(decode); err != nil {
+			return nil, err
+		}
+		v.Members, err = scoredMembers(entries)
+
+	case TypeHash:
+		var pairs []string
+		if pairs, err = d.readStrings(2); err != nil {
+			return nil, err
+		}
+		v.Fields, err = hashFields(pairs, 2)
+
+	case TypeHashZipmap, TypeHashZiplist, TypeHashListpack:
+		decode := listpackEntries
+		switch valueType {
+		case TypeHashZipmap:
+			decode = zipmapEntries
+		case TypeHashZiplist:
+			decode = ziplistEntries
+		}
+		var pairs []string
+		if pairs, err = d.readEncoded(decode); err != nil {
+			return nil, err
+		}
+		v.Fields, err = hashFields(pairs, 2)
+
+	case TypeHashListpackEx, TypeHashListpackExPreGA:
+		if valueType == TypeHashListpackEx {
+			// Earliest field expiration
+			if _, err := d.read(8); err != nil {
+				return nil, err
+			}
+		}
+		// Field, value and expiration triples
+		var triples []string
+		if triples, err = d.readEncoded(listpackEntries); err != nil {
+			return nil, err
+		}
+		v.Fields, err = hashFields(triples, 3)
+
+	case TypeHashMetadata, TypeHashMetadataPreGA:
+		v.Fields, err = d.readHashMetadata(valueType)
+
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		v.Stream, err = d.readStream(valueType)
+
+	default:
+		return nil, &UnsupportedTypeError{Type: valueType}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// readStrings reads a length followed by length*per strings
+func (d *Reader) readStrings(per uint64) ([]string, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for i := uint64(0); i < n*per; i++ {
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, string(s))
+	}
+	return values, nil
+}
+
+// readEncoded reads a string holding a ziplist, listpack, intset or zipmap and decodes it
+func (d *Reader) readEncoded(decode func([]byte) ([]string, error)) ([]string, error) {
+	b, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	return decode(b)
+}
+
+// readZSet reads a sorted set saved as a skiplist, with ASCII or binary scores
+func (d *Reader) readZSet(valueType byte) ([]Member, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	var members []Member
+	for i := uint64(0); i < n; i++ {
+		member, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if valueType == TypeZSet2 {
+			b, err := d.read(8)
+			if err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		} else if score, err = d.readASCIIDouble(); err != nil {
+			return nil, err
+		}
+		members = append(members, Member{Member: string(member), Score: score})
+	}
+
+	// Skiplists are saved from the highest score down
+	sortMembers(members)
+	return members, nil
+}
+
+// readASCIIDouble reads a score saved as text
+func (d *Reader) readASCIIDouble() (float64, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case zsetScoreNaN:
+		return math.NaN(), nil
+	case zsetScorePosInf:
+		return math.Inf(1), nil
+	case zsetScoreNegInf:
+		return math.Inf(-1), nil
+	}
+	b, err := d.read(int(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// readHashMetadata reads a hash with field expirations
+func (d *Reader) readHashMetadata(valueType byte) (map[string]string, error) {
+	if valueType == TypeHashMetadata {
+		// Earliest field expiration
+		if _, err := d.read(8); err != nil {
+			return nil, err
+		}
+	}
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, n)
+	for i := uint64(0); i < n; i++ {
+		if valueType == TypeHashMetadata {
+			_, err = d.readLength()
+		} else {
+			_, err = d.read(8)
+		}
+		if err != nil {
+			return nil, err
+		}
+		field, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		fields[string(field)] = string(value)
+	}
+	return fields, nil
+}
+
+// scoredMembers turns alternating members and scores into sorted set members
+func scoredMembers(entries []string) ([]Member, error) {
+	if len(entries)%2 != 0 {
+		return nil, fmt.Errorf("rdb: sorted set with an odd number of entries")
+	}
+	members := make([]Member, 0, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		score, err := strconv.ParseFloat(entries[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("rdb: invalid score %q", entries[i+1])
+		}
+		members = append(members, Member{Member: entries[i], Score: score})
+	}
+	sortMembers(members)
+	return members, nil
+}
+
+// hashFields turns groups of per entries, each starting with a field and its value, into a map
+func hashFields(entries []string, per int) (map[string]string, error) {
+	if len(entries)%per != 0 {
+		return nil, fmt.Errorf("rdb: hash with an incomplete field")
+	}
+	fields := make(map[string]string, len(entries)/per)
+	for i := 0; i < len(entries); i += per {
+		fields[entries[i]] = entries[i+1]
+	}
+	return fields, nil
+}
+
+// sortMembers orders members by score, then member, as ZRANGE does
+func sortMembers(members []Member) {
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score
+		}
+		return members[i].Member < members[j].Member
+	})
+}
@@ -0,0 +1,67 @@
+package rdb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodePayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		version int
+		want    string
+	}{
+		// DUMP mykey after SET mykey 10, from the DUMP documentation of Redis 7
+		{"redis 7", "\x00\xc0\n\n\x00n\x9fWE\x0e\xaec\xbb", 10, "10"},
+		// The same key dumped by Redis 3
+		{"redis 3", "\x00\xc0\n\x06\x00\xf8r?\xc5\xfb\xfb_(", 6, "10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := []byte(tt.payload)
+			if v := PayloadVersion(payload); v != tt.version {
+				t.Errorf("PayloadVersion = %d, want %d", v, tt.version)
+			}
+			got, err := DecodePayload(payload)
+			if err != nil || got.Type != "string" || got.String != tt.want {
+				t.Fatalf("DecodePayload = %+v, %v, want string %q", got, err, tt.want)
+			}
+
+			// Any change to the payload breaks its checksum
+			payload[1] ^= 1
+			if _, err := DecodePayload(payload); !errors.Is(err, ErrChecksum) {
+				t.Errorf("DecodePayload of a corrupted payload = %v, want %v", err, ErrChecksum)
+			}
+		})
+	}
+}
+
+func TestDecodePayloadOfFixtures(t *testing.T) {
+	for _, fixture := range []string{"ziplist_with_integers", "intset_64", "hash_as_ziplist", "sorted_set_as_ziplist"} {
+		t.Run(fixture, func(t *testing.T) {
+			entry, want := fixtureValue(t, fixture)
+			got, err := DecodePayload(entry.Payload(MaxVersion))
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("DecodePayload = %+v, %v, want %+v", got, err, want)
+			}
+		})
+	}
+}
+
+func TestDecodePayloadErrors(t *testing.T) {
+	var unsupported *UnsupportedTypeError
+	if _, err := DecodePayload(DumpPayload(TypeModule2, []byte{1, 2, 3}, 9)); !errors.As(err, &unsupported) {
+		t.Errorf("DecodePayload of a module value = %v, want an UnsupportedTypeError", err)
+	}
+	if _, err := DecodePayload(DumpPayload(TypeString, appendString(nil, "v"), MaxVersion+1)); err == nil {
+		t.Error("DecodePayload of a newer RDB version succeeded, want an error")
+	}
+	if _, err := DecodePayload([]byte("\x00\x01v")); err == nil {
+		t.Error("DecodePayload of a truncated payload succeeded, want an error")
+	}
+	if _, err := DecodePayload(DumpPayload(TypeString, append(appendString(nil, "v"), 0), 9)); err == nil {
+		t.Error("DecodePayload with trailing data succeeded, want an error")
+	}
+}
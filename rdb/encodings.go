@@ -0,0 +1,308 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var (
+	errZiplist  = errors.New("rdb: invalid ziplist")
+	errListpack = errors.New("rdb: invalid listpack")
+	errIntset   = errors.New("rdb: invalid intset")
+	errZipmap   = errors.New("rdb: invalid zipmap")
+)
+
+// ziplistEntries returns the entries of a ziplist, integers formatted in decimal
+func ziplistEntries(b []byte) ([]string, error) {
+	// Total bytes, offset of the last entry and number of entries
+	const headerSize = 10
+	if len(b) < headerSize+1 {
+		return nil, errZiplist
+	}
+
+	var entries []string
+	pos := headerSize
+	for {
+		if pos >= len(b) {
+			return nil, errZiplist
+		}
+		if b[pos] == 0xFF {
+			return entries, nil
+		}
+
+		// Length of the previous entry
+		if b[pos] == 0xFE {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(b) {
+			return nil, errZiplist
+		}
+
+		enc := b[pos]
+		var n int
+		switch enc >> 6 {
+		case 0:
+			n, pos = int(enc&0x3f), pos+1
+		case 1:
+			if pos+2 > len(b) {
+				return nil, errZiplist
+			}
+			n, pos = int(enc&0x3f)<<8|int(b[pos+1]), pos+2
+		case 2:
+			if pos+5 > len(b) {
+				return nil, errZiplist
+			}
+			n, pos = int(binary.BigEndian.Uint32(b[pos+1:pos+5])), pos+5
+		default:
+			v, size, err := ziplistInt(b[pos+1:], enc)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, strconv.FormatInt(v, 10))
+			pos += 1 + size
+			continue
+		}
+
+		if n < 0 || pos+n > len(b) {
+			return nil, errZiplist
+		}
+		entries = append(entries, string(b[pos:pos+n]))
+		pos += n
+	}
+}
+
+// ziplistInt decodes an integer entry and returns it with the size of its data
+func ziplistInt(b []byte, enc byte) (int64, int, error) {
+	size := 0
+	switch enc {
+	case 0xC0:
+		size = 2
+	case 0xD0:
+		size = 4
+	case 0xE0:
+		size = 8
+	case 0xF0:
+		size = 3
+	case 0xFE:
+		size = 1
+	default:
+		// 4 bit immediate integer between 0 and 12
+		if enc >= 0xF1 && enc <= 0xFD {
+			return int64(enc&0x0f) - 1, 0, nil
+		}
+		return 0, 0, errZiplist
+	}
+	if len(b) < size {
+		return 0, 0, errZiplist
+	}
+
+	switch size {
+	case 1:
+		return int64(int8(b[0])), size, nil
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(b))), size, nil
+	case 3:
+		return int24(b), size, nil
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(b))), size, nil
+	default:
+		return int64(binary.LittleEndian.Uint64(b)), size, nil
+	}
+}
+
+// listpackEntries returns the entries of a listpack, integers formatted in decimal
+func listpackEntries(b []byte) ([]string, error) {
+	// Total bytes and number of entries
+	const headerSize = 6
+	if len(b) < headerSize+1 {
+		return nil, errListpack
+	}
+
+	var entries []string
+	pos := headerSize
+	for {
+		if pos >= len(b) {
+			return nil, errListpack
+		}
+		enc := b[pos]
+		if enc == 0xFF {
+			return entries, nil
+		}
+
+		// size is the length of the encoding and the data, followed by its backlen
+		var size int
+		var str []byte
+		var v int64
+		isString := false
+		need := func(n int) bool { return pos+n <= len(b) }
+
+		switch {
+		case enc&0x80 == 0:
+			// 7 bit unsigned integer
+			v, size = int64(enc&0x7f), 1
+		case enc&0xC0 == 0x80:
+			n := int(enc & 0x3f)
+			if !need(1 + n) {
+				return nil, errListpack
+			}
+			str, size, isString = b[pos+1:pos+1+n], 1+n, true
+		case enc&0xE0 == 0xC0:
+			// 13 bit signed integer
+			if !need(2) {
+				return nil, errListpack
+			}
+			v = int64(enc&0x1f)<<8 | int64(b[pos+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			size = 2
+		case enc&0xF0 == 0xE0:
+			if !need(2) {
+				return nil, errListpack
+			}
+			n := int(enc&0x0f)<<8 | int(b[pos+1])
+			if !need(2 + n) {
+				return nil, errListpack
+			}
+			str, size, isString = b[pos+2:pos+2+n], 2+n, true
+		case enc == 0xF0:
+			if !need(5) {
+				return nil, errListpack
+			}
+			n := int(binary.LittleEndian.Uint32(b[pos+1 : pos+5]))
+			if n < 0 || !need(5+n) {
+				return nil, errListpack
+			}
+			str, size, isString = b[pos+5:pos+5+n], 5+n, true
+		case enc >= 0xF1 && enc <= 0xF4:
+			// 16, 24, 32 and 64 bit signed integers
+			width := [...]int{2, 3, 4, 8}[enc-0xF1]
+			if !need(1 + width) {
+				return nil, errListpack
+			}
+			data := b[pos+1 : pos+1+width]
+			switch width {
+			case 2:
+				v = int64(int16(binary.LittleEndian.Uint16(data)))
+			case 3:
+				v = int24(data)
+			case 4:
+				v = int64(int32(binary.LittleEndian.Uint32(data)))
+			default:
+				v = int64(binary.LittleEndian.Uint64(data))
+			}
+			size = 1 + width
+		default:
+			return nil, errListpack
+		}
+
+		if isString {
+			entries = append(entries, string(str))
+		} else {
+			entries = append(entries, strconv.FormatInt(v, 10))
+		}
+		pos += size + backlenSize(size)
+	}
+}
+
+// backlenSize returns the size of the backlen following a listpack entry of size bytes
+func backlenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// int24 decodes a signed little endian 24 bit integer
+func int24(b []byte) int64 {
+	return int64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8)
+}
+
+// intsetEntries returns the members of an intset formatted in decimal
+func intsetEntries(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, errIntset
+	}
+	width := int(binary.LittleEndian.Uint32(b))
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	if (width != 2 && width != 4 && width != 8) || n < 0 || len(b) != 8+n*width {
+		return nil, errIntset
+	}
+
+	entries := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		data := b[8+i*width:]
+		var v int64
+		switch width {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(data)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(data)))
+		default:
+			v = int64(binary.LittleEndian.Uint64(data))
+		}
+		entries = append(entries, strconv.FormatInt(v, 10))
+	}
+	return entries, nil
+}
+
+// zipmapEntries returns the alternating fields and values of a zipmap
+func zipmapEntries(b []byte) ([]string, error) {
+	var entries []string
+	pos := 1 // Number of entries, unreliable above 253
+	readLen := func() (int, bool) {
+		if pos >= len(b) {
+			return 0, false
+		}
+		switch n := b[pos]; {
+		case n < 254:
+			pos++
+			return int(n), true
+		case n == 254 && pos+5 <= len(b):
+			l := int(binary.LittleEndian.Uint32(b[pos+1:]))
+			pos += 5
+			return l, l >= 0
+		default:
+			return 0, false
+		}
+	}
+
+	for {
+		if pos >= len(b) {
+			return nil, errZipmap
+		}
+		if b[pos] == 0xFF {
+			return entries, nil
+		}
+
+		n, ok := readLen()
+		if !ok || pos+n > len(b) {
+			return nil, errZipmap
+		}
+		entries = append(entries, string(b[pos:pos+n]))
+		pos += n
+
+		n, ok = readLen()
+		if !ok || pos+1 > len(b) {
+			return nil, errZipmap
+		}
+		free := int(b[pos])
+		pos++
+		if pos+n+free > len(b) {
+			return nil, errZipmap
+		}
+		entries = append(entries, string(b[pos:pos+n]))
+		pos += n + free
+	}
+}
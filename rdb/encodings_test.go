@@ -0,0 +1,129 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeEncodedFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    Value
+	}{
+		{"ziplist_that_doesnt_compress", Value{Type: "list", Elements: []string{
+			"aj2410", "cc953a17a8e096e76a44169ad3f9ac87c5f8248a403274416179aa9fbd852344"}}},
+		{"ziplist_that_compresses_easily", Value{Type: "list", Elements: []string{
+			strings.Repeat("a", 6), strings.Repeat("a", 12), strings.Repeat("a", 18),
+			strings.Repeat("a", 24), strings.Repeat("a", 30), strings.Repeat("a", 36)}}},
+		{"ziplist_with_integers", Value{Type: "list", Elements: []string{
+			"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "-2", "13", "25", "-61", "63",
+			"16380", "-16000", "65535", "-65523", "4194304", "9223372036854775807"}}},
+		{"rdb_v7_list_quicklist", Value{Type: "list", Elements: []string{"bar", "baz", "boo"}}},
+		{"intset_16", Value{Type: "set", Elements: []string{"32764", "32765", "32766"}}},
+		{"intset_32", Value{Type: "set", Elements: []string{"2147418108", "2147418109", "2147418110"}}},
+		{"intset_64", Value{Type: "set", Elements: []string{
+			"9223090557583032316", "9223090557583032317", "9223090557583032318"}}},
+		{"regular_set", Value{Type: "set", Elements: []string{"beta", "delta", "alpha", "phi", "gamma", "kappa"}}},
+		{"zipmap_that_doesnt_compress", Value{Type: "hash", Fields: map[string]string{"MKD1G6": "2", "YNNXK": "F7TI"}}},
+		{"zipmap_that_compresses_easily", Value{Type: "hash", Fields: map[string]string{
+			"a": "aa", "aa": "aaaa", "aaaaa": "aaaaaaaaaaaaaa"}}},
+		{"hash_as_ziplist", Value{Type: "hash", Fields: map[string]string{
+			"a": "aa", "aa": "aaaa", "aaaaa": "aaaaaaaaaaaaaa"}}},
+		{"sorted_set_as_ziplist", Value{Type: "zset", Members: []Member{
+			{"8b6ba6718a786daefa69438148361901", 1},
+			{"cb7a24bb7528f934b841b34c3a73e0c7", 2.37},
+			{"523af537946b79c4f8369ed39ba78605", 3.423}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			_, got := fixtureValue(t, tt.fixture)
+			if got.Type != tt.want.Type ||
+				len(got.Elements)+len(tt.want.Elements) > 0 && !reflect.DeepEqual(got.Elements, tt.want.Elements) ||
+				len(got.Fields)+len(tt.want.Fields) > 0 && !reflect.DeepEqual(got.Fields, tt.want.Fields) ||
+				len(got.Members)+len(tt.want.Members) > 0 && !reflect.DeepEqual(got.Members, tt.want.Members) {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// listpack builds a listpack of encoded entries, each followed by a backlen
+func listpack(entries ...[]byte) []byte {
+	b := make([]byte, 6) // Total bytes and number of entries are not read
+	for _, entry := range entries {
+		b = append(b, entry...)
+		b = append(b, make([]byte, backlenSize(len(entry)))...)
+	}
+	return append(b, 0xFF)
+}
+
+func TestListpackEntries(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	tests := []struct {
+		name string
+		in   []byte
+		want []string
+	}{
+		{"empty", listpack(), nil},
+		{"7 bit integer", listpack([]byte{0x05}, []byte{0x7f}), []string{"5", "127"}},
+		{"6 bit string", listpack(append([]byte{0x83}, "abc"...)), []string{"abc"}},
+		{"13 bit integer", listpack([]byte{0xDF, 0xFF}, []byte{0xCF, 0xFF}), []string{"-1", "4095"}},
+		{"12 bit string", listpack(append([]byte{0xE0, 100}, long...)), []string{long}},
+		{"32 bit string", listpack(append([]byte{0xF0, 3, 0, 0, 0}, "abc"...)), []string{"abc"}},
+		{"16 bit integer", listpack([]byte{0xF1, 0x00, 0x80}), []string{"-32768"}},
+		{"24 bit integer", listpack([]byte{0xF2, 0xFF, 0xFF, 0x7F}, []byte{0xF2, 0x00, 0x00, 0x80}), []string{"8388607", "-8388608"}},
+		{"32 bit integer", listpack([]byte{0xF3, 0x00, 0x00, 0x00, 0x80}), []string{"-2147483648"}},
+		{"64 bit integer", listpack([]byte{0xF4, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F}), []string{"9223372036854775807"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := listpackEntries(tt.in)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listpackEntries = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodingErrors(t *testing.T) {
+	truncated := listpack(append([]byte{0x83}, "abc"...))
+	tests := []struct {
+		name   string
+		decode func([]byte) ([]string, error)
+		in     []byte
+	}{
+		{"listpack without end", listpackEntries, truncated[:len(truncated)-1]},
+		{"listpack with short string", listpackEntries, truncated[:8]},
+		{"listpack with unknown encoding", listpackEntries, listpack([]byte{0xF5})},
+		{"intset with bad width", intsetEntries, []byte{3, 0, 0, 0, 1, 0, 0, 0, 1, 2, 3}},
+		{"intset with missing members", intsetEntries, []byte{2, 0, 0, 0, 2, 0, 0, 0, 1, 0}},
+		{"zipmap without end", zipmapEntries, []byte{1, 1, 'a', 1, 0, 'b'}},
+		{"ziplist too short", ziplistEntries, []byte{11, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.decode(tt.in); err == nil {
+				t.Errorf("decoded %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestZiplistTruncated(t *testing.T) {
+	entry, _ := fixtureValue(t, "ziplist_that_doesnt_compress")
+	// The value is a string holding the ziplist
+	d := &Reader{r: bufio.NewReader(bytes.NewReader(entry.Value))}
+	zl, err := d.readString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ziplistEntries(zl[:len(zl)-1]); err == nil {
+		t.Errorf("decoded %q from a truncated ziplist, want an error", got)
+	}
+	if got, err := ziplistEntries(zl); err != nil || len(got) != 2 {
+		t.Errorf("ziplistEntries = %q, %v, want 2 entries", got, err)
+	}
+}
@@ -0,0 +1,54 @@
+package rdb
+
+import "errors"
+
+// errLZF is returned for compressed strings that do not decompress to their recorded length
+var errLZF = errors.New("rdb: invalid LZF compressed string")
+
+// lzfDecompress expands an LZF compressed string of known uncompressed length
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 32 {
+			// Literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > outLen {
+				return nil, errLZF
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		// Back reference
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errLZF
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLZF
+		}
+		ref := len(out) - ((ctrl & 0x1f) << 8) - int(in[i]) - 1
+		i++
+		n += 2
+		if ref < 0 || len(out)+n > outLen {
+			return nil, errLZF
+		}
+		// Byte by byte, the reference may overlap the output
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, errLZF
+	}
+	return out, nil
+}
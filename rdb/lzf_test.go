@@ -0,0 +1,40 @@
+package rdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLZFDecompress(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		outLen int
+		want   string
+		err    bool
+	}{
+		{"literal", "\x02abc", 3, "abc", false},
+		// Three literal bytes, then 6 bytes from 3 back, overlapping the output
+		{"back reference", "\x02abc\x80\x02", 9, "abcabcabc", false},
+		// A length of 7 continues in the next byte: 7+3+2 bytes from 1 back
+		{"long back reference", "\x00a\xe0\x03\x00", 13, strings.Repeat("a", 13), false},
+		{"truncated literal", "\x05abc", 6, "", true},
+		{"reference before start", "\x00a\x20\x05", 4, "", true},
+		{"longer than recorded", "\x02abc", 2, "", true},
+		{"shorter than recorded", "\x02abc", 4, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lzfDecompress([]byte(tt.in), tt.outLen)
+			if tt.err {
+				if err == nil {
+					t.Errorf("lzfDecompress = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("lzfDecompress = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+// Package rdb reads Redis RDB files and the RDB transfer of a replication
+// stream, and builds DUMP payloads out of their values so that keys can be
+// written with RESTORE.
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Value types of the RDB format
+const (
+	TypeString              = 0
+	TypeList                = 1
+	TypeSet                 = 2
+	TypeZSet                = 3
+	TypeHash                = 4
+	TypeZSet2               = 5 // Binary scores
+	TypeModule              = 6 // Not supported: its layout is only known to the module
+	TypeModule2             = 7
+	TypeHashZipmap          = 9
+	TypeListZiplist         = 10
+	TypeSetIntset           = 11
+	TypeZSetZiplist         = 12
+	TypeHashZiplist         = 13
+	TypeListQuicklist       = 14
+	TypeStreamListpacks     = 15
+	TypeHashListpack        = 16
+	TypeZSetListpack        = 17
+	TypeListQuicklist2      = 18
+	TypeStreamListpacks2    = 19
+	TypeSetListpack         = 20
+	TypeStreamListpacks3    = 21
+	TypeHashMetadataPreGA   = 22 // Hash field expiration, Redis 7.4 release candidates
+	TypeHashListpackExPreGA = 23
+	TypeHashMetadata        = 24 // Hash field expiration
+	TypeHashListpackEx      = 25
+)
+
+// Opcodes between the keys of an RDB file
+const (
+	opcodeSlotInfo      = 0xF4
+	opcodeFunction2     = 0xF5
+	opcodeFunctionPreGA = 0xF6
+	opcodeModuleAux     = 0xF7
+	opcodeIdle          = 0xF8
+	opcodeFreq          = 0xF9
+	opcodeAux           = 0xFA
+	opcodeResizeDB      = 0xFB
+	opcodeExpireTimeMs  = 0xFC
+	opcodeExpireTime    = 0xFD
+	opcodeSelectDB      = 0xFE
+	opcodeEOF           = 0xFF
+)
+
+// Opcodes of values saved by modules
+const (
+	moduleOpcodeEOF    = 0
+	moduleOpcodeSInt   = 1
+	moduleOpcodeUInt   = 2
+	moduleOpcodeFloat  = 3
+	moduleOpcodeDouble = 4
+	moduleOpcodeString = 5
+)
+
+// Special string encodings
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+const (
+	// MaxVersion is the newest RDB version that can be read
+	MaxVersion = 12
+
+	quicklistNodePlain = 1
+	zsetScoreNaN       = 253
+	zsetScorePosInf    = 254
+	zsetScoreNegInf    = 255
+	streamIDSize       = 16
+	dumpFooterSize     = 10 // RDB version and CRC64 at the end of a DUMP payload
+)
+
+// ErrChecksum is returned when the checksum of a file or DUMP payload does not match its content
+var ErrChecksum = errors.New("rdb: checksum mismatch")
+
+// UnsupportedTypeError is returned for values whose layout cannot be read,
+// such as module types saved without module opcodes
+type UnsupportedTypeError struct {
+	Type byte
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("rdb: unsupported value type %d", e.Type)
+}
+
+// DumpPayload builds the DUMP payload of a serialized value, to be written
+// with RESTORE: the type, the value, the RDB version and a CRC64 checksum
+func DumpPayload(valueType byte, value []byte, version int) []byte {
+	payload := make([]byte, 0, 1+len(value)+dumpFooterSize)
+	payload = append(payload, valueType)
+	payload = append(payload, value...)
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(version))
+	return binary.LittleEndian.AppendUint64(payload, Checksum(payload))
+}
+
+// ParsePayload splits a DUMP payload into the value type, the serialized
+// value and the RDB version, after verifying its checksum
+func ParsePayload(payload []byte) (valueType byte, value []byte, version int, err error) {
+	if len(payload) < 1+dumpFooterSize {
+		return 0, nil, 0, fmt.Errorf("rdb: DUMP payload too short")
+	}
+	footer := len(payload) - dumpFooterSize
+	version = int(binary.LittleEndian.Uint16(payload[footer:]))
+	expected := binary.LittleEndian.Uint64(payload[footer+2:])
+	if Checksum(payload[:footer+2]) != expected {
+		return 0, nil, 0, ErrChecksum
+	}
+	return payload[0], payload[1:footer], version, nil
+}
+
+// PayloadVersion returns the RDB version of a DUMP payload without verifying
+// its checksum, or 0 when the payload is too short
+func PayloadVersion(payload []byte) int {
+	if len(payload) < 1+dumpFooterSize {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint16(payload[len(payload)-dumpFooterSize:]))
+}
@@ -0,0 +1,642 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Entry is a key read from an RDB file
+type Entry struct {
+	DB       int
+	Key      string
+	Type     byte
+	Value    []byte // Serialized value as in a DUMP payload, without the type
+	ExpireAt int64  // Unix time in milliseconds, 0 when the key does not expire
+}
+
+// Payload returns the DUMP payload of the entry for RESTORE on a server that
+// reads the given RDB version
+func (e *Entry) Payload(version int) []byte {
+	return DumpPayload(e.Type, e.Value, version)
+}
+
+// Reader streams the keys out of an RDB file. Values are kept serialized so
+// that they can be restored as they are.
+type Reader struct {
+	r         *bufio.Reader
+	version   int
+	crc       uint64
+	capturing bool
+	capture   []byte
+	db        int
+	aux       map[string]string
+	done      bool
+}
+
+// NewReader reads the RDB header from r. When r is a *bufio.Reader it is used
+// directly, so that nothing past the end of the RDB data is consumed.
+func NewReader(r io.Reader) (*Reader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(r, 64<<10)
+	}
+	d := &Reader{r: br, aux: make(map[string]string)}
+
+	header, err := d.read(9)
+	if err != nil {
+		return nil, fmt.Errorf("rdb: failed to read header: %w", err)
+	}
+	if string(header[:5]) != "REDIS" {
+		return nil, fmt.Errorf("rdb: not an RDB file")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > MaxVersion {
+		return nil, fmt.Errorf("rdb: unsupported RDB version %q", header[5:])
+	}
+	d.version = version
+	return d, nil
+}
+
+// Version returns the RDB version of the file
+func (d *Reader) Version() int {
+	return d.version
+}
+
+// Aux returns the auxiliary fields read so far, such as redis-ver
+func (d *Reader) Aux() map[string]string {
+	return d.aux
+}
+
+// Next returns the next key, or io.EOF after the last one. The checksum of the
+// file is verified when the end is reached.
+func (d *Reader) Next() (*Entry, error) {
+	var expireAt int64
+	for !d.done {
+		op, err := d.readByte()
+		if err != nil {
+			return nil, d.unexpected(err)
+		}
+
+		switch op {
+		case opcodeAux:
+			key, err := d.readString()
+			if err != nil {
+				return nil, d.unexpected(err)
+			}
+			value, err := d.readString()
+			if err != nil {
+				return nil, d.unexpected(err)
+			}
+			d.aux[string(key)] = string(value)
+
+		case opcodeResizeDB:
+			if err := d.skipLengths(2); err != nil {
+				return nil, d.unexpected(err)
+			}
+
+		case opcodeSlotInfo:
+			// Slot, its size and the number of its keys with an expiry
+			if err := d.skipLengths(3); err != nil {
+				return nil, d.unexpected(err)
+			}
+
+		case opcodeSelectDB:
+			db, err := d.readLength()
+			if err != nil {
+				return nil, d.unexpected(err)
+			}
+			d.db = int(db)
+
+		case opcodeExpireTime:
+			b, err := d.read(4)
+			if err != nil {
+				return nil, d.unexpected(err)
+			}
+			expireAt = int64(binary.LittleEndian.Uint32(b)) * 1000
+
+		case opcodeExpireTimeMs:
+			b, err := d.read(8)
+			if err != nil {
+				return nil, d.unexpected(err)
+			}
+			expireAt = int64(binary.LittleEndian.Uint64(b))
+
+		case opcodeIdle:
+			if _, err := d.readLength(); err != nil {
+				return nil, d.unexpected(err)
+			}
+
+		case opcodeFreq:
+			if _, err := d.readByte(); err != nil {
+				return nil, d.unexpected(err)
+			}
+
+		case opcodeFunction2:
+			// Library code, not a key
+			if err := d.skipString(); err != nil {
+				return nil, d.unexpected(err)
+			}
+
+		case opcodeFunctionPreGA:
+			return nil, fmt.Errorf("rdb: functions saved by Redis 7.0 release candidates are not supported")
+
+		case opcodeModuleAux:
+			if err := d.skipModuleAux(); err != nil {
+				return nil, d.unexpected(err)
+			}
+
+		case opcodeEOF:
+			d.done = true
+			if d.version >= 5 {
+				if err := d.verifyChecksum(); err != nil {
+					return nil, err
+				}
+			}
+
+		default:
+			if op > TypeHashListpackEx {
+				return nil, fmt.Errorf("rdb: unknown opcode 0x%02x", op)
+			}
+			key, err := d.readString()
+			if err != nil {
+				return nil, d.unexpected(err)
+			}
+
+			d.capturing = true
+			d.capture = d.capture[:0]
+			err = d.skipValue(op)
+			d.capturing = false
+			if err != nil {
+				return nil, fmt.Errorf("rdb: failed to read key %q: %w", key, d.unexpected(err))
+			}
+
+			return &Entry{
+				DB:       d.db,
+				Key:      string(key),
+				Type:     op,
+				Value:    append([]byte(nil), d.capture...),
+				ExpireAt: expireAt,
+			}, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// verifyChecksum compares the trailing checksum with the data read. A zero
+// checksum means that the server did not compute one.
+func (d *Reader) verifyChecksum() error {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return d.unexpected(err)
+	}
+	expected := binary.LittleEndian.Uint64(b)
+	if expected != 0 && expected != d.crc {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// skipValue reads a serialized value of the given type
+func (d *Reader) skipValue(valueType byte) error {
+	switch valueType {
+	case TypeString, TypeHashZipmap, TypeListZiplist, TypeSetIntset, TypeZSetZiplist,
+		TypeHashZiplist, TypeHashListpack, TypeZSetListpack, TypeSetListpack, TypeHashListpackExPreGA:
+		return d.skipString()
+
+	case TypeList, TypeSet, TypeListQuicklist:
+		n, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		return d.skipStrings(n)
+
+	case TypeHash:
+		n, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		return d.skipStrings(2 * n)
+
+	case TypeZSet, TypeZSet2:
+		n, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := d.skipString(); err != nil {
+				return err
+			}
+			if valueType == TypeZSet2 {
+				_, err = d.read(8)
+			} else {
+				err = d.skipASCIIDouble()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case TypeListQuicklist2:
+		n, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			// Container: plain or packed node
+			if _, err := d.readLength(); err != nil {
+				return err
+			}
+			if err := d.skipString(); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case TypeHashMetadata, TypeHashMetadataPreGA:
+		if valueType == TypeHashMetadata {
+			// Earliest field expiration
+			if _, err := d.read(8); err != nil {
+				return err
+			}
+		}
+		n, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			// Field TTL, relative in GA versions and absolute before
+			if valueType == TypeHashMetadata {
+				_, err = d.readLength()
+			} else {
+				_, err = d.read(8)
+			}
+			if err != nil {
+				return err
+			}
+			if err := d.skipStrings(2); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case TypeHashListpackEx:
+		// Earliest field expiration and the listpack
+		if _, err := d.read(8); err != nil {
+			return err
+		}
+		return d.skipString()
+
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		return d.skipStream(valueType)
+
+	case TypeModule2:
+		// Module type id followed by the values the module saved
+		if _, err := d.readLength(); err != nil {
+			return err
+		}
+		return d.skipModuleValues()
+
+	default:
+		return &UnsupportedTypeError{Type: valueType}
+	}
+}
+
+// skipStream reads a stream: its listpacks, metadata, consumer groups, their
+// pending entries and consumers
+func (d *Reader) skipStream(valueType byte) error {
+	// Listpacks keyed by their master entry ID
+	n, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	if err := d.skipStrings(2 * n); err != nil {
+		return err
+	}
+
+	// Length and last ID; first ID, max deleted ID and entries added since version 2
+	lengths := uint64(3)
+	if valueType >= TypeStreamListpacks2 {
+		lengths += 5
+	}
+	if err := d.skipLengths(lengths); err != nil {
+		return err
+	}
+
+	groups, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	for g := uint64(0); g < groups; g++ {
+		if err := d.skipString(); err != nil {
+			return err
+		}
+		// Last delivered ID and, since version 2, entries read
+		lengths := uint64(2)
+		if valueType >= TypeStreamListpacks2 {
+			lengths++
+		}
+		if err := d.skipLengths(lengths); err != nil {
+			return err
+		}
+
+		// Pending entries: ID, delivery time and count
+		pending, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		for p := uint64(0); p < pending; p++ {
+			if _, err := d.read(streamIDSize + 8); err != nil {
+				return err
+			}
+			if _, err := d.readLength(); err != nil {
+				return err
+			}
+		}
+
+		consumers, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		for c := uint64(0); c < consumers; c++ {
+			if err := d.skipString(); err != nil {
+				return err
+			}
+			// Seen time and, since version 3, active time
+			times := 8
+			if valueType >= TypeStreamListpacks3 {
+				times += 8
+			}
+			if _, err := d.read(times); err != nil {
+				return err
+			}
+			owned, err := d.readLength()
+			if err != nil {
+				return err
+			}
+			if _, err := d.read(int(owned) * streamIDSize); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipModuleAux reads auxiliary module data stored between keys
+func (d *Reader) skipModuleAux() error {
+	// Module id, then when it was saved as an unsigned value
+	if _, err := d.readLength(); err != nil {
+		return err
+	}
+	opcode, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	if opcode != moduleOpcodeUInt {
+		return fmt.Errorf("invalid module aux data")
+	}
+	if _, err := d.readLength(); err != nil {
+		return err
+	}
+	return d.skipModuleValues()
+}
+
+// skipModuleValues reads typed module values up to their EOF opcode
+func (d *Reader) skipModuleValues() error {
+	for {
+		opcode, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case moduleOpcodeEOF:
+			return nil
+		case moduleOpcodeSInt, moduleOpcodeUInt:
+			_, err = d.readLength()
+		case moduleOpcodeFloat:
+			_, err = d.read(4)
+		case moduleOpcodeDouble:
+			_, err = d.read(8)
+		case moduleOpcodeString:
+			err = d.skipString()
+		default:
+			return fmt.Errorf("unknown module opcode %d", opcode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// skipASCIIDouble reads a score of the original sorted set encoding
+func (d *Reader) skipASCIIDouble() error {
+	n, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	switch n {
+	case zsetScoreNaN, zsetScorePosInf, zsetScoreNegInf:
+		return nil
+	}
+	_, err = d.read(int(n))
+	return err
+}
+
+// readLength reads a length that must not be a special string encoding
+func (d *Reader) readLength() (uint64, error) {
+	n, special, err := d.readLengthOrEncoding()
+	if err != nil {
+		return 0, err
+	}
+	if special {
+		return 0, fmt.Errorf("unexpected string encoding %d", n)
+	}
+	return n, nil
+}
+
+// readLengthOrEncoding reads a length, or the special encoding of a string
+func (d *Reader) readLengthOrEncoding() (uint64, bool, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			v, err := d.read(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(v)), false, nil
+		case 0x81:
+			v, err := d.read(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(v), false, nil
+		default:
+			return 0, false, fmt.Errorf("invalid length encoding 0x%02x", b)
+		}
+	default:
+		return uint64(b & 0x3f), true, nil
+	}
+}
+
+// readString reads a string, expanding integer and LZF encodings
+func (d *Reader) readString() ([]byte, error) {
+	n, special, err := d.readLengthOrEncoding()
+	if err != nil {
+		return nil, err
+	}
+	if !special {
+		return d.read(int(n))
+	}
+
+	switch n {
+	case encInt8:
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int8(b[0])), 10), nil
+	case encInt16:
+		b, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(b))), 10), nil
+	case encInt32:
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(b))), 10), nil
+	case encLZF:
+		compressedLen, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		length, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := d.read(int(compressedLen))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(length))
+	default:
+		return nil, fmt.Errorf("unknown string encoding %d", n)
+	}
+}
+
+// skipString reads a string without expanding it
+func (d *Reader) skipString() error {
+	n, special, err := d.readLengthOrEncoding()
+	if err != nil {
+		return err
+	}
+	if !special {
+		_, err = d.read(int(n))
+		return err
+	}
+
+	switch n {
+	case encInt8:
+		_, err = d.read(1)
+	case encInt16:
+		_, err = d.read(2)
+	case encInt32:
+		_, err = d.read(4)
+	case encLZF:
+		compressedLen, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		if _, err := d.readLength(); err != nil {
+			return err
+		}
+		_, err = d.read(int(compressedLen))
+		return err
+	default:
+		err = fmt.Errorf("unknown string encoding %d", n)
+	}
+	return err
+}
+
+// skipStrings reads n strings
+func (d *Reader) skipStrings(n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if err := d.skipString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipLengths reads n lengths
+func (d *Reader) skipLengths(n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if _, err := d.readLength(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readByte reads one byte
+func (d *Reader) readByte() (byte, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// read reads n bytes, adding them to the checksum and to the value being captured
+func (d *Reader) read(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	var b []byte
+	if n <= 1<<20 {
+		b = make([]byte, n)
+		if _, err := io.ReadFull(d.r, b); err != nil {
+			return nil, err
+		}
+	} else {
+		// Grow with the data actually read, a corrupt length must not allocate gigabytes
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+			return nil, err
+		}
+		b = buf.Bytes()
+	}
+	d.crc = UpdateChecksum(d.crc, b)
+	if d.capturing {
+		d.capture = append(d.capture, b...)
+	}
+	return b, nil
+}
+
+// unexpected turns the end of the input inside the file into an error
+func (d *Reader) unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package rdb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readFixture reads every key of an RDB file in testdata. The files were
+// written by Redis and come from github.com/cupcake/rdb.
+func readFixture(t *testing.T, name string) []*Entry {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name + ".rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readAll(data)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	return entries
+}
+
+func readAll(data []byte) ([]*Entry, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// fixtureValue returns the decoded value of the only key of an RDB file in testdata
+func fixtureValue(t *testing.T, name string) (*Entry, *Value) {
+	t.Helper()
+	entries := readFixture(t, name)
+	if len(entries) != 1 {
+		t.Fatalf("%s has %d keys, want 1", name, len(entries))
+	}
+	value, err := entries[0].Decode()
+	if err != nil {
+		t.Fatalf("decoding %s: %v", name, err)
+	}
+	return entries[0], value
+}
+
+func TestReaderStrings(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    map[string]string
+	}{
+		{"integer_keys", map[string]string{
+			"125":        "Positive 8 bit integer",
+			"43947":      "Positive 16 bit integer",
+			"183358245":  "Positive 32 bit integer",
+			"-123":       "Negative 8 bit integer",
+			"-29477":     "Negative 16 bit integer",
+			"-183358245": "Negative 32 bit integer",
+		}},
+		{"easily_compressible_string_key", map[string]string{
+			strings.Repeat("a", 200): "Key that redis should compress easily",
+		}},
+		{"rdb_version_5_with_checksum", map[string]string{
+			"abcd":         "efgh",
+			"foo":          "bar",
+			"bar":          "baz",
+			"abcdef":       "abcdef",
+			"abc":          "def",
+			"longerstring": "thisisalongerstring.idontknowwhatitmeans",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			entries := readFixture(t, tt.fixture)
+			if len(entries) != len(tt.want) {
+				t.Fatalf("read %d keys, want %d", len(entries), len(tt.want))
+			}
+			for _, entry := range entries {
+				value, err := entry.Decode()
+				if err != nil {
+					t.Fatalf("decoding %q: %v", entry.Key, err)
+				}
+				if want, ok := tt.want[entry.Key]; !ok || value.Type != "string" || value.String != want {
+					t.Errorf("%q = %s %q, want string %q", entry.Key, value.Type, value.String, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReaderDatabasesAndExpiry(t *testing.T) {
+	entries := readFixture(t, "multiple_databases")
+	if len(entries) != 2 || entries[0].DB != 0 || entries[0].Key != "key_in_zeroth_database" ||
+		entries[1].DB != 2 || entries[1].Key != "key_in_second_database" {
+		t.Errorf("read %+v, want key_in_zeroth_database in 0 and key_in_second_database in 2", entries)
+	}
+
+	entries = readFixture(t, "keys_with_expiry")
+	if len(entries) != 1 || entries[0].ExpireAt != 1671963072573 {
+		t.Errorf("read %+v, want expires_ms_precision expiring at 1671963072573", entries)
+	}
+}
+
+func TestReaderChecksum(t *testing.T) {
+	data, err := os.ReadFile("testdata/rdb_version_5_with_checksum.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a bit of the last value, in front of the EOF opcode and checksum
+	data[len(data)-10] ^= 1
+	if _, err := readAll(data); !errors.Is(err, ErrChecksum) {
+		t.Errorf("reading a corrupted file = %v, want %v", err, ErrChecksum)
+	}
+}
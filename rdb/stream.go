@@ -0,0 +1,265 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errStream = errors.New("rdb: invalid stream listpack")
+
+// Stream flags of a listpack entry
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+// Stream is a decoded stream
+type Stream struct {
+	Entries []StreamEntry
+	LastID  string // Last generated ID
+	Groups  []StreamGroup
+}
+
+// StreamEntry is a stream entry with its field/value pairs in order
+type StreamEntry struct {
+	ID     string
+	Fields []string
+}
+
+// StreamGroup is a consumer group, in name order as XINFO GROUPS returns them
+type StreamGroup struct {
+	Name            string
+	LastDeliveredID string
+	Consumers       []StreamConsumer
+}
+
+// StreamConsumer is a consumer with the entries delivered to it but not acknowledged
+type StreamConsumer struct {
+	Name    string
+	Pending []StreamPending
+}
+
+// StreamPending is a pending entry with the time of its last delivery
+type StreamPending struct {
+	ID           string
+	DeliveryTime int64 // Unix time in milliseconds
+	Deliveries   int64
+}
+
+// readStream reads a stream: its listpacks keyed by their master entry ID,
+// metadata, consumer groups, their pending entries and consumers
+func (d *Reader) readStream(valueType byte) (*Stream, error) {
+	stream := &Stream{}
+	nodes, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		master, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		listpack, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(master) != streamIDSize {
+			return nil, errStream
+		}
+		entries, err := listpackEntries(listpack)
+		if err != nil {
+			return nil, err
+		}
+		if stream.Entries, err = streamEntries(stream.Entries, master, entries); err != nil {
+			return nil, err
+		}
+	}
+
+	// Length, then the last ID
+	if _, err := d.readLength(); err != nil {
+		return nil, err
+	}
+	if stream.LastID, err = d.readStreamID(); err != nil {
+		return nil, err
+	}
+	if valueType >= TypeStreamListpacks2 {
+		// First ID, max deleted ID and entries added
+		if err := d.skipLengths(5); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for g := uint64(0); g < groups; g++ {
+		name, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		group := StreamGroup{Name: string(name)}
+		if group.LastDeliveredID, err = d.readStreamID(); err != nil {
+			return nil, err
+		}
+		if valueType >= TypeStreamListpacks2 {
+			// Entries read
+			if _, err := d.readLength(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Pending entries of the group: ID, delivery time and count
+		n, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		pending := make(map[string]StreamPending, n)
+		for p := uint64(0); p < n; p++ {
+			b, err := d.read(streamIDSize + 8)
+			if err != nil {
+				return nil, err
+			}
+			deliveries, err := d.readLength()
+			if err != nil {
+				return nil, err
+			}
+			id := rawStreamID(b)
+			pending[id] = StreamPending{
+				ID:           id,
+				DeliveryTime: int64(binary.LittleEndian.Uint64(b[streamIDSize:])),
+				Deliveries:   int64(deliveries),
+			}
+		}
+
+		consumers, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		for c := uint64(0); c < consumers; c++ {
+			name, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			// Seen time and, since version 3, active time
+			times := 8
+			if valueType >= TypeStreamListpacks3 {
+				times += 8
+			}
+			if _, err := d.read(times); err != nil {
+				return nil, err
+			}
+
+			consumer := StreamConsumer{Name: string(name)}
+			owned, err := d.readLength()
+			if err != nil {
+				return nil, err
+			}
+			for o := uint64(0); o < owned; o++ {
+				b, err := d.read(streamIDSize)
+				if err != nil {
+					return nil, err
+				}
+				p, ok := pending[rawStreamID(b)]
+				if !ok {
+					return nil, fmt.Errorf("rdb: consumer %s owns an entry that is not pending", consumer.Name)
+				}
+				consumer.Pending = append(consumer.Pending, p)
+			}
+			group.Consumers = append(group.Consumers, consumer)
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return stream, nil
+}
+
+// readStreamID reads a stream ID saved as two lengths
+func (d *Reader) readStreamID() (string, error) {
+	ms, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+	seq, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+	return formatStreamID(ms, seq), nil
+}
+
+// streamEntries appends the entries of a stream listpack that are not
+// deleted. The listpack starts with a master entry holding the fields that
+// entries flagged with the same fields leave out, and entry IDs are saved as
+// differences from the master ID.
+func streamEntries(entries []StreamEntry, master []byte, lp []string) ([]StreamEntry, error) {
+	pos := 0
+	next := func() (int64, bool) {
+		if pos >= len(lp) {
+			return 0, false
+		}
+		v, err := strconv.ParseInt(lp[pos], 10, 64)
+		pos++
+		return v, err == nil
+	}
+
+	// Count, deleted count, master fields and a terminating 0
+	_, ok1 := next()
+	_, ok2 := next()
+	n, ok3 := next()
+	if !ok1 || !ok2 || !ok3 || n < 0 || pos+int(n)+1 > len(lp) {
+		return nil, errStream
+	}
+	masterFields := lp[pos : pos+int(n)]
+	pos += int(n) + 1
+
+	masterMs := binary.BigEndian.Uint64(master)
+	masterSeq := binary.BigEndian.Uint64(master[8:])
+	for pos < len(lp) {
+		flags, ok1 := next()
+		msDiff, ok2 := next()
+		seqDiff, ok3 := next()
+		if !ok1 || !ok2 || !ok3 {
+			return nil, errStream
+		}
+
+		var fields []string
+		if flags&streamItemSameFields != 0 {
+			if pos+len(masterFields) > len(lp) {
+				return nil, errStream
+			}
+			fields = make([]string, 0, 2*len(masterFields))
+			for i, field := range masterFields {
+				fields = append(fields, field, lp[pos+i])
+			}
+			pos += len(masterFields)
+		} else {
+			k, ok := next()
+			if !ok || k < 0 || pos+2*int(k) > len(lp) {
+				return nil, errStream
+			}
+			fields = append([]string(nil), lp[pos:pos+2*int(k)]...)
+			pos += 2 * int(k)
+		}
+
+		// Number of listpack entries of the entry, for iterating backwards
+		if _, ok := next(); !ok {
+			return nil, errStream
+		}
+		if flags&streamItemDeleted == 0 {
+			id := formatStreamID(masterMs+uint64(msDiff), masterSeq+uint64(seqDiff))
+			entries = append(entries, StreamEntry{ID: id, Fields: fields})
+		}
+	}
+	return entries, nil
+}
+
+// rawStreamID formats a stream ID saved as 16 big endian bytes
+func rawStreamID(b []byte) string {
+	return formatStreamID(binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:]))
+}
+
+// formatStreamID formats a stream ID as <ms>-<seq>
+func formatStreamID(ms, seq uint64) string {
+	return strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(seq, 10)
+}
@@ -0,0 +1,124 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// streamListpack builds a listpack of small integers and short strings
+func streamListpack(entries ...interface{}) []byte {
+	var encoded [][]byte
+	for _, entry := range entries {
+		switch v := entry.(type) {
+		case int:
+			encoded = append(encoded, []byte{byte(v)})
+		case string:
+			encoded = append(encoded, append([]byte{0x80 | byte(len(v))}, v...))
+		}
+	}
+	return listpack(encoded...)
+}
+
+func rawID(ms, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, ms), seq)
+}
+
+func appendLengths(b []byte, lengths ...uint64) []byte {
+	for _, n := range lengths {
+		b = appendLength(b, n)
+	}
+	return b
+}
+
+// streamValue serializes a stream like Redis 7.2 does: two entries left of
+// three, a consumer group with one pending entry owned by alice, and bob
+// without pending entries
+func streamValue(owned []byte) []byte {
+	const ms = 1700000000000
+	b := appendLength(nil, 1)
+	b = appendString(b, string(rawID(ms, 0)))
+	b = appendString(b, string(streamListpack(
+		// Master entry: count, deleted, fields and terminator
+		2, 1, 2, "f1", "f2", 0,
+		// Same fields as the master, at the master ID
+		streamItemSameFields, 0, 0, "a", "b", 5,
+		// Deleted
+		streamItemDeleted|streamItemSameFields, 0, 1, "c", "d", 5,
+		// Own fields, 5 ms after the master ID
+		0, 5, 0, 1, "x", "y", 7)))
+
+	// Length, last ID, first ID, max deleted ID, entries added
+	b = appendLengths(b, 2, ms+5, 0, ms, 0, ms, 1, 3)
+
+	b = appendLength(b, 1)
+	b = appendString(b, "g")
+	// Last delivered ID, entries read, pending entries
+	b = appendLengths(b, ms, 0, 1, 1)
+	b = append(b, rawID(ms, 0)...)
+	b = binary.LittleEndian.AppendUint64(b, ms+1000)
+	b = appendLengths(b, 3, 2)
+	b = appendString(b, "alice")
+	b = append(b, make([]byte, 16)...) // Seen and active time
+	b = appendLength(b, 1)
+	b = append(b, owned...)
+	b = appendString(b, "bob")
+	b = append(b, make([]byte, 16)...)
+	return appendLength(b, 0)
+}
+
+func TestDecodeStream(t *testing.T) {
+	const ms = 1700000000000
+	id := func(ms, seq uint64) string { return strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(seq, 10) }
+
+	got, err := DecodePayload(DumpPayload(TypeStreamListpacks3, streamValue(rawID(ms, 0)), 11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Stream{
+		Entries: []StreamEntry{
+			{ID: id(ms, 0), Fields: []string{"f1", "a", "f2", "b"}},
+			{ID: id(ms+5, 0), Fields: []string{"x", "y"}},
+		},
+		LastID: id(ms+5, 0),
+		Groups: []StreamGroup{{
+			Name:            "g",
+			LastDeliveredID: id(ms, 0),
+			Consumers: []StreamConsumer{
+				{Name: "alice", Pending: []StreamPending{{ID: id(ms, 0), DeliveryTime: ms + 1000, Deliveries: 3}}},
+				{Name: "bob"},
+			},
+		}},
+	}
+	if got.Type != "stream" || !reflect.DeepEqual(got.Stream, want) {
+		t.Errorf("decoded %s %+v, want %+v", got.Type, got.Stream, want)
+	}
+
+	// A consumer owning an entry that is not pending
+	if _, err := DecodeValue(TypeStreamListpacks3, streamValue(rawID(ms, 1))); err == nil {
+		t.Error("decoding a consumer owning an entry that is not pending succeeded, want an error")
+	}
+}
+
+func TestStreamEntriesErrors(t *testing.T) {
+	master := rawID(1, 0)
+	tests := []struct {
+		name string
+		lp   []string
+	}{
+		{"no master entry", nil},
+		{"master fields missing", []string{"1", "0", "3", "f"}},
+		{"entry without fields", []string{"1", "0", "1", "f", "0", "2", "0", "0"}},
+		{"entry without count", []string{"1", "0", "1", "f", "0", "2", "0", "0", "v"}},
+		{"odd own fields", []string{"1", "0", "1", "f", "0", "0", "0", "0", "2", "a", "b", "c"}},
+		{"not a number", []string{"1", "0", "1", "f", "0", "same", "0", "0", "v", "4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := streamEntries(nil, master, tt.lp); err == nil {
+				t.Errorf("streamEntries = %+v, want an error", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// EncodingVersion is the RDB version of values serialized by EncodeValue.
+// Every server since Redis 5 reads them.
+const EncodingVersion = 9
+
+// Writer writes keys as an RDB file that a server loads at startup. The
+// header is written with the first key, using the RDB version its value was
+// serialized with.
+type Writer struct {
+	w       *bufio.Writer
+	aux     map[string]string
+	crc     uint64
+	version int
+	started bool
+}
+
+// NewWriter returns a writer to w. aux holds auxiliary fields such as
+// redis-ver written after the header.
+func NewWriter(w io.Writer, aux map[string]string) *Writer {
+	return &Writer{w: bufio.NewWriterSize(w, 64<<10), aux: aux}
+}
+
+// Version returns the RDB version of the file, 0 before the first key
+func (w *Writer) Version() int {
+	return w.version
+}
+
+// Write writes a key whose value was serialized for the given RDB version.
+// Every key of a file must have been serialized for the version of the first
+// key or an older one.
+func (w *Writer) Write(e *Entry, version int) error {
+	if err := w.start(version); err != nil {
+		return err
+	}
+	if version > w.version {
+		return fmt.Errorf("rdb: value of %q is serialized for RDB version %d, the file has version %d", e.Key, version, w.version)
+	}
+	if e.DB != 0 {
+		return fmt.Errorf("rdb: key %q is in database %d, only database 0 is written", e.Key, e.DB)
+	}
+
+	if e.ExpireAt > 0 {
+		w.writeByte(opcodeExpireTimeMs)
+		w.write(binary.LittleEndian.AppendUint64(nil, uint64(e.ExpireAt)))
+	}
+	w.writeByte(e.Type)
+	w.writeString(e.Key)
+	w.write(e.Value)
+	return nil
+}
+
+// Close writes the end of the file and its checksum. A file without keys
+// gets the version of EncodingVersion.
+func (w *Writer) Close() error {
+	if err := w.start(EncodingVersion); err != nil {
+		return err
+	}
+	w.writeByte(opcodeEOF)
+	if err := binary.Write(w.w, binary.LittleEndian, w.crc); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// start writes the header, aux fields and database selector once
+func (w *Writer) start(version int) error {
+	if w.started {
+		return nil
+	}
+	if version < 1 || version > MaxVersion {
+		return fmt.Errorf("rdb: cannot write RDB version %d", version)
+	}
+	w.started = true
+	w.version = version
+
+	w.write([]byte(fmt.Sprintf("REDIS%04d", version)))
+
+	names := make([]string, 0, len(w.aux))
+	for name := range w.aux {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.writeByte(opcodeAux)
+		w.writeString(name)
+		w.writeString(w.aux[name])
+	}
+
+	w.writeByte(opcodeSelectDB)
+	w.writeLength(0)
+	return nil
+}
+
+// writeString writes a length prefixed string
+func (w *Writer) writeString(s string) {
+	w.writeLength(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+// writeLength writes a length in the RDB length encoding
+func (w *Writer) writeLength(n uint64) {
+	w.write(appendLength(nil, n))
+}
+
+// writeByte writes one byte
+func (w *Writer) writeByte(b byte) {
+	w.write([]byte{b})
+}
+
+// write writes p and adds it to the checksum. Errors are reported by Close
+// through the buffered writer.
+func (w *Writer) write(p []byte) {
+	w.crc = UpdateChecksum(w.crc, p)
+	w.w.Write(p)
+}
+
+// EncodeValue serializes a value with plain encodings that every server
+// since RDB version EncodingVersion reads, and returns it with its type
+func EncodeValue(v *Value) (byte, []byte, error) {
+	var b []byte
+	switch v.Type {
+	case "string":
+		return TypeString, appendString(nil, v.String), nil
+
+	case "list", "set":
+		valueType := byte(TypeList)
+		if v.Type == "set" {
+			valueType = TypeSet
+		}
+		b = appendLength(b, uint64(len(v.Elements)))
+		for _, element := range v.Elements {
+			b = appendString(b, element)
+		}
+		return valueType, b, nil
+
+	case "hash":
+		b = appendLength(b, uint64(len(v.Fields)))
+		for field, value := range v.Fields {
+			b = appendString(b, field)
+			b = appendString(b, value)
+		}
+		return TypeHash, b, nil
+
+	case "zset":
+		b = appendLength(b, uint64(len(v.Members)))
+		for _, m := range v.Members {
+			b = appendString(b, m.Member)
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(m.Score))
+		}
+		return TypeZSet2, b, nil
+
+	default:
+		return 0, nil, fmt.Errorf("rdb: cannot encode %s values", v.Type)
+	}
+}
+
+// appendString appends a length prefixed string
+func appendString(b []byte, s string) []byte {
+	b = appendLength(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendLength appends a length in the RDB length encoding
+func appendLength(b []byte, n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return append(b, byte(n))
+	case n < 1<<14:
+		return append(b, byte(n>>8)|0x40, byte(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0x80), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0x81), n)
+	}
+}
+
+// DefaultAux returns the auxiliary fields written by default
+func DefaultAux() map[string]string {
+	return map[string]string{
+		"redis-bits": "64",
+		"ctime":      strconv.FormatInt(time.Now().Unix(), 10),
+		"aof-base":   "0",
+	}
+}
@@ -0,0 +1,125 @@
+package rdb
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	values := map[string]*Value{
+		"string": {Type: "string", String: "value"},
+		"long":   {Type: "string", String: strings.Repeat("x", 1<<15)},
+		"list":   {Type: "list", Elements: []string{"a", "b", "a"}},
+		"set":    {Type: "set", Elements: []string{"a", "b"}},
+		"hash":   {Type: "hash", Fields: map[string]string{"f1": "v1", "f2": ""}},
+		"zset": {Type: "zset", Members: []Member{
+			{"low", math.Inf(-1)}, {"a", 1.5}, {"b", 1.5}, {"high", math.Inf(1)}}},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, map[string]string{"redis-ver": "7.2.0"})
+	for key, value := range values {
+		valueType, encoded, err := EncodeValue(value)
+		if err != nil {
+			t.Fatalf("EncodeValue(%s): %v", key, err)
+		}
+		entry := &Entry{Key: key, Type: valueType, Value: encoded}
+		if key == "string" {
+			entry.ExpireAt = 1700000000000
+		}
+		if err := w.Write(entry, EncodingVersion); err != nil {
+			t.Fatalf("Write(%s): %v", key, err)
+		}
+	}
+	// Values read from an older file are written as they are
+	intset, _ := fixtureValue(t, "intset_16")
+	if err := w.Write(intset, 3); err != nil {
+		t.Fatalf("Write(%s): %v", intset.Key, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readAll(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version() != EncodingVersion || len(entries) != len(values)+1 {
+		t.Fatalf("read version %d with %d keys, want %d with %d", r.Version(), len(entries), EncodingVersion, len(values)+1)
+	}
+	for _, entry := range entries {
+		got, err := entry.Decode()
+		if err != nil {
+			t.Fatalf("decoding %s: %v", entry.Key, err)
+		}
+		want := values[entry.Key]
+		if entry.Key == intset.Key {
+			want = &Value{Type: "set", Elements: []string{"32764", "32765", "32766"}}
+		}
+		if want == nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %+v, want %+v", entry.Key, got, want)
+		}
+		wantExpire := int64(0)
+		if entry.Key == "string" {
+			wantExpire = 1700000000000
+		}
+		if entry.ExpireAt != wantExpire {
+			t.Errorf("%s expires at %d, want %d", entry.Key, entry.ExpireAt, wantExpire)
+		}
+	}
+}
+
+func TestWriterAux(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriter(&buf, map[string]string{"redis-ver": "7.2.0"}).Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err == nil {
+		t.Fatal("read a key from an empty file")
+	}
+	if r.Version() != EncodingVersion || r.Aux()["redis-ver"] != "7.2.0" {
+		t.Errorf("read version %d and aux %v, want %d and redis-ver 7.2.0", r.Version(), r.Aux(), EncodingVersion)
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	value := appendString(nil, "v")
+	tests := []struct {
+		name    string
+		entries []*Entry
+		version []int
+	}{
+		{"newer value than file", []*Entry{{Key: "a", Value: value}, {Key: "b", Value: value}}, []int{9, 10}},
+		{"other database", []*Entry{{Key: "a", DB: 1, Value: value}}, []int{9}},
+		{"unknown version", []*Entry{{Key: "a", Value: value}}, []int{MaxVersion + 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWriter(&bytes.Buffer{}, nil)
+			var err error
+			for i, entry := range tt.entries {
+				if err = w.Write(entry, tt.version[i]); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				t.Error("Write succeeded, want an error")
+			}
+		})
+	}
+
+	if _, _, err := EncodeValue(&Value{Type: "stream"}); err == nil {
+		t.Error("EncodeValue of a stream succeeded, want an error")
+	}
+}
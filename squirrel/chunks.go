@@ -0,0 +1,237 @@
+package squirrel
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultChunkSize is the number of elements read per LRANGE, XRANGE, SSCAN,
+// HSCAN or ZSCAN call, and written per RPUSH, SADD, HSET or ZADD
+const DefaultChunkSize = 1000
+
+// DefaultMaxDumpSize is the default proto-max-bulk-len of Redis, the biggest
+// DUMP payload a RESTORE accepts
+const DefaultMaxDumpSize = 512 << 20
+
+// readChunks reads the value of a key by type in chunks of about size
+// elements, with ranged LRANGE and XRANGE and cursor based scans, so that big
+// collections do not block the server. fn receives every chunk in the shape
+// exportValueByType returns; strings are a single chunk. A key that no longer
+// exists returns ErrKeyExpired.
+func readChunks(ctx context.Context, client redis.UniversalClient, key, keyType string, size int64, fn func(value interface{}) error) error {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+
+	switch keyType {
+	case "string":
+		value, err := client.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return ErrKeyExpired
+		}
+		if err != nil {
+			return err
+		}
+		return fn(value)
+
+	case "list":
+		for start := int64(0); ; start += size {
+			elements, err := client.LRange(ctx, key, start, start+size-1).Result()
+			if err != nil {
+				return err
+			}
+			if len(elements) == 0 {
+				if start == 0 {
+					return ErrKeyExpired
+				}
+				return nil
+			}
+			if err := fn(elements); err != nil {
+				return err
+			}
+			if int64(len(elements)) < size {
+				return nil
+			}
+		}
+
+	case "set", "hash", "zset":
+		var cursor uint64
+		read := 0
+		for {
+			var page []string
+			var err error
+			switch keyType {
+			case "set":
+				page, cursor, err = client.SScan(ctx, key, cursor, "", size).Result()
+			case "hash":
+				page, cursor, err = client.HScan(ctx, key, cursor, "", size).Result()
+			default:
+				page, cursor, err = client.ZScan(ctx, key, cursor, "", size).Result()
+			}
+			if err != nil {
+				return err
+			}
+
+			if len(page) > 0 {
+				value, err := scanValue(keyType, page)
+				if err != nil {
+					return err
+				}
+				if err := fn(value); err != nil {
+					return err
+				}
+				read += len(page)
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+		if read == 0 {
+			return ErrKeyExpired
+		}
+		return nil
+
+	case "stream":
+		return readStream(ctx, client, key, size, fn)
+
+	default:
+		return fmt.Errorf("%w:   %s", errUnsupported, keyType)
+	}
+}
+
+// scanValue converts a page of SSCAN, HSCAN or ZSCAN to a value
+func scanValue(keyType string, page []string) (interface{}, error) {
+	switch keyType {
+	case "set":
+		return page, nil
+	case "hash":
+		fields := make(map[string]string, len(page)/2)
+		for i := 0; i+1 < len(page); i += 2 {
+			fields[page[i]] = page[i+1]
+		}
+		return fields, nil
+	default:
+		members := make([]redis.Z, 0, len(page)/2)
+		for i := 0; i+1 < len(page); i += 2 {
+			score, err := strconv.ParseFloat(page[i+1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w score %q of member %q", errInvalid, page[i+1], page[i])
+			}
+			members = append(members, redis.Z{Score: score, Member: page[i]})
+		}
+		return members, nil
+	}
+}
+
+// chunkMerger joins the chunks of a value. Members that a scan returned more
+// than once are kept once, and sorted set members end up in ZRANGE order.
+type chunkMerger struct {
+	keyType string
+	value   interface{}
+	seen    map[string]bool
+}
+
+// add appends a chunk
+func (m *chunkMerger) add(chunk interface{}) {
+	switch v := chunk.(type) {
+	case string:
+		m.value = v
+	case []string:
+		elements, _ := m.value.([]string)
+		if m.keyType != "set" {
+			m.value = append(elements, v...)
+			return
+		}
+		for _, element := range v {
+			if !m.once(element) {
+				elements = append(elements, element)
+			}
+		}
+		m.value = elements
+	case map[string]string:
+		fields, ok := m.value.(map[string]string)
+		if !ok {
+			m.value = v
+			return
+		}
+		for field, value := range v {
+			fields[field] = value
+		}
+	case []redis.Z:
+		members, _ := m.value.([]redis.Z)
+		for _, z := range v {
+			if member, _ := z.Member.(string); !m.once(member) {
+				members = append(members, z)
+			}
+		}
+		m.value = members
+	case StreamValue:
+		stream, _ := m.value.(StreamValue)
+		stream.Entries = append(stream.Entries, v.Entries...)
+		stream.LastID, stream.Groups = v.LastID, v.Groups
+		m.value = stream
+	}
+}
+
+// once reports whether s was added before, and remembers it
+func (m *chunkMerger) once(s string) bool {
+	if m.seen == nil {
+		m.seen = make(map[string]bool)
+	}
+	if m.seen[s] {
+		return true
+	}
+	m.seen[s] = true
+	return false
+}
+
+// result returns the joined value
+func (m *chunkMerger) result() interface{} {
+	if members, ok := m.value.([]redis.Z); ok {
+		sort.Slice(members, func(i, j int) bool {
+			if members[i].Score != members[j].Score {
+				return members[i].Score < members[j].Score
+			}
+			return members[i].Member.(string) < members[j].Member.(string)
+		})
+	}
+	return m.value
+}
+
+// chunkable reports whether readChunks reads keys of keyType
+func chunkable(keyType string) bool {
+	switch keyType {
+	case "string", "list", "set", "hash", "zset", "stream":
+		return true
+	}
+	return false
+}
+
+// exportChunks exports a key by type as one record per chunk of size
+// elements, with the TTL and type read by exportMeta. Every record is passed
+// to write once the next chunk has been read, so that the last one is known:
+// Chunk numbers the records and More is set on all but the last.
+func exportChunks(ctx context.Context, client redis.UniversalClient, meta *KeyData, size int64, write func(*KeyData) error) error {
+	var pending *KeyData
+	err := readChunks(ctx, client, meta.Key, meta.Type, size, func(value interface{}) error {
+		chunk := *meta
+		chunk.Value = value
+		if pending != nil {
+			chunk.Chunk = pending.Chunk + 1
+			pending.More = true
+			if err := write(pending); err != nil {
+				return err
+			}
+		}
+		pending = &chunk
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return write(pending)
+}
@@ -0,0 +1,207 @@
+package squirrel
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeHangUp is a reply closing the connection instead of answering
+type fakeHangUp struct{}
+
+// fakeClient returns a client whose commands are answered by handle. Replies
+// are nil, strings, integers, errors, slices of replies or fakeHangUp. The
+// client does not retry commands itself.
+func fakeClient(t *testing.T, handle func(args []string) interface{}) *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		DisableIdentity: true,
+		MaxRetries:      -1,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, server := net.Pipe()
+			go serveFake(server, handle)
+			return conn, nil
+		},
+	})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func serveFake(conn net.Conn, handle func(args []string) interface{}) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		var n int
+		if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+				return
+			}
+			b := make([]byte, size+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return
+			}
+			args[i] = string(b[:size])
+		}
+
+		// Connection setup such as HELLO is refused, as older servers do
+		reply := interface{}(errors.New("ERR unknown command"))
+		if !strings.EqualFold(args[0], "hello") && !strings.EqualFold(args[0], "client") {
+			reply = handle(args)
+		}
+		if _, ok := reply.(fakeHangUp); ok {
+			return
+		}
+		writeFake(w, reply)
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func writeFake(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, s := range v {
+			writeFake(w, s)
+		}
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeFake(w, item)
+		}
+	}
+}
+
+// fakeList answers LRANGE from a list
+func fakeList(list []string) func(args []string) interface{} {
+	return func(args []string) interface{} {
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		if start >= len(list) {
+			return []string{}
+		}
+		return list[start:min(stop+1, len(list))]
+	}
+}
+
+func TestExportChunks(t *testing.T) {
+	tests := []struct {
+		name   string
+		list   []string
+		chunks [][]string
+	}{
+		{"one chunk", []string{"a"}, [][]string{{"a"}}},
+		{"last chunk short", []string{"a", "b", "c", "d", "e"}, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{"last chunk full", []string{"a", "b", "c", "d"}, [][]string{{"a", "b"}, {"c", "d"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fakeClient(t, fakeList(tt.list))
+			meta := &KeyData{Key: "l", Type: "list", TTL: -1}
+			var records []*KeyData
+			err := exportChunks(context.Background(), client, meta, 2, func(k *KeyData) error {
+				records = append(records, k)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(tt.chunks) {
+				t.Fatalf("wrote %d records, want %d", len(records), len(tt.chunks))
+			}
+			for i, record := range records {
+				more := i < len(records)-1
+				if record.Key != "l" || record.TTL != -1 || record.Chunk != i || record.More != more ||
+					!reflect.DeepEqual(record.Value, tt.chunks[i]) {
+					t.Errorf("record %d = %+v, want chunk %d of %q with More %v", i, record, i, tt.chunks[i], more)
+				}
+			}
+		})
+	}
+
+	client := fakeClient(t, fakeList(nil))
+	err := exportChunks(context.Background(), client, &KeyData{Key: "l", Type: "list"}, 2, func(k *KeyData) error {
+		t.Errorf("wrote %+v for a key that does not exist", k)
+		return nil
+	})
+	if !errors.Is(err, ErrKeyExpired) {
+		t.Errorf("exportChunks of a missing key = %v, want %v", err, ErrKeyExpired)
+	}
+}
+
+func TestReadChunksScan(t *testing.T) {
+	// Two SSCAN pages, the second repeating a member of the first
+	pages := map[string][]interface{}{
+		"0": {"7", []string{"a", "b"}},
+		"7": {"0", []string{"b", "c"}},
+	}
+	client := fakeClient(t, func(args []string) interface{} {
+		return pages[args[2]]
+	})
+	merger := &chunkMerger{keyType: "set"}
+	chunks := 0
+	err := readChunks(context.Background(), client, "s", "set", 2, func(value interface{}) error {
+		chunks++
+		merger.add(value)
+		return nil
+	})
+	if err != nil || chunks != 2 || !reflect.DeepEqual(merger.result(), []string{"a", "b", "c"}) {
+		t.Errorf("read %d chunks merged into %q, %v, want 2 chunks of [a b c]", chunks, merger.result(), err)
+	}
+}
+
+func TestChunkMerger(t *testing.T) {
+	tests := []struct {
+		keyType string
+		chunks  []interface{}
+		want    interface{}
+	}{
+		{"string", []interface{}{"v"}, "v"},
+		{"list", []interface{}{[]string{"a", "b"}, []string{"a"}}, []string{"a", "b", "a"}},
+		{"set", []interface{}{[]string{"a", "b"}, []string{"b", "c"}}, []string{"a", "b", "c"}},
+		{"hash", []interface{}{map[string]string{"f": "1"}, map[string]string{"g": "2", "f": "1"}},
+			map[string]string{"f": "1", "g": "2"}},
+		{"zset", []interface{}{
+			[]redis.Z{{Member: "c", Score: 2}, {Member: "b", Score: 1}},
+			[]redis.Z{{Member: "c", Score: 2}, {Member: "a", Score: 1}},
+		}, []redis.Z{{Member: "a", Score: 1}, {Member: "b", Score: 1}, {Member: "c", Score: 2}}},
+		{"stream", []interface{}{
+			StreamValue{Entries: []StreamEntry{{ID: "1-0"}}},
+			StreamValue{Entries: []StreamEntry{{ID: "2-0"}}, LastID: "3-0", Groups: []StreamGroup{{Name: "g"}}},
+		}, StreamValue{Entries: []StreamEntry{{ID: "1-0"}, {ID: "2-0"}}, LastID: "3-0", Groups: []StreamGroup{{Name: "g"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyType, func(t *testing.T) {
+			merger := &chunkMerger{keyType: tt.keyType}
+			for _, chunk := range tt.chunks {
+				merger.add(chunk)
+			}
+			if got := merger.result(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merged %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package squirrel
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keySpec locates the keys of a command like COMMAND INFO does: they are the
+// arguments from first to last, every step arguments. A negative last counts
+// from the end. Commands whose keys depend on their arguments set keys instead.
+type keySpec struct {
+	first, last, step int
+	keys              func(args []string) []int
+
+	// split commands act on every key group on its own, so that the groups
+	// of keys outside the pattern can be dropped
+	split bool
+	// unsafe commands change the key relative to its value, so that applying
+	// them twice differs from applying them once
+	unsafe bool
+}
+
+// Key positions of the write commands found in a replication stream
+var commandKeySpecs = map[string]keySpec{
+	"append": {first: 1, last: 1, step: 1, unsafe: true}, "set": {first: 1, last: 1, step: 1},
+	"setex": {first: 1, last: 1, step: 1}, "psetex": {first: 1, last: 1, step: 1},
+	"setnx": {first: 1, last: 1, step: 1}, "setrange": {first: 1, last: 1, step: 1},
+	"getset": {first: 1, last: 1, step: 1}, "getdel": {first: 1, last: 1, step: 1},
+	"getex": {first: 1, last: 1, step: 1}, "setbit": {first: 1, last: 1, step: 1},
+	"bitfield": {first: 1, last: 1, step: 1, unsafe: true}, "pfadd": {first: 1, last: 1, step: 1},
+	"incr": {first: 1, last: 1, step: 1, unsafe: true}, "incrby": {first: 1, last: 1, step: 1, unsafe: true},
+	"incrbyfloat": {first: 1, last: 1, step: 1, unsafe: true}, "decr": {first: 1, last: 1, step: 1, unsafe: true},
+	"decrby": {first: 1, last: 1, step: 1, unsafe: true},
+
+	"expire": {first: 1, last: 1, step: 1}, "pexpire": {first: 1, last: 1, step: 1},
+	"expireat": {first: 1, last: 1, step: 1}, "pexpireat": {first: 1, last: 1, step: 1},
+	"persist": {first: 1, last: 1, step: 1}, "restore": {first: 1, last: 1, step: 1},
+	"restore-asking": {first: 1, last: 1, step: 1},
+
+	"lpush": {first: 1, last: 1, step: 1, unsafe: true}, "rpush": {first: 1, last: 1, step: 1, unsafe: true},
+	"lpushx": {first: 1, last: 1, step: 1, unsafe: true}, "rpushx": {first: 1, last: 1, step: 1, unsafe: true},
+	"linsert": {first: 1, last: 1, step: 1, unsafe: true}, "lpop": {first: 1, last: 1, step: 1, unsafe: true},
+	"rpop": {first: 1, last: 1, step: 1, unsafe: true}, "lrem": {first: 1, last: 1, step: 1, unsafe: true},
+	"ltrim": {first: 1, last: 1, step: 1, unsafe: true}, "lset": {first: 1, last: 1, step: 1},
+	"lmove": {first: 1, last: 2, step: 1, unsafe: true}, "rpoplpush": {first: 1, last: 2, step: 1, unsafe: true},
+	"lmpop": {keys: numKeys(1), unsafe: true},
+
+	"sadd": {first: 1, last: 1, step: 1}, "srem": {first: 1, last: 1, step: 1},
+	"spop": {first: 1, last: 1, step: 1, unsafe: true}, "smove": {first: 1, last: 2, step: 1},
+	"sinterstore": {first: 1, last: -1, step: 1}, "sunionstore": {first: 1, last: -1, step: 1},
+	"sdiffstore": {first: 1, last: -1, step: 1},
+
+	"hset": {first: 1, last: 1, step: 1}, "hsetnx": {first: 1, last: 1, step: 1},
+	"hmset": {first: 1, last: 1, step: 1}, "hdel": {first: 1, last: 1, step: 1},
+	"hincrby": {first: 1, last: 1, step: 1, unsafe: true}, "hincrbyfloat": {first: 1, last: 1, step: 1, unsafe: true},
+	"hpexpireat": {first: 1, last: 1, step: 1}, "hpersist": {first: 1, last: 1, step: 1},
+
+	"zadd": {first: 1, last: 1, step: 1}, "zincrby": {first: 1, last: 1, step: 1, unsafe: true},
+	"zrem": {first: 1, last: 1, step: 1}, "zremrangebyscore": {first: 1, last: 1, step: 1},
+	"zremrangebyrank": {first: 1, last: 1, step: 1, unsafe: true}, "zremrangebylex": {first: 1, last: 1, step: 1},
+	"zpopmin": {first: 1, last: 1, step: 1, unsafe: true}, "zpopmax": {first: 1, last: 1, step: 1, unsafe: true},
+	"zmpop": {keys: numKeys(1), unsafe: true}, "zrangestore": {first: 1, last: 2, step: 1},
+	"zunionstore": {keys: storeNumKeys}, "zinterstore": {keys: storeNumKeys},
+	"zdiffstore": {keys: storeNumKeys}, "geoadd": {first: 1, last: 1, step: 1},
+	"geosearchstore": {first: 1, last: 2, step: 1},
+
+	"xadd": {first: 1, last: 1, step: 1, unsafe: true}, "xdel": {first: 1, last: 1, step: 1},
+	"xtrim": {first: 1, last: 1, step: 1}, "xack": {first: 1, last: 1, step: 1},
+	"xclaim": {first: 1, last: 1, step: 1}, "xautoclaim": {first: 1, last: 1, step: 1},
+	"xsetid": {first: 1, last: 1, step: 1}, "xgroup": {first: 2, last: 2, step: 1},
+
+	"del": {first: 1, last: -1, step: 1, split: true}, "unlink": {first: 1, last: -1, step: 1, split: true},
+	"mset": {first: 1, last: -1, step: 2, split: true}, "msetnx": {first: 1, last: -1, step: 2, split: true},
+	"rename": {first: 1, last: 2, step: 1}, "renamenx": {first: 1, last: 2, step: 1},
+	"copy": {first: 1, last: 2, step: 1}, "bitop": {first: 2, last: -1, step: 1},
+	"pfmerge": {first: 1, last: -1, step: 1}, "sort": {keys: sortKeys},
+
+	"eval": {keys: numKeys(2)}, "evalsha": {keys: numKeys(2)}, "fcall": {keys: numKeys(2)},
+	"function": {}, "script": {},
+}
+
+// commandKeys returns the positions of the keys in a command. Unknown
+// commands are taken to have their key first, like most commands do.
+func commandKeys(args []string) (keySpec, []int) {
+	spec, ok := commandKeySpecs[strings.ToLower(args[0])]
+	if !ok {
+		spec = keySpec{first: 1, last: 1, step: 1}
+	}
+	if spec.keys != nil {
+		return spec, spec.keys(args)
+	}
+	if spec.step == 0 {
+		return spec, nil
+	}
+
+	last := spec.last
+	if last < 0 {
+		last += len(args)
+	}
+	var keys []int
+	for i := spec.first; i <= last && i < len(args); i += spec.step {
+		keys = append(keys, i)
+	}
+	return spec, keys
+}
+
+// numKeys locates keys counted by the argument at position n, as in
+// EVAL script numkeys key...
+func numKeys(n int) func(args []string) []int {
+	return func(args []string) []int {
+		if n >= len(args) {
+			return nil
+		}
+		count, err := strconv.Atoi(args[n])
+		if err != nil || count < 0 {
+			return nil
+		}
+		var keys []int
+		for i := n + 1; i <= n+count && i < len(args); i++ {
+			keys = append(keys, i)
+		}
+		return keys
+	}
+}
+
+// storeNumKeys locates the keys of ZUNIONSTORE destination numkeys key...
+func storeNumKeys(args []string) []int {
+	if len(args) < 2 {
+		return nil
+	}
+	return append([]int{1}, numKeys(2)(args)...)
+}
+
+// sortKeys locates the keys of SORT key ... STORE destination
+func sortKeys(args []string) []int {
+	if len(args) < 2 {
+		return nil
+	}
+	keys := []int{1}
+	for i := 2; i+1 < len(args); i++ {
+		if strings.EqualFold(args[i], "store") {
+			keys = append(keys, i+1)
+		}
+	}
+	return keys
+}
+
+// splitCommand keeps the key groups of a split command whose key is kept
+func splitCommand(args []string, spec keySpec, keys []int, keep func(key string) bool) []string {
+	isKey := make(map[int]bool, len(keys))
+	for _, i := range keys {
+		isKey[i] = true
+	}
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if !isKey[i] {
+			out = append(out, args[i])
+			continue
+		}
+		if keep(args[i]) {
+			out = append(out, args[i:min(i+spec.step, len(args))]...)
+		}
+		i += spec.step - 1
+	}
+	return out
+}
@@ -0,0 +1,55 @@
+package squirrel
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandKeys(t *testing.T) {
+	tests := []struct {
+		command string
+		keys    []int
+	}{
+		{"SET a 1", []int{1}},
+		{"DEL a b c", []int{1, 2, 3}},
+		{"MSET a 1 b 2", []int{1, 3}},
+		{"XGROUP CREATE s g $", []int{2}},
+		{"RENAME a b", []int{1, 2}},
+		{"BITOP AND dest a b", []int{2, 3, 4}},
+		{"ZUNIONSTORE dest 2 a b WEIGHTS 1 2", []int{1, 3, 4}},
+		{"LMPOP 2 a b LEFT", []int{2, 3}},
+		{"EVAL script 1 a arg", []int{3}},
+		{"SORT a BY w STORE dest", []int{1, 5}},
+		{"FUNCTION LOAD code", nil},
+		{"UNKNOWN a b", []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			if _, keys := commandKeys(strings.Fields(tt.command)); !reflect.DeepEqual(keys, tt.keys) {
+				t.Errorf("commandKeys(%q) = %v, want %v", tt.command, keys, tt.keys)
+			}
+		})
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"DEL a x b", "DEL a b"},
+		{"MSET x 1 a 2 y 3", "MSET a 2"},
+		{"UNLINK x y", "UNLINK"},
+	}
+	keep := func(key string) bool { return key == "a" || key == "b" }
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			args := strings.Fields(tt.command)
+			spec, keys := commandKeys(args)
+			if got := strings.Join(splitCommand(args, spec, keys, keep), " "); got != tt.want {
+				t.Errorf("splitCommand(%q) = %q, want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CutoverOptions configures a cutover
+type CutoverOptions struct {
+	// PauseTimeout bounds how long writes on the source are paused. The
+	// masters lift the pause on their own once it expires.
+	PauseTimeout time.Duration
+	// DrainTimeout is how long to wait for the sync to apply the last changes
+	DrainTimeout time.Duration
+	// Samples is the number of random keys whose values are compared
+	Samples int
+	// Pattern limits the sampled keys; key counts are only logged for "*"
+	Pattern string
+	// Lag returns the state of the running sync
+	Lag  func(ctx context.Context) (SyncStatus, error)
+	Logf func(format string, args ...interface{})
+}
+
+// CutoverReport describes a cutover
+type CutoverReport struct {
+	Paused      []string  // Source masters whose writes were paused
+	PausedUntil time.Time // When the source accepts writes again
+	DrainTime   time.Duration
+	SourceKeys  int64
+	TargetKeys  int64
+	Sampled     int
+	Mismatches  []string // Sampled keys that differ, with the difference
+	RolledBack  bool     // The pause was lifted because the cutover failed
+}
+
+// ErrCutoverFailed is returned when the target could not be verified; the
+// pause on the source has then been lifted
+var ErrCutoverFailed = errors.New("cutover failed")
+
+// Cutover pauses writes on every source master, waits until the sync applied
+// every change and verifies the target against the source. On success the
+// source stays paused until the report's PausedUntil so that clients can be
+// moved to the target; on failure the pause is lifted right away.
+func Cutover(ctx context.Context, source, target redis.UniversalClient, opts CutoverOptions) (*CutoverReport, error) {
+	logf := logger(opts.Logf)
+	if opts.Pattern == "" {
+		opts.Pattern = "*"
+	}
+	report := &CutoverReport{}
+
+	// Make sure the sync is running before anything is paused
+	status, err := opts.Lag(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to read sync status: %w", err)
+	}
+	if status.Phase != SyncPhaseStreaming {
+		return report, fmt.Errorf("sync is %q, it must be streaming changes", status.Phase)
+	}
+
+	paused, err := pauseWrites(ctx, source, opts.PauseTimeout)
+	report.Paused = paused
+	report.PausedUntil = time.Now().Add(opts.PauseTimeout)
+	if err != nil {
+		return report, rollback(source, report, logf, err)
+	}
+	logf("✓ Paused writes on %d source masters until %s\n", len(paused), report.PausedUntil.Format(time.RFC3339))
+
+	start := time.Now()
+	if err := waitDrained(ctx, opts, logf); err != nil {
+		return report, rollback(source, report, logf, err)
+	}
+	report.DrainTime = time.Since(start)
+	logf("✓ Sync drained in %s\n", report.DrainTime.Round(time.Millisecond))
+
+	if err := verifyTarget(ctx, source, target, opts, report, logf); err != nil {
+		return report, rollback(source, report, logf, err)
+	}
+	if time.Now().After(report.PausedUntil) {
+		return report, rollback(source, report, logf, fmt.Errorf("verification finished after the pause expired"))
+	}
+	return report, nil
+}
+
+// pauseWrites pauses writes on every master and returns the masters paused
+func pauseWrites(ctx context.Context, client redis.UniversalClient, timeout time.Duration) ([]string, error) {
+	var mu sync.Mutex
+	var paused []string
+	err := forEachMaster(ctx, client, func(ctx context.Context, master *redis.Client) error {
+		if err := master.Do(ctx, "CLIENT", "PAUSE", timeout.Milliseconds(), "WRITE").Err(); err != nil {
+			return fmt.Errorf("failed to pause writes on %s: %w", master.Options().Addr, err)
+		}
+		mu.Lock()
+		paused = append(paused, master.Options().Addr)
+		mu.Unlock()
+		return nil
+	})
+	sort.Strings(paused)
+	return paused, err
+}
+
+// rollback lifts the pause on every source master
+func rollback(source redis.UniversalClient, report *CutoverReport, logf func(string, ...interface{}), cause error) error {
+	// The pause has to be lifted even when the cutover was interrupted
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := forEachMaster(ctx, source, func(ctx context.Context, master *redis.Client) error {
+		return master.Do(ctx, "CLIENT", "UNPAUSE").Err()
+	})
+	if err != nil {
+		logf("⚠ Failed to unpause every source master, writes resume at %s: %v\n", report.PausedUntil.Format(time.RFC3339), err)
+	} else if len(report.Paused) > 0 {
+		logf("✓ Writes on the source resumed\n")
+	}
+	report.RolledBack = true
+	return fmt.Errorf("%w: %w", ErrCutoverFailed, cause)
+}
+
+// waitDrained waits until the sync has nothing left to apply. The lag has to
+// stay at zero for a second so that changes still on their way are seen.
+func waitDrained(ctx context.Context, opts CutoverOptions, logf func(string, ...interface{})) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.DrainTimeout)
+	defer cancel()
+
+	var drainedSince time.Time
+	var last SyncStatus
+	for {
+		status, err := opts.Lag(ctx)
+		if err == nil {
+			last = status
+			if status.Phase != SyncPhaseStreaming {
+				return fmt.Errorf("sync stopped streaming (%s)", status.Phase)
+			}
+			if status.Queued == 0 && status.InFlight == 0 && status.LagBytes == 0 {
+				if drainedSince.IsZero() {
+					drainedSince = time.Now()
+				}
+				if time.Since(drainedSince) >= time.Second {
+					return nil
+				}
+			} else {
+				drainedSince = time.Time{}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return fmt.Errorf("failed to read sync status: %w", err)
+			}
+			return fmt.Errorf("sync did not drain within %s: %d queued, %d in flight, %d bytes behind",
+				opts.DrainTimeout, last.Queued, last.InFlight, last.LagBytes)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// verifyTarget compares a sample of values of source and target. Key counts
+// are only logged: the source still counts keys that expired during the
+// pause, which suspends expiry, and the target counts keys it had before and
+// what interrupted imports left behind.
+func verifyTarget(ctx context.Context, source, target redis.UniversalClient, opts CutoverOptions, report *CutoverReport, logf func(string, ...interface{})) error {
+	var err error
+	if opts.Pattern == "*" {
+		if report.SourceKeys, err = countKeys(ctx, source); err != nil {
+			return fmt.Errorf("failed to count source keys: %w", err)
+		}
+		if report.TargetKeys, err = countKeys(ctx, target); err != nil {
+			return fmt.Errorf("failed to count target keys: %w", err)
+		}
+		if report.SourceKeys != report.TargetKeys {
+			logf("⚠ Key counts differ: %d on the source, %d on the target\n", report.SourceKeys, report.TargetKeys)
+		} else {
+			logf("✓ Key counts match: %d\n", report.SourceKeys)
+		}
+	}
+
+	keys, err := sampleKeys(ctx, source, opts.Pattern, opts.Samples)
+	if err != nil {
+		return fmt.Errorf("failed to sample keys: %w", err)
+	}
+	for _, key := range keys {
+		if diff := compareKey(ctx, source, target, key); diff != "" {
+			report.Mismatches = append(report.Mismatches, fmt.Sprintf("%s: %s", key, diff))
+		}
+	}
+	report.Sampled = len(keys)
+	if len(report.Mismatches) > 0 {
+		return fmt.Errorf("%d of %d sampled keys differ", len(report.Mismatches), len(keys))
+	}
+	logf("✓ %d sampled keys match\n", len(keys))
+	return nil
+}
+
+// countKeys sums DBSIZE over every master
+func countKeys(ctx context.Context, client redis.UniversalClient) (int64, error) {
+	var mu sync.Mutex
+	var total int64
+	err := forEachMaster(ctx, client, func(ctx context.Context, master *redis.Client) error {
+		n, err := master.DBSize(ctx).Result()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		total += n
+		mu.Unlock()
+		return nil
+	})
+	return total, err
+}
+
+// sampleKeys picks up to n distinct random keys matching pattern, spread over the masters
+func sampleKeys(ctx context.Context, client redis.UniversalClient, pattern string, n int) ([]string, error) {
+	var masters []*redis.Client
+	var mu sync.Mutex
+	err := forEachMaster(ctx, client, func(ctx context.Context, master *redis.Client) error {
+		mu.Lock()
+		masters = append(masters, master)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil || len(masters) == 0 || n <= 0 {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	keys := make([]string, 0, n)
+	// Bounded, an empty or sparse keyspace yields fewer keys
+	for attempt := 0; attempt < 10*n && len(keys) < n; attempt++ {
+		key, err := masters[attempt%len(masters)].RandomKey(ctx).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !seen[key] && matchPattern(pattern, key) {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// compareKey returns how key differs between source and target, or "" when it matches
+func compareKey(ctx context.Context, source, target redis.UniversalClient, key string) string {
+	want, err := exportKey(ctx, source, key, false)
+	if errors.Is(err, ErrKeyExpired) {
+		// Expired since it was sampled
+		return ""
+	}
+	if err != nil {
+		// Types without a logical form are compared by their DUMP payload
+		want, err = exportKey(ctx, source, key, true)
+		if err != nil {
+			return fmt.Sprintf("failed to read source: %v", err)
+		}
+		got, err := exportKey(ctx, target, key, true)
+		if err != nil {
+			return fmt.Sprintf("failed to read target: %v", err)
+		}
+		if string(want.Dump) != string(got.Dump) {
+			return "values differ"
+		}
+		return compareTTL(want.TTL, got.TTL)
+	}
+
+	got, err := exportKey(ctx, target, key, false)
+	if errors.Is(err, ErrKeyExpired) {
+		return "missing on the target"
+	}
+	if err != nil {
+		return fmt.Sprintf("failed to read target: %v", err)
+	}
+	if want.Type != got.Type {
+		return fmt.Sprintf("type %s on the source, %s on the target", want.Type, got.Type)
+	}
+	if !reflect.DeepEqual(normalizeValue(want.Value), normalizeValue(got.Value)) {
+		return "values differ"
+	}
+	return compareTTL(want.TTL, got.TTL)
+}
+
+// compareTTL returns how two TTLs differ, allowing for the time between reads
+func compareTTL(want, got time.Duration) string {
+	if (want < 0) != (got < 0) {
+		return fmt.Sprintf("TTL %s on the source, %s on the target", want, got)
+	}
+	if diff := want - got; want > 0 && (diff > 5*time.Second || diff < -5*time.Second) {
+		return fmt.Sprintf("TTL %s on the source, %s on the target", want, got)
+	}
+	return ""
+}
+
+// normalizeValue sorts set members, which SMEMBERS returns in any order, and
+// drops the idle times of pending stream entries, which grow between reads
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []string:
+		sorted := append([]string(nil), v...)
+		sort.Strings(sorted)
+		return sorted
+	case StreamValue:
+		groups := make([]StreamGroup, len(v.Groups))
+		for i, group := range v.Groups {
+			groups[i] = group
+			groups[i].Consumers = make([]StreamConsumer, len(group.Consumers))
+			for j, consumer := range group.Consumers {
+				pending := make([]StreamPending, len(consumer.Pending))
+				for k, p := range consumer.Pending {
+					pending[k] = StreamPending{ID: p.ID, Deliveries: p.Deliveries}
+				}
+				groups[i].Consumers[j] = StreamConsumer{Name: consumer.Name, Pending: pending}
+			}
+		}
+		v.Groups = groups
+		return v
+	}
+	return value
+}
@@ -0,0 +1,89 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyTarget(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     map[string]interface{}
+		mismatches []string
+	}{
+		{"match", map[string]interface{}{"a": "1", "b": "2"}, nil},
+		// Counts that differ are only a warning
+		{"extra keys on the target", map[string]interface{}{"a": "1", "b": "2", "c": "3", chunkMarker("d"): "1"}, nil},
+		{"value differs", map[string]interface{}{"a": "1", "b": "x"}, []string{"b: values differ"}},
+		{"key missing", map[string]interface{}{"a": "1"}, []string{"b: missing on the target"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, target := newFakeRedis(), newFakeRedis()
+			source.data["a"], source.data["b"] = "1", "2"
+			target.data = tt.target
+
+			var logged []string
+			logf := func(format string, args ...interface{}) { logged = append(logged, format) }
+			opts := CutoverOptions{Pattern: "*", Samples: 10}
+			report := &CutoverReport{}
+			err := verifyTarget(context.Background(), fakeClient(t, source.handle), fakeClient(t, target.handle), opts, report, logf)
+
+			if (err != nil) != (tt.mismatches != nil) || strings.Join(report.Mismatches, ",") != strings.Join(tt.mismatches, ",") {
+				t.Errorf("verifyTarget = %v with mismatches %q, want %q", err, report.Mismatches, tt.mismatches)
+			}
+			if report.Sampled != 2 || report.SourceKeys != 2 || report.TargetKeys != int64(len(tt.target)) {
+				t.Errorf("report = %+v, want 2 keys sampled and counted on the source, %d on the target", report, len(tt.target))
+			}
+			if warned := strings.HasPrefix(logged[0], "⚠"); warned != (len(tt.target) != 2) {
+				t.Errorf("logged %q first, want a warning only when the counts differ", logged[0])
+			}
+		})
+	}
+}
+
+func TestWaitDrained(t *testing.T) {
+	streaming := SyncStatus{Phase: SyncPhaseStreaming}
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		status  func(call int) (SyncStatus, error)
+		err     string
+	}{
+		// The lag has to stay at zero for a second
+		{"drained", 3 * time.Second, func(call int) (SyncStatus, error) {
+			if call < 2 {
+				return SyncStatus{Phase: SyncPhaseStreaming, Queued: 3, LagBytes: 10}, nil
+			}
+			return streaming, nil
+		}, ""},
+		{"behind", 500 * time.Millisecond, func(int) (SyncStatus, error) {
+			return SyncStatus{Phase: SyncPhaseStreaming, InFlight: 1, LagBytes: 10}, nil
+		}, "sync did not drain within 500ms: 0 queued, 1 in flight, 10 bytes behind"},
+		{"stopped", time.Second, func(int) (SyncStatus, error) {
+			return SyncStatus{Phase: "failed"}, nil
+		}, "sync stopped streaming (failed)"},
+		{"no status", 500 * time.Millisecond, func(int) (SyncStatus, error) {
+			return SyncStatus{}, errors.New("connection refused")
+		}, "failed to read sync status: connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			opts := CutoverOptions{DrainTimeout: tt.timeout, Lag: func(context.Context) (SyncStatus, error) {
+				calls++
+				return tt.status(calls)
+			}}
+			got := ""
+			if err := waitDrained(context.Background(), opts, nil); err != nil {
+				got = err.Error()
+			}
+			if got != tt.err {
+				t.Errorf("waitDrained = %q, want %q", got, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package squirrel
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DumpFormatVersion is the version of the dump file layout. Version 2 writes
+// sorted set members in the logical schema, with scores as strings.
+const DumpFormatVersion = 2
+
+// DumpMetadata describes a dump file. It is written after the keys so that it
+// can record how the export ended.
+type DumpMetadata struct {
+	Version    int       `json:"version"`
+	Pattern    string    `json:"pattern"`
+	Partial    bool      `json:"partial"` // The export was interrupted or aborted
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	KeyCount   int       `json:"key_count"`
+
+	// Consistent dumps hold every key as of ConsistentAt: keys changed during
+	// the export appear again later in the dump, deleted keys as tombstones
+	Consistent   bool      `json:"consistent"`
+	ConsistentAt time.Time `json:"consistent_at,omitzero"`
+}
+
+// DumpWriter streams keys to a dump as they are exported:
+//
+//	{"keys": [
+//	  {...},
+//	  {...}
+//	],
+//	"metadata": {...}}
+type DumpWriter struct {
+	buf    *bufio.Writer
+	count  int
+	closed bool
+}
+
+// NewDumpWriter writes the dump header to w
+func NewDumpWriter(w io.Writer) (*DumpWriter, error) {
+	d := &DumpWriter{buf: bufio.NewWriter(w)}
+	if _, err := d.buf.WriteString("{\"keys\": ["); err != nil {
+		return nil, fmt.Errorf("failed to write output: %w", err)
+	}
+	return d, nil
+}
+
+// Write appends one key to the dump
+func (d *DumpWriter) Write(keyData *KeyData) error {
+	data, err := json.Marshal(keyData)
+	if err != nil {
+		return fmt.Errorf("failed to encode key %s: %w", keyData.Key, err)
+	}
+
+	sep := ",\n  "
+	if d.count == 0 {
+		sep = "\n  "
+	}
+	if _, err := d.buf.WriteString(sep); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	if _, err := d.buf.Write(data); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	d.count++
+	return nil
+}
+
+// Close terminates the key list, writes the metadata and flushes the dump.
+// The dump is valid JSON whether or not the export finished. The underlying
+// writer is not closed.
+func (d *DumpWriter) Close(meta DumpMetadata) error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	meta.Version = DumpFormatVersion
+	meta.KeyCount = d.count
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(d.buf, "\n],\n\"metadata\": %s}\n", data); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	if err := d.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+// DumpReader streams keys out of a dump. It also reads the plain JSON arrays
+// written by earlier versions.
+type DumpReader struct {
+	decoder  *json.Decoder
+	legacy   bool
+	inKeys   bool
+	done     bool
+	metadata *DumpMetadata
+}
+
+// NewDumpReader reads the dump header from r
+func NewDumpReader(r io.Reader) (*DumpReader, error) {
+	d := &DumpReader{decoder: json.NewDecoder(bufio.NewReader(r))}
+
+	token, err := d.decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	switch token {
+	case json.Delim('['):
+		d.legacy = true
+		d.inKeys = true
+	case json.Delim('{'):
+	default:
+		return nil, fmt.Errorf("failed to parse JSON: unexpected %v at start of dump", token)
+	}
+	return d, nil
+}
+
+// Next returns the next key, or io.EOF after the last one
+func (d *DumpReader) Next() (*KeyData, error) {
+	for !d.done {
+		if d.inKeys {
+			if d.decoder.More() {
+				var keyData KeyData
+				if err := d.decoder.Decode(&keyData); err != nil {
+					return nil, fmt.Errorf("failed to parse JSON: %w", err)
+				}
+				return &keyData, nil
+			}
+
+			// Closing ']' of the key list
+			if _, err := d.decoder.Token(); err != nil {
+				return nil, fmt.Errorf("failed to parse JSON: %w", err)
+			}
+			d.inKeys = false
+			if d.legacy {
+				d.done = true
+			}
+			continue
+		}
+
+		if !d.decoder.More() {
+			d.done = true
+			break
+		}
+
+		token, err := d.decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		switch token {
+		case "keys":
+			if token, err := d.decoder.Token(); err != nil || token != json.Delim('[') {
+				return nil, fmt.Errorf("failed to parse JSON: keys must be an array")
+			}
+			d.inKeys = true
+		case "metadata":
+			var meta DumpMetadata
+			if err := d.decoder.Decode(&meta); err != nil {
+				return nil, fmt.Errorf("failed to parse JSON metadata: %w", err)
+			}
+			d.metadata = &meta
+		default:
+			// Skip fields added by newer versions
+			var skip json.RawMessage
+			if err := d.decoder.Decode(&skip); err != nil {
+				return nil, fmt.Errorf("failed to parse JSON: %w", err)
+			}
+		}
+	}
+
+	return nil, io.EOF
+}
+
+// Metadata returns the dump metadata once it has been read, or nil
+func (d *DumpReader) Metadata() *DumpMetadata {
+	return d.metadata
+}
+
+// ReadDump reads every key of a dump
+func ReadDump(r io.Reader) ([]KeyData, *DumpMetadata, error) {
+	reader, err := NewDumpReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var keyDataList []KeyData
+	for {
+		keyData, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		keyDataList = append(keyDataList, *keyData)
+	}
+
+	return keyDataList, reader.Metadata(), nil
+}
+
+// SliceReader is a KeyReader over keys held in memory
+type SliceReader struct {
+	keys []KeyData
+	next int
+}
+
+// NewSliceReader returns a KeyReader yielding keys in order
+func NewSliceReader(keys []KeyData) *SliceReader {
+	return &SliceReader{keys: keys}
+}
+
+// Next returns the next key, or io.EOF after the last one
+func (s *SliceReader) Next() (*KeyData, error) {
+	if s.next >= len(s.keys) {
+		return nil, io.EOF
+	}
+	s.next++
+	return &s.keys[s.next-1], nil
+}
+
+// MultiReader is a KeyReader yielding the keys of several readers in turn,
+// for example of one RDB file per shard
+type MultiReader struct {
+	readers []KeyReader
+}
+
+// NewMultiReader returns a KeyReader over readers
+func NewMultiReader(readers ...KeyReader) *MultiReader {
+	return &MultiReader{readers: readers}
+}
+
+// Next returns the next key, or io.EOF after the last key of the last reader
+func (m *MultiReader) Next() (*KeyData, error) {
+	for len(m.readers) > 0 {
+		keyData, err := m.readers[0].Next()
+		if err != io.EOF {
+			return keyData, err
+		}
+		m.readers = m.readers[1:]
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,452 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ExportOptions configures an Exporter
+type ExportOptions struct {
+	RunOptions
+
+	Pattern      string   // Glob-style pattern of the keys to scan, "*" when empty
+	BatchSize    int64    // COUNT hint of every SCAN call
+	UseDump      bool     // Read keys with DUMP instead of by type
+	FromReplicas bool     // Scan and read the replica of every shard with the least lag; the cluster client needs ReadOnly
+	Keys         []string // Export exactly these keys instead of scanning
+
+	// ChunkSize is the number of elements per record of collections exported
+	// by type, DefaultChunkSize when 0. Bigger collections are read with
+	// ranged LRANGE and XRANGE or SSCAN, HSCAN and ZSCAN and written as
+	// several records.
+	ChunkSize int64
+
+	// MaxDumpSize moves keys whose DUMP payload would be bigger than this
+	// many bytes by type in chunks instead, with UseDump. MEMORY USAGE is
+	// asked before dumping, and the payload size is checked after. Module
+	// types cannot be read by type and keep their payload. 0 never falls
+	// back.
+	MaxDumpSize int64
+
+	// Consistent re-exports the keys changed while the export ran, using
+	// keyspace notifications of every master, and writes tombstones for the
+	// keys deleted in the meantime
+	Consistent bool
+	// ConfigureNotifications enables keyspace notifications on masters that
+	// do not publish them while a consistent export runs
+	ConfigureNotifications bool
+}
+
+// errOutput fails a key whose records could not be written; the write error itself stops the run
+var errOutput = errors.New("failed to write output")
+
+// maxCatchUpRounds bounds how often a consistent export re-exports changed keys
+const maxCatchUpRounds = 5
+
+// Exporter reads keys from a source
+type Exporter struct {
+	client redis.UniversalClient
+	opts   ExportOptions
+	keySet map[string]bool // Keys of opts.Keys
+
+	// Node read for every shard with FromReplicas, by master address
+	nodes    []shardNode
+	byMaster map[string]*redis.Client
+}
+
+// NewExporter returns an exporter reading from client
+func NewExporter(client redis.UniversalClient, opts ExportOptions) *Exporter {
+	if opts.Pattern == "" {
+		opts.Pattern = "*"
+	}
+	e := &Exporter{client: client, opts: opts}
+	if opts.Keys != nil {
+		e.keySet = make(map[string]bool, len(opts.Keys))
+		for _, key := range opts.Keys {
+			e.keySet[key] = true
+		}
+	}
+	return e
+}
+
+// Export writes every selected key to w. When ctx is cancelled, the key in
+// flight is finished and ErrInterrupted is returned with the summary so far.
+func (e *Exporter) Export(ctx context.Context, w KeyWriter) (*Summary, error) {
+	start := time.Now()
+	summary := &Summary{}
+	logf := logger(e.opts.Logf)
+
+	// A consistent export subscribes before scanning so that no change is missed
+	var watcher *keyspaceWatcher
+	var changed changedKeys
+	if e.opts.Consistent {
+		if e.opts.FromReplicas {
+			return summary, fmt.Errorf("a consistent export reads from masters and cannot use replicas")
+		}
+		var err error
+		watcher, err = watchKeyspace(ctx, e.client, e.opts.ConfigureNotifications, e.selected, changed.Add, logf)
+		if err != nil {
+			return summary, err
+		}
+		defer watcher.Close()
+		logf("✓ Watching keyspace events for changes during the export\n")
+	}
+
+	if err := e.selectNodes(ctx); err != nil {
+		return summary, err
+	}
+	keys := e.opts.Keys
+	if keys == nil {
+		var err error
+		keys, err = e.ScanKeys(ctx)
+		if err != nil {
+			summary.Duration = time.Since(start)
+			return summary, interruptedOr(ctx, err)
+		}
+	}
+	summary.Total = len(keys)
+
+	if len(keys) > 0 {
+		logf("Exporting key data...\n")
+	}
+
+	// Keys in flight are finished even after an interrupt
+	keyCtx := context.WithoutCancel(ctx)
+
+	var runErr error
+	for _, key := range keys {
+		if runErr = e.exportOne(ctx, keyCtx, w, summary, key, false); runErr != nil {
+			break
+		}
+	}
+
+	if watcher != nil && runErr == nil {
+		runErr = e.catchUp(ctx, keyCtx, w, summary, watcher, &changed)
+	}
+
+	summary.Duration = time.Since(start)
+	if runErr == nil && summary.Failed > 0 {
+		runErr = fmt.Errorf("%d keys failed to export: %w", summary.Failed, ErrPartial)
+	}
+	return summary, runErr
+}
+
+// exportOne exports key to w. With tombstones, a key that no longer exists is
+// written as deleted instead of being counted as expired. It returns an error
+// only when the run has to stop.
+func (e *Exporter) exportOne(ctx, keyCtx context.Context, w KeyWriter, summary *Summary, key string, tombstones bool) error {
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+
+	client, node := e.readerFor(ctx, key)
+	task := &keyTask{Key: key, Node: node, Phase: PhaseExport}
+	if err := e.opts.wait(ctx, task.Node, 1, 0); err != nil {
+		return err
+	}
+
+	// Records are written as they are read, an output error stops the run
+	var keyData *KeyData
+	var outputErr error
+	size := 0
+	write := func(record *KeyData) error {
+		if err := w.Write(record); err != nil {
+			if errors.Is(err, errUnwritable) || errors.Is(err, errNotUTF8) {
+				return err
+			}
+			outputErr = err
+			return errOutput
+		}
+		keyData = record
+		size += record.Size()
+		return nil
+	}
+
+	ok, err := e.opts.runKey(ctx, summary, task, func() error {
+		err := e.exportRecords(keyCtx, client, key, write)
+		if errors.Is(err, ErrKeyExpired) && tombstones {
+			return write(&KeyData{Key: key, Deleted: true})
+		}
+		return err
+	})
+	if outputErr != nil {
+		return outputErr
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if keyData != nil {
+			// Some chunks were written before the key failed: drop them on import
+			return w.Write(&KeyData{Key: key, Deleted: true})
+		}
+		return nil
+	}
+
+	if keyData.Deleted {
+		summary.Deleted++
+	} else if e.opts.UseDump && keyData.Dump == nil {
+		summary.Chunked++
+	}
+	e.opts.keyDone(summary, task)
+
+	// The size is only known once the value has been read
+	return e.opts.wait(ctx, task.Node, 0, size)
+}
+
+// exportRecords reads a key from client and writes it as one record, or by
+// type as one record per chunk. A retried key starts again at chunk 0.
+func (e *Exporter) exportRecords(ctx context.Context, client redis.UniversalClient, key string, write func(*KeyData) error) error {
+	if !e.opts.UseDump {
+		meta, err := exportMeta(ctx, client, key)
+		if err != nil {
+			return err
+		}
+		return exportChunks(ctx, client, meta, e.opts.ChunkSize, write)
+	}
+
+	// Keys known to be too big are not dumped at all
+	if e.oversized(ctx, client, key) {
+		meta, err := exportMeta(ctx, client, key)
+		if err != nil {
+			return err
+		}
+		if chunkable(meta.Type) {
+			return exportChunks(ctx, client, meta, e.opts.ChunkSize, write)
+		}
+	}
+
+	keyData, err := exportKey(ctx, client, key, true)
+	if err != nil {
+		return err
+	}
+	if e.opts.MaxDumpSize > 0 && int64(len(keyData.Dump)) > e.opts.MaxDumpSize && chunkable(keyData.Type) {
+		keyData.Dump = nil
+		return exportChunks(ctx, client, keyData, e.opts.ChunkSize, write)
+	}
+	return write(keyData)
+}
+
+// oversized reports whether MEMORY USAGE of a key exceeds MaxDumpSize. The
+// payload size is checked anyway, so servers rejecting the command are fine.
+func (e *Exporter) oversized(ctx context.Context, client redis.UniversalClient, key string) bool {
+	if e.opts.MaxDumpSize <= 0 {
+		return false
+	}
+	usage, err := client.MemoryUsage(ctx, key).Result()
+	return err == nil && usage > e.opts.MaxDumpSize
+}
+
+// selectNodes picks the node read for every shard once, with FromReplicas
+// and a cluster client
+func (e *Exporter) selectNodes(ctx context.Context) error {
+	cluster, ok := e.client.(*redis.ClusterClient)
+	if !e.opts.FromReplicas || !ok || e.nodes != nil {
+		return nil
+	}
+	nodes, err := selectShardNodes(ctx, cluster, logger(e.opts.Logf))
+	if err != nil {
+		return err
+	}
+	e.nodes = nodes
+	e.byMaster = make(map[string]*redis.Client, len(nodes))
+	for _, node := range nodes {
+		e.byMaster[node.MasterAddr] = node.Client
+	}
+	return nil
+}
+
+// readerFor returns the client reading key and the address of the node it
+// reads from: the node chosen for the shard of the key with FromReplicas, so
+// that every read of a key goes to the replica that was scanned, otherwise
+// the source client and the master of the key
+func (e *Exporter) readerFor(ctx context.Context, key string) (redis.UniversalClient, string) {
+	master := nodeForKey(ctx, e.client, key)
+	if node, ok := e.byMaster[master]; ok {
+		return node, node.Options().Addr
+	}
+	return e.client, master
+}
+
+// catchUp re-exports the keys changed since they were exported until a round
+// sees no further changes. The export is consistent as of the end of that round.
+func (e *Exporter) catchUp(ctx, keyCtx context.Context, w KeyWriter, summary *Summary, watcher *keyspaceWatcher, changed *changedKeys) error {
+	logf := logger(e.opts.Logf)
+
+	for round := 1; round <= maxCatchUpRounds; round++ {
+		keys := changed.Take()
+		if len(keys) == 0 {
+			if watcher.Lost() > 0 {
+				logf("⚠ Keyspace events were missed, the export is not consistent\n")
+				return nil
+			}
+			if summary.Failed > 0 {
+				logf("⚠ %d keys failed, the export is not consistent\n", summary.Failed)
+				return nil
+			}
+			summary.Consistent = true
+			summary.ConsistentAt = time.Now()
+			return nil
+		}
+
+		logf("Re-exporting %d keys changed during the export (round %d)...\n", len(keys), round)
+		summary.Total += len(keys)
+		summary.Changed += len(keys)
+		for _, key := range keys {
+			if err := e.exportOne(ctx, keyCtx, w, summary, key, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	logf("⚠ Keys kept changing after %d rounds, the export is not consistent\n", maxCatchUpRounds)
+	return nil
+}
+
+// selected reports whether key belongs to the export
+func (e *Exporter) selected(key string) bool {
+	if e.opts.Keys != nil {
+		return e.keySet[key]
+	}
+	return matchPattern(e.opts.Pattern, key)
+}
+
+// ScanKeys collects the keys matching the pattern from one node per shard
+func (e *Exporter) ScanKeys(ctx context.Context) ([]string, error) {
+	logf := logger(e.opts.Logf)
+
+	// Collect all keys from one node per shard using sync.Map
+	var allKeys sync.Map
+	var totalKeys int
+
+	if err := e.selectNodes(ctx); err != nil {
+		return nil, err
+	}
+	err := forEachScanNode(ctx, e.client, e.nodes, func(ctx context.Context, node *redis.Client) error {
+		addr := node.Options().Addr
+		notify(e.opts.OnEvent, Event{Type: EventScanNode, Phase: PhaseExport, Node: addr})
+
+		iter := node.Scan(ctx, 0, e.opts.Pattern, e.opts.BatchSize).Iterator()
+		nodeKeyCount := 0
+
+		for iter.Next(ctx) {
+			key := iter.Val()
+
+			if err := e.opts.wait(ctx, addr, 1, len(key)); err != nil {
+				return err
+			}
+
+			// LoadOrStore is atomic and returns true if the key was actually stored (was new)
+			if _, loaded := allKeys.LoadOrStore(key, true); !loaded {
+				nodeKeyCount++
+			}
+		}
+
+		if err := iter.Err(); err != nil {
+			return fmt.Errorf("scan error on %s:  %w", addr, err)
+		}
+
+		logf("  Found %d keys on %s\n", nodeKeyCount, addr)
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan cluster:  %w", err)
+	}
+
+	// Convert sync.Map to slice
+	keys := make([]string, 0)
+	allKeys.Range(func(key, value interface{}) bool {
+		keys = append(keys, key.(string))
+		totalKeys++
+		return true
+	})
+
+	notify(e.opts.OnEvent, Event{Type: EventScanDone, Phase: PhaseExport, Total: totalKeys})
+	return keys, nil
+}
+
+// ExportKey exports a single key with all its data
+func (e *Exporter) ExportKey(ctx context.Context, key string) (*KeyData, error) {
+	if err := e.selectNodes(ctx); err != nil {
+		return nil, err
+	}
+	client, _ := e.readerFor(ctx, key)
+	return exportKey(ctx, client, key, e.opts.UseDump)
+}
+
+// exportKey exports a single key with all its data
+func exportKey(ctx context.Context, client redis.UniversalClient, key string, useDump bool) (*KeyData, error) {
+	keyData, err := exportMeta(ctx, client, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if useDump {
+		// Use DUMP command for accurate serialization
+		dump, err := client.Dump(ctx, key).Result()
+		if err == redis.Nil {
+			return nil, ErrKeyExpired
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to dump key: %w", err)
+		}
+		keyData.Dump = []byte(dump)
+	} else {
+		// Fallback: export by type (less reliable for complex types)
+		value, err := exportValueByType(ctx, client, key, keyData.Type)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export value:   %w", err)
+		}
+		keyData.Value = value
+	}
+
+	return keyData, nil
+}
+
+// exportMeta reads the TTL and type of a key
+func exportMeta(ctx context.Context, client redis.UniversalClient, key string) (*KeyData, error) {
+	keyData := &KeyData{
+		Key: key,
+	}
+
+	// Get TTL
+	ttl, err := client.TTL(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get TTL:   %w", err)
+	}
+	if ttl == -2 {
+		return nil, ErrKeyExpired
+	}
+	keyData.TTL = ttl
+
+	// Get type
+	keyType, err := client.Type(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get type:  %w", err)
+	}
+	if keyType == "none" {
+		return nil, ErrKeyExpired
+	}
+	keyData.Type = keyType
+	return keyData, nil
+}
+
+// exportValueByType exports value based on Redis type. Collections are read
+// in chunks and joined, so that the server is never blocked for long.
+func exportValueByType(ctx context.Context, client redis.UniversalClient, key, keyType string) (interface{}, error) {
+	merger := &chunkMerger{keyType: keyType}
+	err := readChunks(ctx, client, key, keyType, DefaultChunkSize, func(value interface{}) error {
+		merger.add(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merger.result(), nil
+}
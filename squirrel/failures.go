@@ -0,0 +1,217 @@
+package squirrel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/seabfh/kv-squirrel/rdb"
+)
+
+// Phases of a run, as recorded in the failure report
+const (
+	PhaseExport = "export"
+	PhaseImport = "import"
+)
+
+// Error classes recorded in the failure report
+const (
+	ClassExpired     = "expired"
+	ClassTimeout     = "timeout"
+	ClassNetwork     = "network"
+	ClassCluster     = "cluster"
+	ClassLoading     = "loading"
+	ClassAuth        = "auth"
+	ClassBusyKey     = "busykey"
+	ClassPayload     = "payload"
+	ClassWrongType   = "wrongtype"
+	ClassUnsupported = "unsupported"
+	ClassInvalid     = "invalid"
+	ClassOther       = "other"
+)
+
+// ErrKeyExpired is returned when a key disappears between SCAN and DUMP
+var ErrKeyExpired = errors.New("key expired or was deleted before it could be read")
+
+// Errors about values, classified as ClassInvalid and ClassUnsupported. Their
+// text starts the messages that wrap them, e.g. "invalid zset value".
+var (
+	errInvalid     = errors.New("invalid")
+	errUnsupported = errors.New("unsupported type")
+)
+
+// replyError is an error reply read from a server without go-redis
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
+
+// RedisError marks the reply as a redis.Error
+func (replyError) RedisError() {}
+
+// FailureRecord is one line of the failure report
+type FailureRecord struct {
+	Key   string `json:"key"`
+	Phase string `json:"phase"`
+	Class string `json:"class"`
+	Error string `json:"error"`
+}
+
+// FailureReport writes failed keys as JSON lines. The file is only created
+// once the first record is written. A nil report discards records.
+type FailureReport struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewFailureReport returns a report writing to path, or nil when path is
+// empty. The report of an earlier run is removed so that it does not list
+// keys that succeeded since, unless resume is set: a resumed import appends
+// to the report of the run it continues.
+func NewFailureReport(path string, resume bool) (*FailureReport, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !resume {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove old failure report: %w", err)
+		}
+	}
+	return &FailureReport{path: path}, nil
+}
+
+// Record appends a failed key to the report
+func (r *FailureReport) Record(key, phase string, err error) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to create failure report: %w", err)
+		}
+		r.file = file
+		r.encoder = json.NewEncoder(file)
+	}
+
+	return r.encoder.Encode(FailureRecord{
+		Key:   key,
+		Phase: phase,
+		Class: ClassifyError(err),
+		Error: err.Error(),
+	})
+}
+
+// Close closes the report file if it was created
+func (r *FailureReport) Close() error {
+	if r == nil || r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// LoadFailedKeys returns the keys of a report that failed in phase, in report order.
+// Expired keys are not failures and are skipped.
+func LoadFailedKeys(path, phase string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open failure report: %w", err)
+	}
+	defer file.Close()
+
+	var keys []string
+	seen := make(map[string]bool)
+	decoder := json.NewDecoder(file)
+
+	for {
+		var record FailureRecord
+		if err := decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse failure report: %w", err)
+		}
+
+		if record.Phase != phase || record.Class == ClassExpired || seen[record.Key] {
+			continue
+		}
+		seen[record.Key] = true
+		keys = append(keys, record.Key)
+	}
+
+	return keys, nil
+}
+
+// ClassifyError returns the failure report class of an error. Only error
+// values and the first word of server replies are looked at: messages hold
+// key names, which must not change the class.
+func ClassifyError(err error) string {
+	if errors.Is(err, ErrKeyExpired) {
+		return ClassExpired
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ClassTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ClassTimeout
+	}
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return ClassNetwork
+	}
+
+	var reply redis.Error
+	if errors.As(err, &reply) {
+		return classifyReply(reply.Error())
+	}
+
+	var unsupported *rdb.UnsupportedTypeError
+	switch {
+	case errors.As(err, &unsupported), errors.Is(err, errUnsupported), errors.Is(err, errNotUTF8):
+		return ClassUnsupported
+	case errors.Is(err, errInvalid):
+		return ClassInvalid
+	default:
+		return ClassOther
+	}
+}
+
+// classifyReply returns the class of a server error reply from its prefix
+func classifyReply(msg string) string {
+	prefix, rest, _ := strings.Cut(msg, " ")
+	switch prefix {
+	case "MOVED", "ASK", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN":
+		return ClassCluster
+	case "IOERR":
+		// MIGRATE lost its connection to the target
+		return ClassNetwork
+	case "LOADING":
+		return ClassLoading
+	case "NOPERM", "NOAUTH", "WRONGPASS":
+		return ClassAuth
+	case "BUSYKEY":
+		return ClassBusyKey
+	case "WRONGTYPE":
+		return ClassWrongType
+	case "ERR":
+		// RESTORE rejects a payload with a fixed message
+		if strings.HasPrefix(rest, "DUMP payload version or checksum") || strings.HasPrefix(rest, "Bad data format") {
+			return ClassPayload
+		}
+	}
+	return ClassOther
+}
@@ -0,0 +1,117 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/seabfh/kv-squirrel/rdb"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("DUMP: %w", ErrKeyExpired), ClassExpired},
+		{context.DeadlineExceeded, ClassTimeout},
+		{&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, ClassTimeout},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, ClassNetwork},
+		{io.EOF, ClassNetwork},
+		{io.ErrClosedPipe, ClassNetwork},
+		{fmt.Errorf("write: %w", syscall.EPIPE), ClassNetwork},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), ClassNetwork},
+		{replyError("MOVED 3999 127.0.0.1:6381"), ClassCluster},
+		{replyError("ASK 3999 127.0.0.1:6381"), ClassCluster},
+		{replyError("CLUSTERDOWN The cluster is down"), ClassCluster},
+		{replyError("TRYAGAIN Multiple keys request during rehashing of slot"), ClassCluster},
+		{replyError("IOERR error or timeout reading to target instance"), ClassNetwork},
+		{fmt.Errorf("failed to restore key: %w", replyError("LOADING Redis is loading the dataset in memory")), ClassLoading},
+		{replyError("NOPERM this user has no permissions to run the 'dump' command"), ClassAuth},
+		{replyError("WRONGPASS invalid username-password pair"), ClassAuth},
+		{replyError("BUSYKEY Target key name already exists."), ClassBusyKey},
+		{replyError("ERR DUMP payload version or checksum are wrong"), ClassPayload},
+		{replyError("ERR Bad data format"), ClassPayload},
+		{replyError("WRONGTYPE Operation against a key holding the wrong kind of value"), ClassWrongType},
+		{replyError("ERR something else"), ClassOther},
+		{&rdb.UnsupportedTypeError{Type: 7}, ClassUnsupported},
+		{fmt.Errorf("%w: module", errUnsupported), ClassUnsupported},
+		{fmt.Errorf("key name: %w", errNotUTF8), ClassUnsupported},
+		{fmt.Errorf("%w score %q", errInvalid, "x"), ClassInvalid},
+		// Key names in messages do not change the class
+		{fmt.Errorf("failed to restore key LOADING:MOVED: %w", replyError("ERR invalid TTL value")), ClassOther},
+		{fmt.Errorf("failed to export key invalid:BUSYKEY: %w", io.EOF), ClassNetwork},
+		{errors.New("failed to export key invalid:LOADING"), ClassOther},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("ClassifyError(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFailureReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failures.jsonl")
+	type failure struct {
+		key, phase string
+		err        error
+	}
+	write := func(resume bool, failures ...failure) {
+		t.Helper()
+		report, err := NewFailureReport(path, resume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range failures {
+			if err := report.Record(f.key, f.phase, f.err); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := report.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	load := func(phase string) []string {
+		t.Helper()
+		keys, err := LoadFailedKeys(path, phase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+
+	write(false,
+		failure{"a", PhaseImport, errors.New("BUSYKEY Target key name already exists.")},
+		failure{"b", PhaseExport, errors.New("i/o timeout")},
+		failure{"a", PhaseImport, errors.New("LOADING")},
+		failure{"c", PhaseImport, ErrKeyExpired},
+		failure{"d", PhaseImport, errors.New("ERR")})
+	if got, want := load(PhaseImport), []string{"a", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("import failures = %q, want %q", got, want)
+	}
+	if got, want := load(PhaseExport), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("export failures = %q, want %q", got, want)
+	}
+
+	// A resumed run appends, any other run replaces the report
+	write(true, failure{"e", PhaseImport, errors.New("ERR")})
+	if got, want := load(PhaseImport), []string{"a", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("import failures after resuming = %q, want %q", got, want)
+	}
+	write(false)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a run without failures left the report of the previous run: %v", err)
+	}
+
+	var report *FailureReport
+	if err := report.Record("a", PhaseImport, errors.New("ERR")); err != nil {
+		t.Errorf("Record on a nil report = %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package squirrel
+
+// MatchPattern reports whether key matches a glob-style pattern the way
+// SCAN MATCH does
+func MatchPattern(pattern, key string) bool {
+	return matchPattern(pattern, key)
+}
+
+// matchPattern reports whether key matches a glob-style pattern the way
+// Redis does for SCAN MATCH and KEYS: * and ? wildcards, [abc], [^abc] and
+// [a-z] classes and \ escapes. Matching is byte-wise and case-sensitive.
+func matchPattern(pattern, key string) bool {
+	p, s := 0, 0
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			// Collapse consecutive stars
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for ; s <= len(key); s++ {
+				if matchPattern(pattern[p+1:], key[s:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if s >= len(key) {
+				return false
+			}
+			s++
+
+		case '[':
+			if s >= len(key) {
+				return false
+			}
+			p++
+			not := p < len(pattern) && pattern[p] == '^'
+			if not {
+				p++
+			}
+			match := false
+			for p < len(pattern) && pattern[p] != ']' {
+				switch {
+				case pattern[p] == '\\' && p+1 < len(pattern):
+					p++
+					if pattern[p] == key[s] {
+						match = true
+					}
+				case p+2 < len(pattern) && pattern[p+1] == '-':
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					if key[s] >= start && key[s] <= end {
+						match = true
+					}
+					p += 2
+				default:
+					if pattern[p] == key[s] {
+						match = true
+					}
+				}
+				p++
+			}
+			if p >= len(pattern) {
+				// Redis treats an unterminated class as ending at the pattern end
+				p--
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s++
+
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+
+		default:
+			if s >= len(key) || pattern[p] != key[s] {
+				return false
+			}
+			s++
+		}
+		p++
+	}
+	return s == len(key)
+}
@@ -0,0 +1,323 @@
+package squirrel
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	minThrottleDelay = time.Millisecond
+	maxThrottleDelay = time.Second
+)
+
+// HealthThresholds configures adaptive throttling. Zero disables a threshold.
+type HealthThresholds struct {
+	Interval       time.Duration // How often nodes are sampled
+	MaxLatency     time.Duration // Slow down when PING latency is above
+	MaxOpsPerSec   int64         // Slow down when instantaneous_ops_per_sec is above
+	MaxReplLag     int64         // Slow down when replication lag in bytes is above
+	PauseMemoryPct float64       // Pause when target used_memory/maxmemory is above
+	MaxPause       time.Duration // Abort when paused for longer than
+	AbortMemoryPct float64       // Abort when target used_memory/maxmemory is above
+}
+
+// nodeHealth is one sample of a master node
+type nodeHealth struct {
+	Addr      string
+	Latency   time.Duration
+	OpsPerSec int64
+	UsedMem   int64
+	MaxMem    int64
+	ReplLag   int64
+}
+
+func (h nodeHealth) memoryPct() float64 {
+	if h.MaxMem <= 0 {
+		return 0
+	}
+	return float64(h.UsedMem) / float64(h.MaxMem) * 100
+}
+
+// throttleState is the decision for one watched cluster
+type throttleState struct {
+	delay    time.Duration
+	paused   bool
+	pausedAt time.Time
+	maxPause time.Duration
+}
+
+// Governor slows down, pauses or aborts work based on server health.
+// It implements Throttle; a nil Governor never throttles.
+type Governor struct {
+	mu     sync.Mutex
+	states map[string]*throttleState
+	resume chan struct{}
+	err    error
+	logf   func(string, ...interface{})
+}
+
+// NewGovernor returns a governor logging its decisions through logf
+func NewGovernor(logf func(format string, args ...interface{})) *Governor {
+	return &Governor{
+		states: make(map[string]*throttleState),
+		resume: make(chan struct{}),
+		logf:   logger(logf),
+	}
+}
+
+// Wait blocks while any cluster is paused, then applies the current slowdown.
+// It returns an error once a hard limit was hit or a pause lasted too long.
+func (g *Governor) Wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+
+	for {
+		g.mu.Lock()
+		if g.err != nil {
+			g.mu.Unlock()
+			return g.err
+		}
+		paused := false
+		var delay time.Duration
+		var deadline time.Time
+		for name, state := range g.states {
+			if state.delay > delay {
+				delay = state.delay
+			}
+			if !state.paused {
+				continue
+			}
+			paused = true
+			if state.maxPause <= 0 {
+				continue
+			}
+			if time.Since(state.pausedAt) >= state.maxPause {
+				g.err = fmt.Errorf("health check: %s paused for more than %v: %w", name, state.maxPause, ErrAborted)
+				g.logf("✗ Throttle [%s]: aborting (paused for more than %v)\n", name, state.maxPause)
+				g.wake()
+				g.mu.Unlock()
+				return g.err
+			}
+			if until := state.pausedAt.Add(state.maxPause); deadline.IsZero() || until.Before(deadline) {
+				deadline = until
+			}
+		}
+		resume := g.resume
+		g.mu.Unlock()
+
+		if paused {
+			if err := waitResume(ctx, resume, deadline); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		return nil
+	}
+}
+
+// waitResume blocks until resume is closed or deadline passes, if it is set
+func waitResume(ctx context.Context, resume <-chan struct{}, deadline time.Time) error {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resume:
+	case <-expired:
+	}
+	return nil
+}
+
+// Watch samples the masters of a cluster until ctx is done. Memory limits
+// only apply when isTarget is set: exporting never lowers source memory, so
+// pausing on it would wait forever.
+func (g *Governor) Watch(ctx context.Context, name string, client redis.UniversalClient, isTarget bool, thresholds HealthThresholds) {
+	interval := thresholds.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	g.mu.Lock()
+	g.states[name] = &throttleState{maxPause: thresholds.MaxPause}
+	g.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		samples, err := sampleHealth(ctx, client)
+		if err != nil {
+			if ctx.Err() == nil {
+				g.logf("⚠ Health check on %s cluster failed: %v\n", name, err)
+			}
+			continue
+		}
+		g.evaluate(name, samples, isTarget, thresholds)
+	}
+}
+
+// evaluate updates the throttle state of a cluster from its latest samples
+func (g *Governor) evaluate(name string, samples []nodeHealth, isTarget bool, thresholds HealthThresholds) {
+	var abortReason, pauseReason, slowReason string
+
+	for _, h := range samples {
+		memPct := h.memoryPct()
+		switch {
+		case !isTarget:
+		case thresholds.AbortMemoryPct > 0 && memPct > thresholds.AbortMemoryPct:
+			abortReason = fmt.Sprintf("%s used_memory %.1f%% of maxmemory > %.1f%%", h.Addr, memPct, thresholds.AbortMemoryPct)
+		case thresholds.PauseMemoryPct > 0 && memPct > thresholds.PauseMemoryPct:
+			pauseReason = fmt.Sprintf("%s used_memory %.1f%% of maxmemory > %.1f%%", h.Addr, memPct, thresholds.PauseMemoryPct)
+		}
+
+		switch {
+		case thresholds.MaxLatency > 0 && h.Latency > thresholds.MaxLatency:
+			slowReason = fmt.Sprintf("%s latency %v > %v", h.Addr, h.Latency.Round(time.Microsecond), thresholds.MaxLatency)
+		case thresholds.MaxOpsPerSec > 0 && h.OpsPerSec > thresholds.MaxOpsPerSec:
+			slowReason = fmt.Sprintf("%s instantaneous_ops_per_sec %d > %d", h.Addr, h.OpsPerSec, thresholds.MaxOpsPerSec)
+		case thresholds.MaxReplLag > 0 && h.ReplLag > thresholds.MaxReplLag:
+			slowReason = fmt.Sprintf("%s replication lag %d bytes > %d", h.Addr, h.ReplLag, thresholds.MaxReplLag)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.err != nil {
+		return
+	}
+
+	state := g.states[name]
+	wasPaused := state.paused
+
+	switch {
+	case abortReason != "":
+		g.err = fmt.Errorf("health check: %s: %w", abortReason, ErrAborted)
+		g.logf("✗ Throttle [%s]: aborting (%s)\n", name, abortReason)
+		g.wake()
+		return
+
+	case pauseReason != "":
+		if !state.paused {
+			state.paused = true
+			state.pausedAt = time.Now()
+			g.logf("⏸ Throttle [%s]: pausing (%s)\n", name, pauseReason)
+		}
+		return
+
+	case slowReason != "":
+		state.paused = false
+		if state.delay == 0 {
+			state.delay = minThrottleDelay
+		} else if state.delay < maxThrottleDelay {
+			state.delay *= 2
+		}
+		g.logf("⚠ Throttle [%s]: slowing down to %v per key (%s)\n", name, state.delay, slowReason)
+
+	default:
+		state.paused = false
+		if state.delay > 0 {
+			state.delay /= 2
+			if state.delay < minThrottleDelay {
+				state.delay = 0
+			}
+			g.logf("Throttle [%s]: speeding up to %v per key (all metrics within thresholds)\n", name, state.delay)
+		}
+	}
+
+	if wasPaused && !state.paused {
+		g.logf("▶ Throttle [%s]: resuming (memory back below %.1f%%)\n", name, thresholds.PauseMemoryPct)
+		g.wake()
+	}
+}
+
+// wake releases callers blocked in Wait. The caller must hold g.mu.
+func (g *Governor) wake() {
+	close(g.resume)
+	g.resume = make(chan struct{})
+}
+
+// sampleHealth measures latency and reads INFO from every master of a cluster
+func sampleHealth(ctx context.Context, client redis.UniversalClient) ([]nodeHealth, error) {
+	var mu sync.Mutex
+	var samples []nodeHealth
+
+	err := forEachMaster(ctx, client, func(ctx context.Context, master *redis.Client) error {
+		h := nodeHealth{Addr: master.Options().Addr}
+
+		start := time.Now()
+		if err := master.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("ping %s: %w", h.Addr, err)
+		}
+		h.Latency = time.Since(start)
+
+		info, err := master.Info(ctx).Result()
+		if err != nil {
+			return fmt.Errorf("info %s: %w", h.Addr, err)
+		}
+		fields := parseInfo(info)
+		h.OpsPerSec, _ = strconv.ParseInt(fields["instantaneous_ops_per_sec"], 10, 64)
+		h.UsedMem, _ = strconv.ParseInt(fields["used_memory"], 10, 64)
+		h.MaxMem, _ = strconv.ParseInt(fields["maxmemory"], 10, 64)
+		h.ReplLag = replicaLag(fields)
+
+		mu.Lock()
+		samples = append(samples, h)
+		mu.Unlock()
+		return nil
+	})
+
+	return samples, err
+}
+
+// replicaLag returns how many bytes the slowest replica is behind its master
+func replicaLag(fields map[string]string) int64 {
+	masterOffset, err := strconv.ParseInt(fields["master_repl_offset"], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	var lag int64
+	for name, value := range fields {
+		// slave0:ip=10.0.0.2,port=6379,state=online,offset=1234,lag=0
+		if !strings.HasPrefix(name, "slave") || !strings.Contains(value, "offset=") {
+			continue
+		}
+		for _, part := range strings.Split(value, ",") {
+			if offset, ok := strings.CutPrefix(part, "offset="); ok {
+				replicaOffset, err := strconv.ParseInt(offset, 10, 64)
+				if err == nil && masterOffset-replicaOffset > lag {
+					lag = masterOffset - replicaOffset
+				}
+			}
+		}
+	}
+	return lag
+}
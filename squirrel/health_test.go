@@ -0,0 +1,101 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGovernorEvaluate(t *testing.T) {
+	thresholds := HealthThresholds{
+		MaxLatency:     10 * time.Millisecond,
+		PauseMemoryPct: 80,
+		AbortMemoryPct: 90,
+	}
+	healthy := nodeHealth{Addr: "a", Latency: time.Millisecond, UsedMem: 50, MaxMem: 100}
+	slow := nodeHealth{Addr: "a", Latency: 20 * time.Millisecond, UsedMem: 50, MaxMem: 100}
+	full := nodeHealth{Addr: "a", Latency: time.Millisecond, UsedMem: 85, MaxMem: 100}
+	overfull := nodeHealth{Addr: "a", Latency: time.Millisecond, UsedMem: 95, MaxMem: 100}
+
+	tests := []struct {
+		name     string
+		state    throttleState
+		sample   nodeHealth
+		isTarget bool
+		want     throttleState
+		aborted  bool
+	}{
+		{"healthy", throttleState{}, healthy, true, throttleState{}, false},
+		{"slow down", throttleState{}, slow, true, throttleState{delay: minThrottleDelay}, false},
+		{"slow down further", throttleState{delay: 4 * time.Millisecond}, slow, true, throttleState{delay: 8 * time.Millisecond}, false},
+		{"slowdown capped", throttleState{delay: maxThrottleDelay}, slow, true, throttleState{delay: maxThrottleDelay}, false},
+		{"speed up", throttleState{delay: 4 * time.Millisecond}, healthy, true, throttleState{delay: 2 * time.Millisecond}, false},
+		{"speed up to full speed", throttleState{delay: minThrottleDelay}, healthy, true, throttleState{}, false},
+		{"pause target", throttleState{}, full, true, throttleState{paused: true}, false},
+		{"stay paused", throttleState{paused: true}, full, true, throttleState{paused: true}, false},
+		{"resume target", throttleState{paused: true}, healthy, true, throttleState{}, false},
+		{"abort target", throttleState{}, overfull, true, throttleState{}, true},
+		{"source memory ignored", throttleState{}, overfull, false, throttleState{}, false},
+		{"source slow down", throttleState{}, slow, false, throttleState{delay: minThrottleDelay}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGovernor(nil)
+			state := tt.state
+			g.states["cluster"] = &state
+			resume := g.resume
+
+			g.evaluate("cluster", []nodeHealth{tt.sample}, tt.isTarget, thresholds)
+
+			if state.delay != tt.want.delay || state.paused != tt.want.paused {
+				t.Errorf("state = %v delay, paused %v, want %v, %v", state.delay, state.paused, tt.want.delay, tt.want.paused)
+			}
+			if aborted := errors.Is(g.err, ErrAborted); aborted != tt.aborted {
+				t.Errorf("aborted = %v (%v), want %v", aborted, g.err, tt.aborted)
+			}
+			woken := false
+			select {
+			case <-resume:
+				woken = true
+			default:
+			}
+			if want := tt.aborted || tt.state.paused && !tt.want.paused; woken != want {
+				t.Errorf("woke waiters = %v, want %v", woken, want)
+			}
+		})
+	}
+}
+
+func TestGovernorWait(t *testing.T) {
+	g := NewGovernor(nil)
+	g.states["target"] = &throttleState{maxPause: time.Hour}
+	full := []nodeHealth{{Addr: "a", UsedMem: 85, MaxMem: 100}}
+	thresholds := HealthThresholds{PauseMemoryPct: 80}
+
+	g.evaluate("target", full, true, thresholds)
+	done := make(chan error)
+	go func() { done <- g.Wait(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Wait() = %v while paused", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	g.evaluate("target", []nodeHealth{{Addr: "a", UsedMem: 50, MaxMem: 100}}, true, thresholds)
+	if err := <-done; err != nil {
+		t.Fatalf("Wait() after resume = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.evaluate("target", full, true, thresholds)
+	cancel()
+	if err := g.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() with a cancelled context = %v", err)
+	}
+
+	g.states["target"].maxPause = 10 * time.Millisecond
+	if err := g.Wait(context.Background()); !errors.Is(err, ErrAborted) {
+		t.Errorf("Wait() after a long pause = %v, want ErrAborted", err)
+	}
+}
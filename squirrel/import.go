@@ -0,0 +1,401 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ImportOptions configures an Importer
+type ImportOptions struct {
+	RunOptions
+
+	UseDump bool // Restore DUMP payloads when present instead of writing by type
+	Skip    int  // Skip the first records of the reader, every chunk counting as one, e.g. to resume an import
+	Total   int  // Number of keys the reader yields when known, used for progress and MaxFailures
+	Failed  int  // Failures of earlier attempts of the same import, counted against MaxFailures
+
+	// Conflict decides what happens to keys that already exist on the
+	// target: ConflictReplace (default), ConflictSkip or ConflictFail
+	Conflict string
+}
+
+// Conflict policies for keys that already exist on the target
+const (
+	ConflictReplace = "replace" // Overwrite the existing key
+	ConflictSkip    = "skip"    // Keep the existing key and count the key as skipped
+	ConflictFail    = "fail"    // Keep the existing key and count the key as failed
+)
+
+// ErrKeyExists is returned for a key left alone because it already exists on
+// the target and the conflict policy is ConflictSkip
+var ErrKeyExists = errors.New("key already exists on the target")
+
+// errBusyKey is returned for an existing key with ConflictFail, classified like the BUSYKEY error of RESTORE
+var errBusyKey = errors.New("BUSYKEY target key already exists")
+
+// Importer writes keys to a target
+type Importer struct {
+	client redis.UniversalClient
+	opts   ImportOptions
+}
+
+// NewImporter returns an importer writing to client
+func NewImporter(client redis.UniversalClient, opts ImportOptions) *Importer {
+	opts.priorFailures = opts.Failed
+	return &Importer{client: client, opts: opts}
+}
+
+// Import writes every key of r to the target. When ctx is cancelled, the key
+// in flight is finished, with all its chunks, and ErrInterrupted is returned
+// with the summary so far; Skip+Processed is then the index of the first
+// record that was not imported, always the first chunk of a key.
+func (i *Importer) Import(ctx context.Context, r KeyReader) (*Summary, error) {
+	start := time.Now()
+	summary := &Summary{Total: i.opts.Total}
+
+	// Keys in flight are finished even after an interrupt
+	keyCtx := context.WithoutCancel(ctx)
+
+	// Key whose remaining chunks are dropped because an earlier one failed or was skipped
+	var dropped string
+	// The last record had more chunks: the run only stops between keys, so
+	// that a resumed import never starts in the middle of one
+	inKey := false
+
+	var runErr error
+	for index := 0; ; index++ {
+		if ctx.Err() != nil && !inKey {
+			runErr = ErrInterrupted
+			break
+		}
+
+		keyData, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			runErr = err
+			break
+		}
+		inKey = keyData.More
+		if index < i.opts.Skip {
+			continue
+		}
+		if keyData.Chunk > 0 && keyData.Key == dropped {
+			summary.Processed++
+			continue
+		}
+
+		waitCtx := ctx
+		if keyData.Chunk > 0 {
+			waitCtx = keyCtx
+		}
+		task := &keyTask{Key: keyData.Key, Node: nodeForKey(ctx, i.client, keyData.Key), Phase: PhaseImport}
+		if err := i.opts.wait(waitCtx, task.Node, 1, keyData.Size()); err != nil {
+			runErr = err
+			break
+		}
+
+		var fallback bool
+		ok, err := i.opts.runKey(ctx, summary, task, func() error {
+			var err error
+			fallback, err = i.importKey(keyCtx, keyData)
+			return err
+		})
+		if err != nil {
+			runErr = err
+			break
+		}
+		switch {
+		case !ok && keyData.More:
+			dropped = keyData.Key
+		case ok && keyData.More:
+			// The key is done with its last chunk
+		case ok:
+			if keyData.Deleted {
+				summary.Deleted++
+			}
+			if fallback {
+				summary.Fallback++
+			}
+			i.opts.keyDone(summary, task)
+		}
+	}
+
+	summary.Duration = time.Since(start)
+	if runErr == nil && summary.Total == 0 {
+		// Without a total up front, a percentage is checked once every key was read
+		runErr = i.opts.MaxFailures.Check(i.opts.priorFailures+summary.Failed, i.opts.Skip+summary.Processed)
+	}
+	if runErr == nil && summary.Failed > 0 {
+		runErr = fmt.Errorf("%d keys failed to import: %w", summary.Failed, ErrPartial)
+	}
+	return summary, runErr
+}
+
+// ImportKey imports a single key, applying the conflict policy
+func (i *Importer) ImportKey(ctx context.Context, keyData *KeyData) error {
+	_, err := i.importKey(ctx, keyData)
+	return err
+}
+
+// importKey imports a single key, applying the conflict policy. It reports
+// whether the key was written by type because its payload was rejected.
+func (i *Importer) importKey(ctx context.Context, keyData *KeyData) (bool, error) {
+	// Later chunks add to the key the first chunk created
+	if !keyData.Deleted && keyData.Chunk == 0 && i.opts.Conflict != "" && i.opts.Conflict != ConflictReplace {
+		exists, err := i.client.Exists(ctx, keyData.Key).Result()
+		if err != nil {
+			return false, fmt.Errorf("failed to check key: %w", err)
+		}
+		if exists > 0 {
+			if i.opts.Conflict == ConflictSkip {
+				return false, ErrKeyExists
+			}
+			return false, errBusyKey
+		}
+	}
+	return importKey(ctx, i.client, keyData, i.opts.UseDump)
+}
+
+// importKey imports a single key. A DUMP payload the target rejects, because
+// it does not read its RDB version, is decoded and written by type instead;
+// the result reports when that happened.
+func importKey(ctx context.Context, client redis.UniversalClient, keyData *KeyData, useDump bool) (bool, error) {
+	if err := keyData.Err(); err != nil {
+		return false, err
+	}
+	if keyData.Deleted {
+		// Tombstone of a key deleted during a consistent export, or of a key
+		// that failed after some of its chunks were written
+		return false, client.Del(ctx, keyData.Key, tempKey(keyData.Key), chunkMarker(keyData.Key)).Err()
+	}
+
+	if useDump && len(keyData.Dump) > 0 {
+		// Use RESTORE command
+		ttl := keyData.TTL
+		if ttl < 0 {
+			ttl = 0 // No expiration
+		}
+
+		err := client.RestoreReplace(ctx, keyData.Key, ttl, string(keyData.Dump)).Err()
+		if err == nil || ClassifyError(err) != ClassPayload {
+			return false, err
+		}
+		if fallbackErr := restoreByType(ctx, client, keyData); fallbackErr != nil {
+			return false, fmt.Errorf("%w, writing it by type failed too: %v", err, fallbackErr)
+		}
+		return true, nil
+	}
+
+	// Fallback:  import by type
+	return false, importValueByType(ctx, client, keyData)
+}
+
+// restoreByType decodes the DUMP payload of a key and writes it by type,
+// replacing the key like RESTORE REPLACE does
+func restoreByType(ctx context.Context, client redis.UniversalClient, keyData *KeyData) error {
+	keyType, value, err := DecodeDump(keyData.Dump)
+	if err != nil {
+		return fmt.Errorf("failed to decode DUMP payload: %w", err)
+	}
+	return importValueByType(ctx, client, &KeyData{Key: keyData.Key, Type: keyType, TTL: keyData.TTL, Value: value})
+}
+
+// tempKeyTTL expires the temporary key of a chunked import that was abandoned
+const tempKeyTTL = time.Hour
+
+// errMissingChunks fails a chunk whose predecessors are not in the temporary
+// key, because they were never imported there or it expired
+var errMissingChunks = errors.New("earlier chunks of the key are missing, import it again from chunk 0")
+
+// importValueByType imports value based on Redis type. Every record is
+// written in one MULTI/EXEC that replaces the key and sets its TTL, so that
+// importing it again gives the same result and a failure leaves the key as it
+// was. A key exported in chunks is built in tempKey and renamed into place by
+// the last chunk, so that readers never see half of it.
+func importValueByType(ctx context.Context, client redis.UniversalClient, keyData *KeyData) error {
+	if keyData.Chunk > 0 || keyData.More {
+		return importChunk(ctx, client, keyData)
+	}
+
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keyData.Key)
+		if err := writeValue(ctx, pipe, keyData.Key, keyData); err != nil {
+			return err
+		}
+		if keyData.TTL > 0 {
+			pipe.PExpire(ctx, keyData.Key, keyData.TTL)
+		}
+		return nil
+	})
+	return err
+}
+
+// importChunk writes a record of a key exported in chunks to tempKey. The
+// marker next to it counts the chunks applied and is set in the same
+// MULTI/EXEC, under WATCH: a chunk retried after its EXEC went through but the
+// reply was lost is not applied twice, and a chunk whose predecessors are not
+// in the temporary key fails instead of going live without them. The marker
+// is deleted once the last chunk is in place.
+func importChunk(ctx context.Context, client redis.UniversalClient, keyData *KeyData) error {
+	marker := chunkMarker(keyData.Key)
+	if err := applyChunk(ctx, client, keyData, marker); err != nil {
+		return err
+	}
+	if !keyData.More {
+		// Expires with the temporary key otherwise
+		client.Del(ctx, marker)
+	}
+	return nil
+}
+
+// applyChunk writes a chunk to tempKey unless marker shows it was applied
+func applyChunk(ctx context.Context, client redis.UniversalClient, keyData *KeyData, marker string) error {
+	temp := tempKey(keyData.Key)
+	return client.Watch(ctx, func(tx *redis.Tx) error {
+		if keyData.Chunk > 0 {
+			applied, err := tx.Get(ctx, marker).Int()
+			if err != nil && err != redis.Nil {
+				return fmt.Errorf("failed to read chunk marker: %w", err)
+			}
+			if applied > keyData.Chunk {
+				// Applied by an earlier attempt
+				return nil
+			}
+			if applied < keyData.Chunk {
+				return fmt.Errorf("chunk %d: %w", keyData.Chunk, errMissingChunks)
+			}
+			if exists, err := tx.Exists(ctx, temp).Result(); err != nil || exists == 0 {
+				if err != nil {
+					return err
+				}
+				return fmt.Errorf("chunk %d: %w", keyData.Chunk, errMissingChunks)
+			}
+		}
+
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if keyData.Chunk == 0 {
+				// Drops what an earlier attempt left behind
+				pipe.Del(ctx, temp)
+			}
+			if err := writeValue(ctx, pipe, temp, keyData); err != nil {
+				return err
+			}
+
+			switch {
+			case keyData.More:
+				pipe.PExpire(ctx, temp, tempKeyTTL)
+			case keyData.TTL > 0:
+				pipe.PExpire(ctx, temp, keyData.TTL)
+			default:
+				pipe.Persist(ctx, temp)
+			}
+			if !keyData.More {
+				pipe.Rename(ctx, temp, keyData.Key)
+			}
+			pipe.Set(ctx, marker, keyData.Chunk+1, tempKeyTTL)
+			return nil
+		})
+		return err
+	}, marker)
+}
+
+// writeValue queues the commands writing the value of a record to key.
+// Collections are written with variadic commands of DefaultChunkSize
+// elements.
+func writeValue(ctx context.Context, pipe redis.Pipeliner, key string, keyData *KeyData) error {
+	switch keyData.Type {
+	case "string":
+		val, ok := keyData.Value.(string)
+		if !ok {
+			return fmt.Errorf("%w string value", errInvalid)
+		}
+		pipe.Set(ctx, key, val, 0)
+
+	case "list", "set":
+		vals, ok := elements(keyData.Value)
+		if !ok {
+			return fmt.Errorf("%w %s value", errInvalid, keyData.Type)
+		}
+		for start := 0; start < len(vals); start += DefaultChunkSize {
+			batch := vals[start:min(start+DefaultChunkSize, len(vals))]
+			if keyData.Type == "list" {
+				pipe.RPush(ctx, key, batch...)
+			} else {
+				pipe.SAdd(ctx, key, batch...)
+			}
+		}
+
+	case "hash":
+		vals, ok := hashFields(keyData.Value)
+		if !ok {
+			return fmt.Errorf("%w hash value", errInvalid)
+		}
+		batch := make([]interface{}, 0, 2*min(len(vals), DefaultChunkSize))
+		for field, value := range vals {
+			batch = append(batch, field, value)
+			if len(batch) == 2*DefaultChunkSize {
+				pipe.HSet(ctx, key, batch...)
+				batch = make([]interface{}, 0, 2*DefaultChunkSize)
+			}
+		}
+		if len(batch) > 0 {
+			pipe.HSet(ctx, key, batch...)
+		}
+
+	case "zset":
+		members, ok := keyData.Value.([]redis.Z)
+		if !ok {
+			return fmt.Errorf("%w zset value", errInvalid)
+		}
+		for start := 0; start < len(members); start += DefaultChunkSize {
+			pipe.ZAdd(ctx, key, members[start:min(start+DefaultChunkSize, len(members))]...)
+		}
+
+	case "stream":
+		return writeStream(ctx, pipe, key, keyData)
+
+	default:
+		return fmt.Errorf("%w:  %s", errUnsupported, keyData.Type)
+	}
+	return nil
+}
+
+// elements returns the elements of a list or set value, either read from a
+// dump or held as exported
+func elements(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		vals := make([]interface{}, len(v))
+		for i, s := range v {
+			vals[i] = s
+		}
+		return vals, true
+	default:
+		return nil, false
+	}
+}
+
+// hashFields returns the fields of a hash value, either read from a dump or held as exported
+func hashFields(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[string]string:
+		fields := make(map[string]interface{}, len(v))
+		for field, s := range v {
+			fields[field] = s
+		}
+		return fields, true
+	default:
+		return nil, false
+	}
+}
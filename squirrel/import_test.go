@@ -0,0 +1,231 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis keeps strings and lists in memory for the commands that import
+// and read them, transactions included. WATCH conflicts are not detected.
+type fakeRedis struct {
+	mu     sync.Mutex
+	data   map[string]interface{} // string or []string
+	ttl    map[string]int64       // Milliseconds
+	queue  [][]string             // Commands queued since MULTI
+	multi  bool
+	random int // Keys returned by RANDOMKEY so far
+
+	// loseExec applies the next EXEC but hangs up instead of replying
+	loseExec bool
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{data: make(map[string]interface{}), ttl: make(map[string]int64)}
+}
+
+func (f *fakeRedis) handle(args []string) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch name := strings.ToLower(args[0]); {
+	case name == "multi":
+		f.multi, f.queue = true, nil
+		return "OK"
+	case name == "exec":
+		f.multi = false
+		replies := make([]interface{}, len(f.queue))
+		for i, command := range f.queue {
+			replies[i] = f.apply(command)
+		}
+		if f.loseExec {
+			f.loseExec = false
+			return fakeHangUp{}
+		}
+		return replies
+	case f.multi:
+		f.queue = append(f.queue, args)
+		return "QUEUED"
+	default:
+		return f.apply(args)
+	}
+}
+
+// apply runs a command outside of a transaction
+func (f *fakeRedis) apply(args []string) interface{} {
+	key := ""
+	if len(args) > 1 {
+		key = args[1]
+	}
+	switch strings.ToLower(args[0]) {
+	case "watch", "unwatch":
+		return "OK"
+	case "get":
+		if s, ok := f.data[key].(string); ok {
+			return s
+		}
+		return nil
+	case "type":
+		switch f.data[key].(type) {
+		case string:
+			return "string"
+		case []string:
+			return "list"
+		}
+		return "none"
+	case "ttl":
+		if _, ok := f.data[key]; !ok {
+			return -2
+		}
+		if ms, ok := f.ttl[key]; ok {
+			return int(ms / 1000)
+		}
+		return -1
+	case "dbsize":
+		return len(f.data)
+	case "randomkey":
+		// Every key in turn
+		if len(f.data) == 0 {
+			return nil
+		}
+		keys := make([]string, 0, len(f.data))
+		for key := range f.data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		f.random++
+		return keys[f.random%len(keys)]
+	case "exists":
+		if _, ok := f.data[key]; ok {
+			return 1
+		}
+		return 0
+	case "set":
+		f.data[key] = args[2]
+		delete(f.ttl, key)
+		if len(args) == 5 && strings.EqualFold(args[3], "ex") {
+			seconds, _ := strconv.ParseInt(args[4], 10, 64)
+			f.ttl[key] = seconds * 1000
+		}
+		return "OK"
+	case "del":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := f.data[key]; ok {
+				deleted++
+			}
+			delete(f.data, key)
+			delete(f.ttl, key)
+		}
+		return deleted
+	case "rpush":
+		list, _ := f.data[key].([]string)
+		list = append(list, args[2:]...)
+		f.data[key] = list
+		return len(list)
+	case "pexpire":
+		ms, _ := strconv.ParseInt(args[2], 10, 64)
+		f.ttl[key] = ms
+		return 1
+	case "persist":
+		delete(f.ttl, key)
+		return 1
+	case "rename":
+		if _, ok := f.data[key]; !ok {
+			return errors.New("ERR no such key")
+		}
+		f.data[args[2]], f.ttl[args[2]] = f.data[key], f.ttl[key]
+		delete(f.data, key)
+		delete(f.ttl, key)
+		if f.ttl[args[2]] == 0 {
+			delete(f.ttl, args[2])
+		}
+		return "OK"
+	}
+	return errors.New("ERR unknown command " + args[0])
+}
+
+func TestImportChunks(t *testing.T) {
+	ctx := context.Background()
+	temp, marker := tempKey("l"), chunkMarker("l")
+	chunk := func(n int, more bool, elements ...string) *KeyData {
+		return &KeyData{Key: "l", Type: "list", TTL: 5 * time.Second, Value: elements, Chunk: n, More: more}
+	}
+
+	server := newFakeRedis()
+	client := fakeClient(t, server.handle)
+
+	// Leftovers of an earlier attempt are replaced by chunk 0
+	server.data[temp], server.data[marker] = []string{"old"}, "3"
+	if err := importValueByType(ctx, client, chunk(0, true, "a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if list := server.data[temp]; !reflect.DeepEqual(list, []string{"a", "b"}) || server.data[marker] != "1" {
+		t.Errorf("after chunk 0, temporary key = %q and marker = %v, want [a b] and 1", list, server.data[marker])
+	}
+	if server.ttl[temp] != tempKeyTTL.Milliseconds() {
+		t.Errorf("temporary key expires in %dms, want %v", server.ttl[temp], tempKeyTTL)
+	}
+	if _, ok := server.data["l"]; ok {
+		t.Error("key is live before its last chunk")
+	}
+
+	// The last chunk renames the key into place with its TTL
+	if err := importValueByType(ctx, client, chunk(1, false, "c")); err != nil {
+		t.Fatal(err)
+	}
+	if list := server.data["l"]; !reflect.DeepEqual(list, []string{"a", "b", "c"}) || server.ttl["l"] != 5000 {
+		t.Errorf("after the last chunk, key = %q with TTL %dms, want [a b c] with 5000ms", list, server.ttl["l"])
+	}
+	for _, key := range []string{temp, marker} {
+		if _, ok := server.data[key]; ok {
+			t.Errorf("%s is left after the last chunk", key)
+		}
+	}
+
+	// A chunk cannot be resumed without the chunks before it
+	server = newFakeRedis()
+	client = fakeClient(t, server.handle)
+	server.data[marker] = "1"
+	if err := importValueByType(ctx, client, chunk(1, true, "c")); !errors.Is(err, errMissingChunks) {
+		t.Errorf("chunk 1 without the temporary key = %v, want %v", err, errMissingChunks)
+	}
+	delete(server.data, marker)
+	server.data[temp] = []string{"a"}
+	if err := importValueByType(ctx, client, chunk(1, true, "c")); !errors.Is(err, errMissingChunks) {
+		t.Errorf("chunk 1 without a marker = %v, want %v", err, errMissingChunks)
+	}
+	if list := server.data[temp]; !reflect.DeepEqual(list, []string{"a"}) {
+		t.Errorf("failed chunk changed the temporary key to %q", list)
+	}
+}
+
+func TestImportChunkReplayed(t *testing.T) {
+	ctx := context.Background()
+	temp, marker := tempKey("l"), chunkMarker("l")
+	server := newFakeRedis()
+	client := fakeClient(t, server.handle)
+
+	if err := importValueByType(ctx, client, &KeyData{Key: "l", Type: "list", Value: []string{"a"}, More: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunk 1 is applied but the reply of its EXEC is lost, so it is retried
+	chunk := &KeyData{Key: "l", Type: "list", Value: []string{"b"}, Chunk: 1, More: true}
+	server.loseExec = true
+	if err := importValueByType(ctx, client, chunk); err == nil {
+		t.Fatal("import with a lost EXEC reply succeeded")
+	}
+	if err := importValueByType(ctx, client, chunk); err != nil {
+		t.Fatalf("retried chunk: %v", err)
+	}
+	if list := server.data[temp]; !reflect.DeepEqual(list, []string{"a", "b"}) || server.data[marker] != "2" {
+		t.Errorf("after the retry, temporary key = %q and marker = %v, want [a b] and 2", list, server.data[marker])
+	}
+}
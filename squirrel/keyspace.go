@@ -0,0 +1,283 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyspaceEvents are the notify-keyspace-events classes needed to see every
+// change of a key: K for keyspace channels and the classes that A stands for,
+// including d for the keys of module types since Redis 7. Servers collapse
+// the full set to A, so older ones without d still pass.
+const keyspaceEvents = "g$lshzxetd"
+
+// keyspaceWatcher passes the keys changed on every master to a callback
+type keyspaceWatcher struct {
+	client    redis.UniversalClient
+	configure bool
+	match     func(key string) bool
+	onKey     func(key string)
+	logf      func(format string, args ...interface{})
+
+	mu       sync.Mutex
+	subs     map[string]*redis.PubSub // By master address
+	lost     int                      // Times events may have been missed
+	closed   bool
+	restores []notifyConfig
+	wg       sync.WaitGroup
+}
+
+// notifyConfig is a notify-keyspace-events setting to put back on a node
+type notifyConfig struct {
+	node  *redis.Client
+	value string
+}
+
+// watchKeyspace subscribes to the keyspace notifications of every master and
+// calls onKey, from any goroutine, with the changed keys for which match
+// returns true. With configure, masters that do not publish the notifications
+// are reconfigured for the duration of the watch; otherwise they are an error.
+func watchKeyspace(ctx context.Context, client redis.UniversalClient, configure bool, match func(string) bool, onKey func(string), logf func(format string, args ...interface{})) (*keyspaceWatcher, error) {
+	w := &keyspaceWatcher{
+		client:    client,
+		configure: configure,
+		match:     match,
+		onKey:     onKey,
+		logf:      logf,
+		subs:      make(map[string]*redis.PubSub),
+	}
+	if err := w.Refresh(ctx); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Refresh subscribes to masters that are not watched yet, for example after a
+// failover, and drops the subscriptions of nodes that are no longer masters
+func (w *keyspaceWatcher) Refresh(ctx context.Context) error {
+	if c, ok := w.client.(*redis.ClusterClient); ok {
+		c.ReloadState(ctx)
+	}
+
+	var mu sync.Mutex
+	masters := make(map[string]bool)
+	err := forEachMaster(ctx, w.client, func(ctx context.Context, master *redis.Client) error {
+		addr := master.Options().Addr
+		mu.Lock()
+		masters[addr] = true
+		mu.Unlock()
+
+		w.mu.Lock()
+		_, watched := w.subs[addr]
+		w.mu.Unlock()
+		if watched {
+			return nil
+		}
+		return w.subscribe(ctx, master)
+	})
+
+	// Nodes that were demoted or left the cluster
+	w.mu.Lock()
+	for addr, sub := range w.subs {
+		if !masters[addr] && err == nil {
+			delete(w.subs, addr)
+			sub.Close()
+			w.logf("  Stopped watching keyspace events on %s (no longer a master)\n", addr)
+		}
+	}
+	w.mu.Unlock()
+	return err
+}
+
+// subscribe starts receiving the keyspace events of master
+func (w *keyspaceWatcher) subscribe(ctx context.Context, master *redis.Client) error {
+	addr := master.Options().Addr
+	if err := w.enableNotifications(ctx, master); err != nil {
+		return err
+	}
+
+	prefix := fmt.Sprintf("__keyspace@%d__:", master.Options().DB)
+	sub := master.PSubscribe(ctx, prefix+"*")
+	// Wait for the confirmation so that no change after this point is missed
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return fmt.Errorf("failed to subscribe to keyspace events on %s: %w", addr, err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		sub.Close()
+		return nil
+	}
+	w.subs[addr] = sub
+	w.wg.Add(1)
+	go w.receive(sub, addr, prefix)
+	return nil
+}
+
+// enableNotifications checks that master publishes keyspace events for every
+// change, enabling them when the watcher may configure masters
+func (w *keyspaceWatcher) enableNotifications(ctx context.Context, master *redis.Client) error {
+	addr := master.Options().Addr
+	config, err := master.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return fmt.Errorf("failed to read notify-keyspace-events on %s: %w", addr, err)
+	}
+	current := config["notify-keyspace-events"]
+	if notificationsEnabled(current) {
+		return nil
+	}
+	if !w.configure {
+		return fmt.Errorf("keyspace notifications are disabled on %s (notify-keyspace-events is %q, needs \"KA\"); enable them or configure them automatically", addr, current)
+	}
+
+	if err := master.ConfigSet(ctx, "notify-keyspace-events", current+"KA").Err(); err != nil {
+		return fmt.Errorf("failed to enable keyspace notifications on %s: %w", addr, err)
+	}
+	w.logf("  Enabled keyspace notifications on %s\n", addr)
+
+	w.mu.Lock()
+	w.restores = append(w.restores, notifyConfig{node: master, value: current})
+	w.mu.Unlock()
+	return nil
+}
+
+// notificationsEnabled reports whether a notify-keyspace-events value covers
+// every change on keyspace channels
+func notificationsEnabled(value string) bool {
+	if !strings.Contains(value, "K") {
+		return false
+	}
+	if strings.Contains(value, "A") {
+		return true
+	}
+	for _, class := range keyspaceEvents {
+		if !strings.ContainsRune(value, class) {
+			return false
+		}
+	}
+	return true
+}
+
+// receive passes on the keys of the events of one subscription until it is dropped
+func (w *keyspaceWatcher) receive(sub *redis.PubSub, addr, prefix string) {
+	defer w.wg.Done()
+	ctx := context.Background()
+
+	interrupted := false
+	for {
+		msg, err := sub.ReceiveTimeout(ctx, 5*time.Second)
+
+		w.mu.Lock()
+		active := !w.closed && w.subs[addr] == sub
+		w.mu.Unlock()
+		if !active {
+			return
+		}
+
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			// The subscription reconnects on the next receive, changes in between are lost
+			if !interrupted {
+				w.logf("  ⚠ Keyspace events from %s interrupted: %v\n", addr, err)
+				w.mu.Lock()
+				w.lost++
+				w.mu.Unlock()
+			}
+			interrupted = true
+			time.Sleep(time.Second)
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if interrupted {
+				w.logf("  ✓ Keyspace events from %s resumed\n", addr)
+			}
+			interrupted = false
+		case *redis.Message:
+			interrupted = false
+			key := strings.TrimPrefix(msg.Channel, prefix)
+			if w.match(key) {
+				w.onKey(key)
+			}
+		}
+	}
+}
+
+// Lost returns how many times events may have been missed since the watch started
+func (w *keyspaceWatcher) Lost() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lost
+}
+
+// Subscriptions returns the number of masters watched
+func (w *keyspaceWatcher) Subscriptions() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.subs)
+}
+
+// Close unsubscribes and puts back the notification settings that were changed
+func (w *keyspaceWatcher) Close() {
+	w.mu.Lock()
+	w.closed = true
+	subs, restores := w.subs, w.restores
+	w.subs = make(map[string]*redis.PubSub)
+	w.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+	w.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, restore := range restores {
+		if err := restore.node.ConfigSet(ctx, "notify-keyspace-events", restore.value).Err(); err != nil {
+			w.logf("  ⚠ Failed to restore notify-keyspace-events on %s: %v\n", restore.node.Options().Addr, err)
+		}
+	}
+}
+
+// changedKeys collects the distinct keys changed since they were last taken
+type changedKeys struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// Add records a changed key
+func (c *changedKeys) Add(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[string]struct{})
+	}
+	c.keys[key] = struct{}{}
+}
+
+// Take returns the keys changed since the previous call
+func (c *changedKeys) Take() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.keys))
+	for key := range c.keys {
+		keys = append(keys, key)
+	}
+	c.keys = nil
+	return keys
+}
@@ -0,0 +1,175 @@
+package squirrel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MigrateOptions configures a Migrator
+type MigrateOptions struct {
+	RunOptions
+
+	Pattern      string   // Glob-style pattern of the keys to scan, "*" when empty
+	BatchSize    int64    // COUNT hint of every SCAN call
+	UseDump      bool     // Move keys with DUMP/RESTORE instead of by type
+	ChunkSize    int64    // Elements per chunk of collections moved by type, DefaultChunkSize when 0
+	MaxDumpSize  int64    // Move keys with bigger DUMP payloads by type in chunks, see ExportOptions
+	FromReplicas bool     // Scan and read the replica of every shard with the least lag; the source client needs ReadOnly
+	Keys         []string // Migrate exactly these keys instead of scanning
+
+	// Transport is TransportRestore (default) or TransportMigrate
+	Transport      string
+	MigrateBatch   int           // Keys per MIGRATE command, 100 when 0
+	MigrateTimeout time.Duration // MIGRATE timeout between the servers, 5s when 0
+}
+
+// Transports moving keys from the source to the target
+const (
+	// TransportRestore reads every key with DUMP and writes it with RESTORE
+	TransportRestore = "restore"
+	// TransportMigrate has the source masters send keys to the target with
+	// MIGRATE; keys fall back to DUMP/RESTORE when MIGRATE is rejected
+	TransportMigrate = "migrate"
+)
+
+// Migrator copies keys from a source to a target without an intermediate file
+type Migrator struct {
+	source   redis.UniversalClient
+	target   redis.UniversalClient
+	opts     MigrateOptions
+	exporter *Exporter
+	importer *Importer
+}
+
+// NewMigrator returns a migrator copying keys from source to target
+func NewMigrator(source, target redis.UniversalClient, opts MigrateOptions) *Migrator {
+	return &Migrator{
+		source: source,
+		target: target,
+		opts:   opts,
+		exporter: NewExporter(source, ExportOptions{
+			RunOptions:   opts.RunOptions,
+			Pattern:      opts.Pattern,
+			BatchSize:    opts.BatchSize,
+			UseDump:      opts.UseDump,
+			FromReplicas: opts.FromReplicas,
+			ChunkSize:    opts.ChunkSize,
+			MaxDumpSize:  opts.MaxDumpSize,
+		}),
+		importer: NewImporter(target, ImportOptions{
+			RunOptions: opts.RunOptions,
+			UseDump:    opts.UseDump,
+		}),
+	}
+}
+
+// Migrate copies every selected key. When ctx is cancelled, the key in flight
+// is finished and ErrInterrupted is returned with the summary so far.
+func (m *Migrator) Migrate(ctx context.Context) (*Summary, error) {
+	start := time.Now()
+	summary := &Summary{}
+	logf := logger(m.opts.Logf)
+
+	if err := m.exporter.selectNodes(ctx); err != nil {
+		return summary, err
+	}
+	keys := m.opts.Keys
+	if keys == nil {
+		var err error
+		keys, err = m.exporter.ScanKeys(ctx)
+		if err != nil {
+			summary.Duration = time.Since(start)
+			return summary, interruptedOr(ctx, err)
+		}
+	}
+	summary.Total = len(keys)
+
+	if len(keys) > 0 {
+		logf("Migrating keys...\n")
+	}
+
+	// Keys in flight are finished even after an interrupt
+	keyCtx := context.WithoutCancel(ctx)
+
+	var runErr error
+	if m.opts.Transport == TransportMigrate {
+		runErr = m.migrateNative(ctx, keyCtx, summary, keys)
+	} else {
+		for _, key := range keys {
+			if ctx.Err() != nil {
+				runErr = ErrInterrupted
+				break
+			}
+			if runErr = m.migrateKey(ctx, keyCtx, summary, key); runErr != nil {
+				break
+			}
+		}
+	}
+
+	summary.Duration = time.Since(start)
+	if runErr == nil && summary.Failed > 0 {
+		runErr = fmt.Errorf("%d keys failed to migrate: %w", summary.Failed, ErrPartial)
+	}
+	return summary, runErr
+}
+
+// migrateKey copies one key with DUMP/RESTORE (or by type). It returns an
+// error only when the run has to stop.
+func (m *Migrator) migrateKey(ctx, keyCtx context.Context, summary *Summary, key string) error {
+	client, node := m.exporter.readerFor(ctx, key)
+	task := &keyTask{Key: key, Node: node, Phase: PhaseExport}
+	if err := m.opts.wait(ctx, task.Node, 1, 0); err != nil {
+		return err
+	}
+
+	// A key in one record is exported once and only its import is retried.
+	// Chunks are imported as they are read, so a failed chunk starts the key
+	// again at chunk 0.
+	start := time.Now()
+	var keyData, last *KeyData
+	var fallback bool
+	size := 0
+	ok, err := m.opts.runKey(ctx, summary, task, func() error {
+		if keyData == nil {
+			task.Phase = PhaseExport
+			size, last = 0, nil
+			err := m.exporter.exportRecords(keyCtx, client, key, func(record *KeyData) error {
+				size += record.Size()
+				last = record
+				if record.Chunk == 0 && !record.More {
+					keyData = record
+					return nil
+				}
+				task.Phase = PhaseImport
+				if _, err := m.importer.importKey(keyCtx, record); err != nil {
+					return err
+				}
+				task.Phase = PhaseExport
+				return nil
+			})
+			if err != nil || keyData == nil {
+				return err
+			}
+		}
+		task.Phase = PhaseImport
+		var err error
+		fallback, err = m.importer.importKey(keyCtx, keyData)
+		return err
+	})
+	if err != nil || !ok {
+		return err
+	}
+	if fallback {
+		summary.Fallback++
+	}
+	if m.opts.UseDump && last.Dump == nil {
+		summary.Chunked++
+	}
+	summary.Restore.add(1, int64(size), time.Since(start))
+	m.opts.keyDone(summary, task)
+
+	return m.opts.wait(ctx, task.Node, 0, size)
+}
@@ -0,0 +1,687 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/seabfh/kv-squirrel/rdb"
+)
+
+// ReplicationSyncer keeps the target up to date by following every source
+// master as a replica: it loads the RDB transfer of a full resync and then
+// applies the replicated command stream. Unlike keyspace notifications this
+// needs no configuration on the source and does not drop changes. Writes are
+// routed by the target client, so the target topology may differ.
+type ReplicationSyncer struct {
+	syncState
+	source redis.UniversalClient
+	target redis.UniversalClient
+	opts   SyncOptions
+
+	shards map[string]*shardStream // By shard, guarded by mu
+	wg     sync.WaitGroup
+}
+
+// shardStream follows the master of one shard. The replication ID and offset
+// survive a failover so that the promoted replica can continue the stream.
+type shardStream struct {
+	id     string // Lowest slot of the shard, or the address without cluster
+	addr   string
+	cancel context.CancelFunc
+	done   chan struct{} // Closed once the current follower stopped
+
+	replID       string
+	offset       int64 // Replication offset applied to the target
+	masterOffset int64
+	connected    bool
+	loaded       bool // The first RDB transfer was applied
+	caughtUpAt   time.Time
+
+	// Replication offset at which keys were copied from the master. Changes
+	// of a key up to its offset are already in the copy and are not applied.
+	// Owned by the follower.
+	resynced map[string]int64
+}
+
+// NewReplicationSyncer returns a syncer following the masters of source
+func NewReplicationSyncer(source, target redis.UniversalClient, opts SyncOptions) *ReplicationSyncer {
+	if opts.Pattern == "" {
+		opts.Pattern = "*"
+	}
+	if opts.TopologyInterval <= 0 {
+		opts.TopologyInterval = 30 * time.Second
+	}
+	s := &ReplicationSyncer{
+		source: source,
+		target: target,
+		opts:   opts,
+		shards: make(map[string]*shardStream),
+	}
+	s.runOpts = &s.opts.RunOptions
+	return s
+}
+
+// Run follows every master until ctx is cancelled. Stopping after every shard
+// finished its initial transfer is not an error; stopping before returns
+// ErrInterrupted.
+func (s *ReplicationSyncer) Run(ctx context.Context) (*Summary, error) {
+	logf := logger(s.opts.Logf)
+	s.start(&Summary{})
+	defer s.setPhase(SyncPhaseStopped)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		s.wg.Wait()
+	}()
+
+	if err := s.refresh(ctx); err != nil {
+		return s.summary, err
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastRefresh := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			cancel()
+			s.wg.Wait()
+			if s.Status().Phase == SyncPhaseInitial {
+				return s.summary, ErrInterrupted
+			}
+			return s.summary, s.result()
+
+		case <-ticker.C:
+			if s.loaded() && s.Status().Phase == SyncPhaseInitial {
+				logf("✓ Initial transfer done on every shard, streaming changes\n")
+				s.setPhase(SyncPhaseStreaming)
+			}
+			if time.Since(lastRefresh) >= s.opts.TopologyInterval {
+				lastRefresh = time.Now()
+				if err := s.refresh(ctx); err != nil && ctx.Err() == nil {
+					logf("⚠ Failed to refresh source masters: %v\n", err)
+				}
+			}
+			if err := s.failureLimit(); err != nil {
+				return s.summary, err
+			}
+		}
+	}
+}
+
+// refresh starts following new masters, including replicas promoted by a
+// failover, and stops following nodes that are no longer masters
+func (s *ReplicationSyncer) refresh(ctx context.Context) error {
+	masters, err := s.masters(ctx)
+	if err != nil {
+		return err
+	}
+	logf := logger(s.opts.Logf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, shard := range s.shards {
+		master, ok := masters[id]
+		if ok && master.Options().Addr == shard.addr {
+			continue
+		}
+		shard.cancel()
+		if !ok {
+			logf("  Stopped following shard %s on %s\n", id, shard.addr)
+			delete(s.shards, id)
+		}
+	}
+
+	for id, master := range masters {
+		shard, ok := s.shards[id]
+		if ok && shard.addr == master.Options().Addr {
+			continue
+		}
+		if !ok {
+			shard = &shardStream{id: id}
+			s.shards[id] = shard
+		} else {
+			logf("  Shard %s moved from %s to %s, continuing replication\n", id, shard.addr, master.Options().Addr)
+		}
+
+		shardCtx, cancel := context.WithCancel(ctx)
+		previous, done := shard.done, make(chan struct{})
+		shard.addr = master.Options().Addr
+		shard.cancel = cancel
+		shard.done = done
+		s.wg.Add(1)
+		go func(shard *shardStream, master *redis.Client) {
+			defer s.wg.Done()
+			defer close(done)
+			// The follower of the old master has to stop before its offset is reused
+			if previous != nil {
+				<-previous
+			}
+			s.follow(shardCtx, shard, master)
+		}(shard, master)
+	}
+	return nil
+}
+
+// masters returns the current master of every shard, by shard
+func (s *ReplicationSyncer) masters(ctx context.Context) (map[string]*redis.Client, error) {
+	c, ok := s.source.(*redis.ClusterClient)
+	if !ok {
+		var masters map[string]*redis.Client
+		err := forEachMaster(ctx, s.source, func(ctx context.Context, master *redis.Client) error {
+			masters = map[string]*redis.Client{master.Options().Addr: master}
+			return nil
+		})
+		return masters, err
+	}
+
+	c.ReloadState(ctx)
+	slots, err := c.ClusterSlots(ctx).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster slots: %w", err)
+	}
+	// A shard is named after its lowest slot, which does not change on failover
+	shardOf := make(map[string]int)
+	for _, slot := range slots {
+		if len(slot.Nodes) == 0 {
+			continue
+		}
+		addr := slot.Nodes[0].Addr
+		if lowest, ok := shardOf[addr]; !ok || slot.Start < lowest {
+			shardOf[addr] = slot.Start
+		}
+	}
+
+	var mu sync.Mutex
+	masters := make(map[string]*redis.Client)
+	err = c.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		lowest, ok := shardOf[master.Options().Addr]
+		if !ok {
+			// A master without slots holds no keys
+			return nil
+		}
+		mu.Lock()
+		masters[strconv.Itoa(lowest)] = master
+		mu.Unlock()
+		return nil
+	})
+	return masters, err
+}
+
+// follow replicates from one master until ctx is cancelled, reconnecting with
+// a partial resync after errors
+func (s *ReplicationSyncer) follow(ctx context.Context, shard *shardStream, master *redis.Client) {
+	logf := logger(s.opts.Logf)
+	addr := master.Options().Addr
+
+	for attempt := 0; ctx.Err() == nil; attempt++ {
+		if attempt > 0 {
+			delay := backoff(RetryPolicy{BaseDelay: time.Second, MaxDelay: 30 * time.Second}, attempt)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		err := s.replicate(ctx, shard, master)
+		s.mu.Lock()
+		shard.connected = false
+		s.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logf("⚠ Replication from %s interrupted: %v\n", addr, err)
+		}
+		if errors.Is(err, ErrAborted) {
+			return
+		}
+	}
+}
+
+// replicate runs one replication connection: a full or partial resync
+// followed by the command stream
+func (s *ReplicationSyncer) replicate(ctx context.Context, shard *shardStream, master *redis.Client) error {
+	logf := logger(s.opts.Logf)
+	addr := master.Options().Addr
+
+	s.mu.Lock()
+	replID, offset := shard.replID, shard.offset
+	s.mu.Unlock()
+
+	conn, fullSync, err := dialReplica(ctx, master.Options(), replID, offset)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Unblock reads once the shard is stopped
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	keyCtx := context.WithoutCancel(ctx)
+	if fullSync {
+		if replID != "" {
+			// Keys deleted before the resync are not in the transfer and stay on the target
+			logf("⚠ %s could not continue replication, loading a full resync\n", addr)
+			s.mu.Lock()
+			s.status.Gaps++
+			s.mu.Unlock()
+		} else {
+			logf("  Loading RDB transfer from %s...\n", addr)
+		}
+
+		loaded := 0
+		err := conn.loadRDB(func(entry *rdb.Entry, version int) error {
+			applied, err := s.restore(ctx, keyCtx, addr, master.Options().DB, entry, version)
+			if applied {
+				loaded++
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to load RDB transfer: %w", err)
+		}
+		logf("✓ Loaded %d keys from %s\n", loaded, addr)
+		// Offsets of copied keys belong to the replaced stream
+		shard.resynced = nil
+	} else {
+		logf("✓ Continuing replication from %s at offset %d\n", addr, conn.offset)
+	}
+
+	s.mu.Lock()
+	shard.replID, shard.offset = conn.replID, conn.offset
+	shard.connected, shard.loaded = true, true
+	shard.caughtUpAt = time.Now()
+	s.mu.Unlock()
+
+	go s.acknowledge(ctx, shard, conn, master)
+
+	db := master.Options().DB
+	for {
+		args, err := conn.readCommand()
+		if err != nil {
+			return err
+		}
+
+		if len(args) >= 2 && strings.EqualFold(args[0], "REPLCONF") && strings.EqualFold(args[1], "GETACK") {
+			s.mu.Lock()
+			applied := shard.offset
+			s.mu.Unlock()
+			if err := conn.ack(applied); err != nil {
+				return err
+			}
+		} else if err := s.apply(ctx, keyCtx, shard, master, &db, conn.offset, args); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		shard.offset = conn.offset
+		shard.replID = conn.replID
+		if shard.offset >= shard.masterOffset {
+			shard.caughtUpAt = time.Now()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// acknowledge reports the applied offset to the master every second, as
+// replicas do, and samples the master offset to measure the lag
+func (s *ReplicationSyncer) acknowledge(ctx context.Context, shard *shardStream, conn *replicaConn, master *redis.Client) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		applied := shard.offset
+		connected := shard.connected
+		s.mu.Unlock()
+		if !connected {
+			return
+		}
+		if err := conn.ack(applied); err != nil {
+			return
+		}
+
+		info, err := master.Info(ctx, "replication").Result()
+		if err != nil {
+			continue
+		}
+		if masterOffset, err := strconv.ParseInt(parseInfo(info)["master_repl_offset"], 10, 64); err == nil {
+			s.mu.Lock()
+			shard.masterOffset = masterOffset
+			if shard.offset >= masterOffset {
+				shard.caughtUpAt = time.Now()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// restore writes a key of the RDB transfer to the target. It reports whether
+// the key was written, and returns an error only when the sync has to stop.
+func (s *ReplicationSyncer) restore(ctx, keyCtx context.Context, addr string, db int, entry *rdb.Entry, version int) (bool, error) {
+	if entry.DB != db || !matchPattern(s.opts.Pattern, entry.Key) {
+		return false, nil
+	}
+	if entry.ExpireAt > 0 && entry.ExpireAt <= time.Now().UnixMilli() {
+		return false, nil
+	}
+
+	task := &keyTask{Key: entry.Key, Node: addr, Phase: PhaseImport}
+	payload := entry.Payload(version)
+	if err := s.opts.wait(ctx, addr, 1, len(entry.Key)+len(payload)); err != nil {
+		return false, err
+	}
+
+	args := []interface{}{"RESTORE", entry.Key, entry.ExpireAt, payload, "REPLACE"}
+	if entry.ExpireAt > 0 {
+		args = append(args, "ABSTTL")
+	}
+	fallback := false
+	retries, err := withRetry(keyCtx, s.opts.Retry, func() error {
+		err := s.target.Do(keyCtx, args...).Err()
+		if err == nil || ClassifyError(err) != ClassPayload {
+			return err
+		}
+		// The target does not read the RDB version of the source
+		keyData := &KeyData{Key: entry.Key, TTL: -1, Dump: payload}
+		if entry.ExpireAt > 0 {
+			keyData.TTL = time.Until(time.UnixMilli(entry.ExpireAt))
+		}
+		if fallbackErr := restoreByType(keyCtx, s.target, keyData); fallbackErr != nil {
+			return fmt.Errorf("%w, writing it by type failed too: %v", err, fallbackErr)
+		}
+		fallback = true
+		return nil
+	}, nil)
+	if err := s.record(task, false, retries, err); err != nil {
+		return false, err
+	}
+	if fallback {
+		s.fellBack()
+	}
+	return err == nil, nil
+}
+
+// apply writes a replicated command to the target. db tracks SELECT in the
+// stream; only commands on the database of master are applied. offset is the
+// replication offset past the command.
+func (s *ReplicationSyncer) apply(ctx, keyCtx context.Context, shard *shardStream, master *redis.Client, db *int, offset int64, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	logf := logger(s.opts.Logf)
+	addr := master.Options().Addr
+
+	name := strings.ToLower(args[0])
+	switch name {
+	case "select":
+		if len(args) == 2 {
+			*db, _ = strconv.Atoi(args[1])
+		}
+		return nil
+	case "ping", "multi", "exec", "replconf", "publish", "spublish":
+		// Transactions are applied command by command, so they are not
+		// atomic on the target
+		return nil
+	}
+	if *db != master.Options().DB {
+		return nil
+	}
+
+	switch name {
+	case "flushall", "flushdb", "swapdb":
+		logf("⚠ Not applying %s from %s to the target\n", strings.ToUpper(name), addr)
+		s.mu.Lock()
+		s.status.Gaps++
+		s.mu.Unlock()
+		return nil
+	}
+
+	// A key copied from the master after this command already holds its change
+	current := func(key string) bool {
+		at, ok := shard.resynced[key]
+		if ok && offset > at {
+			delete(shard.resynced, key)
+			return true
+		}
+		return !ok
+	}
+
+	spec, positions := commandKeys(args)
+	var keys []string
+	for _, i := range positions {
+		if matchPattern(s.opts.Pattern, args[i]) && current(args[i]) {
+			keys = append(keys, args[i])
+		}
+	}
+	if len(positions) > 0 && len(keys) == 0 {
+		// Outside the pattern, or already in the copies of its keys
+		return nil
+	}
+	if len(keys) < len(positions) {
+		if !spec.split {
+			// The command depends on keys that are not synced or whose copy
+			// is ahead of it, so the other keys it changed are copied instead
+			return s.resync(ctx, keyCtx, shard, master, keys)
+		}
+		args = splitCommand(args, spec, positions, func(key string) bool {
+			_, copied := shard.resynced[key]
+			return matchPattern(s.opts.Pattern, key) && !copied
+		})
+		if _, positions = commandKeys(args); len(positions) == 0 {
+			return nil
+		}
+	}
+
+	key := ""
+	if len(keys) > 0 {
+		key = keys[0]
+	}
+	task := &keyTask{Key: key, Node: addr, Phase: PhaseImport}
+	size := 0
+	for _, arg := range args {
+		size += len(arg)
+	}
+	if err := s.opts.wait(ctx, addr, 1, size); err != nil {
+		return err
+	}
+
+	unsafe := spec.unsafe || name == "zadd" && containsFold(args[1:], "incr")
+	cmd := make([]interface{}, len(args))
+	for i, arg := range args {
+		cmd[i] = arg
+	}
+	var mu sync.Mutex
+	var ambiguous error
+	send := func(ctx context.Context, client redis.UniversalClient) (int, error) {
+		return withRetry(ctx, s.opts.Retry, func() error {
+			err := client.Do(ctx, cmd...).Err()
+			if err == redis.Nil {
+				return nil
+			}
+			if err != nil && unsafe && isAmbiguous(err) {
+				// The target may have applied it, and applying it again would
+				// apply it twice
+				mu.Lock()
+				ambiguous = err
+				mu.Unlock()
+				return nil
+			}
+			return err
+		}, nil)
+	}
+	var retries int
+	var err error
+	if len(positions) == 0 {
+		// Every master keeps its own functions and scripts, and a cluster
+		// client would send the command to one of them
+		err = forEachMaster(keyCtx, s.target, func(ctx context.Context, target *redis.Client) error {
+			n, err := send(ctx, target)
+			mu.Lock()
+			retries += n
+			mu.Unlock()
+			if err != nil {
+				return fmt.Errorf("%s: %w", target.Options().Addr, err)
+			}
+			return nil
+		})
+	} else {
+		retries, err = send(keyCtx, s.target)
+	}
+	if ambiguous != nil {
+		logf("⚠ %s of %s may not have been applied (%v), copying the key from %s\n", strings.ToUpper(name), key, ambiguous, addr)
+		s.mu.Lock()
+		s.status.Gaps++
+		s.mu.Unlock()
+		return s.resync(ctx, keyCtx, shard, master, keys)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %w", strings.ToUpper(name), err)
+	}
+	deleted := name == "del" || name == "unlink"
+	return s.record(task, deleted, retries, err)
+}
+
+// resync copies keys from master to the target and records the replication
+// offset of the copy, so that the changes it already holds are not applied
+// again. It returns an error only when the sync has to stop.
+func (s *ReplicationSyncer) resync(ctx, keyCtx context.Context, shard *shardStream, master *redis.Client, keys []string) error {
+	addr := master.Options().Addr
+	for _, key := range keys {
+		task := &keyTask{Key: key, Node: addr, Phase: PhaseImport}
+		if err := s.opts.wait(ctx, addr, 1, len(key)); err != nil {
+			return err
+		}
+
+		var offset int64
+		var keyData *KeyData
+		fallback := false
+		retries, err := withRetry(keyCtx, s.opts.Retry, func() error {
+			var err error
+			if offset, keyData, err = dumpAtOffset(keyCtx, master, key); err != nil {
+				return err
+			}
+			fallback, err = importKey(keyCtx, s.target, keyData, true)
+			return err
+		}, nil)
+		if err != nil {
+			err = fmt.Errorf("failed to copy the key from %s: %w", addr, err)
+		} else {
+			if shard.resynced == nil {
+				shard.resynced = make(map[string]int64)
+			}
+			shard.resynced[key] = offset
+		}
+		if err := s.record(task, err == nil && keyData.Deleted, retries, err); err != nil {
+			return err
+		}
+		if fallback {
+			s.fellBack()
+		}
+	}
+	return nil
+}
+
+// dumpAtOffset dumps a key together with the replication offset of the
+// master, in one transaction so that the dump holds exactly the changes up
+// to the offset. A key that does not exist is returned as a tombstone.
+func dumpAtOffset(ctx context.Context, master *redis.Client, key string) (int64, *KeyData, error) {
+	var info *redis.StringCmd
+	var dump *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := master.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		info = pipe.Info(ctx, "replication")
+		dump = pipe.Dump(ctx, key)
+		pttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return 0, nil, err
+	}
+
+	offset, err := strconv.ParseInt(parseInfo(info.Val())["master_repl_offset"], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read the replication offset: %w", err)
+	}
+	if dump.Err() == redis.Nil {
+		return offset, &KeyData{Key: key, Deleted: true}, nil
+	}
+	ttl := pttl.Val()
+	if ttl <= 0 {
+		ttl = -1
+	}
+	return offset, &KeyData{Key: key, TTL: ttl, Dump: []byte(dump.Val())}, nil
+}
+
+// isAmbiguous reports whether a command that failed with err may still have
+// been applied, because the connection was lost or timed out after sending it
+func isAmbiguous(err error) bool {
+	switch ClassifyError(err) {
+	case ClassTimeout, ClassNetwork:
+		return true
+	default:
+		return false
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// loaded reports whether every shard applied its first RDB transfer
+func (s *ReplicationSyncer) loaded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, shard := range s.shards {
+		if !shard.loaded {
+			return false
+		}
+	}
+	return len(s.shards) > 0
+}
+
+// failureLimit returns the error that stops the sync once too many changes failed
+func (s *ReplicationSyncer) failureLimit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts.MaxFailures.Check(s.summary.Failed, s.summary.Total)
+}
+
+// Status returns the current state of the sync. It is safe to call from any goroutine.
+func (s *ReplicationSyncer) Status() SyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	for _, shard := range s.shards {
+		if shard.connected {
+			status.Subscriptions++
+		}
+		if lag := shard.masterOffset - shard.offset; lag > 0 {
+			status.LagBytes += lag
+			if age := time.Since(shard.caughtUpAt).Seconds(); age > status.OldestAge {
+				status.OldestAge = age
+			}
+		}
+	}
+	return status
+}
@@ -0,0 +1,138 @@
+package squirrel
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/seabfh/kv-squirrel/rdb"
+)
+
+func TestReplicationApply(t *testing.T) {
+	valueType, value, err := rdb.EncodeValue(&rdb.Value{Type: "string", String: "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := string(rdb.DumpPayload(valueType, value, rdb.EncodingVersion))
+
+	// The master answers the transaction of dumpAtOffset
+	masterOffset := 0
+	dumps := map[string]interface{}{"a1": nil, "a2": payload}
+	var dumped string
+	master := fakeClient(t, func(args []string) interface{} {
+		switch strings.ToLower(args[0]) {
+		case "multi":
+			return "OK"
+		case "exec":
+			info := "# Replication\r\nmaster_repl_offset:" + strconv.Itoa(masterOffset) + "\r\n"
+			return []interface{}{info, dumps[dumped], -1}
+		case "dump":
+			dumped = args[1]
+		}
+		return "QUEUED"
+	})
+
+	var applied []string
+	hangUp := false
+	target := fakeClient(t, func(args []string) interface{} {
+		name := strings.ToLower(args[0])
+		if hangUp && name == "incr" {
+			// Applied, but the reply is lost
+			hangUp = false
+			applied = append(applied, strings.Join(args, " "))
+			return fakeHangUp{}
+		}
+		applied = append(applied, strings.Join(args, " "))
+		if name == "del" || name == "incr" {
+			return 1
+		}
+		return "OK"
+	})
+
+	s := NewReplicationSyncer(master, target, SyncOptions{MigrateOptions: MigrateOptions{Pattern: "a*"}})
+	s.start(&Summary{})
+	shard := &shardStream{}
+	db := 0
+
+	tests := []struct {
+		name    string
+		offset  int64
+		command string
+		master  int // Offset of the master when keys are copied
+		hangUp  bool
+		want    []string
+	}{
+		{"key outside the pattern", 10, "SET b 1", 0, false, nil},
+		{"split DEL", 20, "DEL a1 b1 a2", 0, false, []string{"DEL a1 a2"}},
+		{"split MSET", 30, "MSET b 1 a1 2", 0, false, []string{"MSET a1 2"}},
+		{"keys outside the pattern", 40, "RENAME a1 b1", 45, false,
+			[]string{"del a1 " + tempKey("a1") + " " + chunkMarker("a1")}},
+		{"change in the copy", 45, "INCR a1", 0, false, nil},
+		{"change after the copy", 50, "INCR a1", 0, false, []string{"INCR a1"}},
+		{"ambiguous failure", 60, "INCR a2", 70, true,
+			[]string{"INCR a2", "restore a2 0 " + payload + " replace"}},
+		{"change in the copy after a failure", 65, "INCR a2", 0, false, nil},
+		{"idempotent after a failure", 75, "SET a2 1", 0, false, []string{"SET a2 1"}},
+		{"no keys", 80, "SCRIPT FLUSH", 0, false, []string{"SCRIPT FLUSH"}},
+		{"transaction", 90, "MULTI", 0, false, nil},
+	}
+	for _, tt := range tests {
+		applied = nil
+		masterOffset, hangUp = tt.master, tt.hangUp
+		err := s.apply(context.Background(), context.Background(), shard, master, &db, tt.offset, strings.Fields(tt.command))
+		if err != nil || !reflect.DeepEqual(applied, tt.want) {
+			t.Errorf("%s: applied %q, %v, want %q", tt.name, applied, err, tt.want)
+		}
+	}
+
+	status := s.Status()
+	if status.Gaps != 1 || status.Failed != 0 {
+		t.Errorf("status has %d gaps and %d failures, want 1 gap and none", status.Gaps, status.Failed)
+	}
+}
+
+func TestReplicationApplyKeyless(t *testing.T) {
+	// A cluster target of two masters
+	var mu sync.Mutex
+	applied := make(map[string][]string)
+	slots := []interface{}{
+		[]interface{}{0, 8191, []interface{}{"m1", 6379, "id1"}},
+		[]interface{}{8192, 16383, []interface{}{"m2", 6379, "id2"}},
+	}
+	target := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:      []string{"m1:6379"},
+		MaxRetries: -1,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, server := net.Pipe()
+			go serveFake(server, func(args []string) interface{} {
+				if strings.EqualFold(args[0], "cluster") {
+					return slots
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				applied[addr] = append(applied[addr], strings.Join(args, " "))
+				return "OK"
+			})
+			return conn, nil
+		},
+	})
+	defer target.Close()
+
+	master := fakeClient(t, func(args []string) interface{} { return "OK" })
+	s := NewReplicationSyncer(master, target, SyncOptions{})
+	s.start(&Summary{})
+	db := 0
+	command := "FUNCTION LOAD #!lua name=lib"
+	if err := s.apply(context.Background(), context.Background(), &shardStream{}, master, &db, 10, []string{"FUNCTION", "LOAD", "#!lua name=lib"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"m1:6379": {command}, "m2:6379": {command}}
+	if !reflect.DeepEqual(applied, want) {
+		t.Errorf("applied %q, want %q", applied, want)
+	}
+}
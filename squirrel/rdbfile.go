@@ -0,0 +1,264 @@
+package squirrel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/seabfh/kv-squirrel/rdb"
+)
+
+// RDBOptions configures an RDBReader
+type RDBOptions struct {
+	Pattern string // Glob-style pattern of the keys to read, "*" when empty
+	DB      int    // Database whose keys are read, keys of other databases are skipped
+	Logical bool   // Decode values by type instead of restoring DUMP payloads
+}
+
+// RDBReader is a KeyReader over the keys of an RDB file such as the dump.rdb
+// written by BGSAVE. Keys that already expired are skipped.
+type RDBReader struct {
+	reader *rdb.Reader
+	opts   RDBOptions
+
+	filtered int
+	expired  int
+}
+
+// NewRDBReader reads the RDB header from r
+func NewRDBReader(r io.Reader, opts RDBOptions) (*RDBReader, error) {
+	reader, err := rdb.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Pattern == "" {
+		opts.Pattern = "*"
+	}
+	return &RDBReader{reader: reader, opts: opts}, nil
+}
+
+// Version returns the RDB version of the file
+func (r *RDBReader) Version() int {
+	return r.reader.Version()
+}
+
+// Skipped returns the number of keys skipped so far because they did not
+// match the pattern or database, and because they had expired
+func (r *RDBReader) Skipped() (filtered, expired int) {
+	return r.filtered, r.expired
+}
+
+// Next returns the next key, or io.EOF after the last one
+func (r *RDBReader) Next() (*KeyData, error) {
+	for {
+		entry, err := r.reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry.DB != r.opts.DB || !matchPattern(r.opts.Pattern, entry.Key) {
+			r.filtered++
+			continue
+		}
+
+		keyData := &KeyData{Key: entry.Key, Type: rdb.TypeName(entry.Type), TTL: -1}
+		if entry.ExpireAt > 0 {
+			keyData.TTL = time.Until(time.UnixMilli(entry.ExpireAt)).Truncate(time.Millisecond)
+			if keyData.TTL <= 0 {
+				r.expired++
+				continue
+			}
+		}
+
+		if !r.opts.Logical {
+			keyData.Dump = entry.Payload(r.reader.Version())
+			return keyData, nil
+		}
+
+		value, err := entry.Decode()
+		var unsupported *rdb.UnsupportedTypeError
+		switch {
+		case errors.As(err, &unsupported):
+			// Module types can only be restored from their payload
+			keyData.Dump = entry.Payload(r.reader.Version())
+		case err != nil:
+			return nil, fmt.Errorf("failed to decode key %q: %w", entry.Key, err)
+		default:
+			keyData.Value = logicalValue(value)
+		}
+		return keyData, nil
+	}
+}
+
+// DecodeDump decodes a DUMP payload, as exported with UseDump, into the type
+// of the key and its value in the shape exportValueByType returns. Corrupt
+// payloads are rejected by their checksum; module types return an
+// *rdb.UnsupportedTypeError.
+func DecodeDump(payload []byte) (keyType string, value interface{}, err error) {
+	decoded, err := rdb.DecodePayload(payload)
+	if err != nil {
+		return "", nil, err
+	}
+	return decoded.Type, logicalValue(decoded), nil
+}
+
+// logicalValue returns a decoded value in the shape exportValueByType returns
+func logicalValue(value *rdb.Value) interface{} {
+	switch value.Type {
+	case "string":
+		return value.String
+	case "list", "set":
+		return value.Elements
+	case "hash":
+		return value.Fields
+	case "zset":
+		members := make([]redis.Z, len(value.Members))
+		for i, m := range value.Members {
+			members[i] = redis.Z{Score: m.Score, Member: m.Member}
+		}
+		return members
+	case "stream":
+		return streamValue(value.Stream)
+	default:
+		return nil
+	}
+}
+
+// streamValue returns a decoded stream as a StreamValue, with the idle times
+// of pending entries counted from their last delivery until now
+func streamValue(stream *rdb.Stream) StreamValue {
+	now := time.Now().UnixMilli()
+	value := StreamValue{LastID: stream.LastID}
+	for _, entry := range stream.Entries {
+		value.Entries = append(value.Entries, StreamEntry{ID: entry.ID, Fields: entry.Fields})
+	}
+	for _, g := range stream.Groups {
+		group := StreamGroup{Name: g.Name, LastDeliveredID: g.LastDeliveredID}
+		for _, c := range g.Consumers {
+			consumer := StreamConsumer{Name: c.Name}
+			for _, p := range c.Pending {
+				consumer.Pending = append(consumer.Pending, StreamPending{
+					ID: p.ID, Idle: max(now-p.DeliveryTime, 0), Deliveries: p.Deliveries,
+				})
+			}
+			group.Consumers = append(group.Consumers, consumer)
+		}
+		value.Groups = append(value.Groups, group)
+	}
+	return value
+}
+
+// errUnwritable fails a key exported by type whose value an output format
+// cannot hold, without stopping the export
+var errUnwritable = fmt.Errorf("%w for this output format", errUnsupported)
+
+// RDBWriter is a KeyWriter writing keys as an RDB file that a server loads at
+// startup. DUMP payloads are written as they are, so the file gets the RDB
+// version of the source; values exported by type use plain encodings. The
+// chunks of a key are joined before it is written, so memory use is bound by
+// the biggest key exported in chunks, held twice while it is encoded.
+type RDBWriter struct {
+	w *rdb.Writer
+
+	// Key exported in chunks whose records are joined until the last one
+	pending *KeyData
+	merger  *chunkMerger
+}
+
+// NewRDBWriter returns a writer of an RDB file to w
+func NewRDBWriter(w io.Writer) *RDBWriter {
+	return &RDBWriter{w: rdb.NewWriter(w, rdb.DefaultAux())}
+}
+
+// Write writes a key. The chunks of a key exported in pieces are held until
+// its last one, since RDB values start with their length.
+func (r *RDBWriter) Write(keyData *KeyData) error {
+	if keyData.Deleted {
+		if r.pending != nil && r.pending.Key == keyData.Key {
+			// The key failed after some of its chunks
+			r.pending = nil
+			return nil
+		}
+		return fmt.Errorf("RDB files cannot hold deleted keys")
+	}
+	if len(keyData.Dump) == 0 && keyData.Type == "stream" {
+		// Checked before any chunk is held
+		return fmt.Errorf("%w: %s", errUnwritable, keyData.Type)
+	}
+
+	if keyData.Chunk > 0 || keyData.More {
+		if keyData.Chunk == 0 {
+			r.pending = keyData
+			r.merger = &chunkMerger{keyType: keyData.Type}
+		} else if r.pending == nil || r.pending.Key != keyData.Key {
+			return fmt.Errorf("chunk %d of %q without the chunks before it", keyData.Chunk, keyData.Key)
+		}
+		r.merger.add(keyData.Value)
+		if keyData.More {
+			return nil
+		}
+		joined := *keyData
+		joined.Chunk, joined.Value = 0, r.merger.result()
+		keyData = &joined
+		r.pending, r.merger = nil, nil
+	}
+
+	entry := &rdb.Entry{Key: keyData.Key}
+	if keyData.TTL > 0 {
+		entry.ExpireAt = time.Now().Add(keyData.TTL).UnixMilli()
+	}
+
+	version := rdb.EncodingVersion
+	if len(keyData.Dump) > 0 {
+		var err error
+		entry.Type, entry.Value, version, err = rdb.ParsePayload(keyData.Dump)
+		if err != nil {
+			return fmt.Errorf("%w DUMP payload of %q: %w", errInvalid, keyData.Key, err)
+		}
+	} else {
+		value, err := rdbValue(keyData)
+		if err != nil {
+			return err
+		}
+		if entry.Type, entry.Value, err = rdb.EncodeValue(value); err != nil {
+			return err
+		}
+	}
+	return r.w.Write(entry, version)
+}
+
+// Close completes the file with its checksum
+func (r *RDBWriter) Close() error {
+	return r.w.Close()
+}
+
+// rdbValue returns the value of a key exported by type as an rdb.Value
+func rdbValue(keyData *KeyData) (*rdb.Value, error) {
+	value := &rdb.Value{Type: keyData.Type}
+	ok := true
+	switch keyData.Type {
+	case "string":
+		value.String, ok = keyData.Value.(string)
+	case "list", "set":
+		value.Elements, ok = keyData.Value.([]string)
+	case "hash":
+		value.Fields, ok = keyData.Value.(map[string]string)
+	case "zset":
+		var members []redis.Z
+		members, ok = keyData.Value.([]redis.Z)
+		for _, z := range members {
+			member, isString := z.Member.(string)
+			if !isString {
+				return nil, fmt.Errorf("%w zset member %v of %q", errInvalid, z.Member, keyData.Key)
+			}
+			value.Members = append(value.Members, rdb.Member{Member: member, Score: z.Score})
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnwritable, keyData.Type)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w %s value of %q", errInvalid, keyData.Type, keyData.Key)
+	}
+	return value, nil
+}
@@ -0,0 +1,199 @@
+package squirrel
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// shardNode is the node chosen to serve one shard of the source cluster
+type shardNode struct {
+	MasterAddr string
+	Client     *redis.Client
+	IsReplica  bool
+}
+
+// forEachScanNode concurrently calls fn on one node per shard: the nodes
+// chosen by selectShardNodes when there are any, the masters otherwise.
+// A standalone client is its own single shard.
+func forEachScanNode(ctx context.Context, client redis.UniversalClient, nodes []shardNode, fn func(ctx context.Context, node *redis.Client) error) error {
+	if nodes != nil {
+		return forEachShardNode(ctx, nodes, fn)
+	}
+	return forEachMaster(ctx, client, fn)
+}
+
+// forEachMaster concurrently calls fn on every master. A standalone client is its own master.
+func forEachMaster(ctx context.Context, client redis.UniversalClient, fn func(ctx context.Context, master *redis.Client) error) error {
+	switch c := client.(type) {
+	case *redis.Client:
+		return fn(ctx, c)
+	case *redis.ClusterClient:
+		return c.ForEachMaster(ctx, fn)
+	default:
+		return fmt.Errorf("unsupported client type %T", client)
+	}
+}
+
+// forEachShardNode concurrently calls fn on the node chosen for every shard
+func forEachShardNode(ctx context.Context, nodes []shardNode, fn func(ctx context.Context, node *redis.Client) error) error {
+	var wg sync.WaitGroup
+	errCh := make(chan error, 1)
+
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node shardNode) {
+			defer wg.Done()
+			if err := fn(ctx, node.Client); err != nil {
+				select {
+				case errCh <- err:
+				default:
+				}
+			}
+		}(node)
+	}
+
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// selectShardNodes picks the healthiest replica of every shard and logs its
+// replication lag. The master is used when the shard has no healthy replica.
+func selectShardNodes(ctx context.Context, client *redis.ClusterClient, logf func(string, ...interface{})) ([]shardNode, error) {
+	var mu sync.Mutex
+	masters := make(map[string]*redis.Client)
+	replicas := make(map[string]*redis.Client)
+
+	err := client.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		mu.Lock()
+		masters[master.Options().Addr] = master
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list master nodes: %w", err)
+	}
+
+	err = client.ForEachSlave(ctx, func(ctx context.Context, replica *redis.Client) error {
+		mu.Lock()
+		replicas[replica.Options().Addr] = replica
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replica nodes: %w", err)
+	}
+
+	slots, err := client.ClusterSlots(ctx).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster slots: %w", err)
+	}
+
+	// Map every master to the replicas serving its slots
+	shardReplicas := make(map[string][]string)
+	for _, slot := range slots {
+		if len(slot.Nodes) == 0 {
+			continue
+		}
+		masterAddr := slot.Nodes[0].Addr
+		for _, node := range slot.Nodes[1:] {
+			if !containsString(shardReplicas[masterAddr], node.Addr) {
+				shardReplicas[masterAddr] = append(shardReplicas[masterAddr], node.Addr)
+			}
+		}
+	}
+
+	nodes := make([]shardNode, 0, len(masters))
+	for masterAddr, master := range masters {
+		node := shardNode{MasterAddr: masterAddr, Client: master}
+
+		masterInfo, err := replicationInfo(ctx, master)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read replication info from %s: %w", masterAddr, err)
+		}
+		masterOffset, _ := strconv.ParseInt(masterInfo["master_repl_offset"], 10, 64)
+
+		bestLag := int64(-1)
+		for _, replicaAddr := range shardReplicas[masterAddr] {
+			replica, ok := replicas[replicaAddr]
+			if !ok {
+				continue
+			}
+
+			info, err := replicationInfo(ctx, replica)
+			if err != nil {
+				logf("  ⚠ Replica %s of %s is unreachable: %v\n", replicaAddr, masterAddr, err)
+				continue
+			}
+			if info["master_link_status"] != "up" {
+				logf("  ⚠ Replica %s of %s has master link %q, skipping\n", replicaAddr, masterAddr, info["master_link_status"])
+				continue
+			}
+
+			replicaOffset, _ := strconv.ParseInt(info["slave_repl_offset"], 10, 64)
+			lag := masterOffset - replicaOffset
+			if lag < 0 {
+				lag = 0
+			}
+			if bestLag < 0 || lag < bestLag {
+				bestLag = lag
+				node.Client = replica
+				node.IsReplica = true
+			}
+		}
+
+		if node.IsReplica {
+			logf("Shard %s: reading from replica %s (replication lag: %d bytes)\n",
+				masterAddr, node.Client.Options().Addr, bestLag)
+		} else {
+			logf("Shard %s: ⚠ no healthy replica, falling back to master\n", masterAddr)
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// replicationInfo returns the fields of INFO replication for a node
+func replicationInfo(ctx context.Context, node *redis.Client) (map[string]string, error) {
+	info, err := node.Info(ctx, "replication").Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(info), nil
+}
+
+// parseInfo parses the output of the INFO command into a field map
+func parseInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+package squirrel
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/seabfh/kv-squirrel/rdb"
+)
+
+// replicationTimeout bounds every read from a master. Masters send a newline
+// while preparing the RDB transfer and PING regularly afterwards.
+const replicationTimeout = time.Minute
+
+// replicaConn is a connection to a master on which we act as a replica
+type replicaConn struct {
+	addr   string
+	conn   net.Conn
+	r      *bufio.Reader
+	replID string
+	offset int64 // Replication offset of the last byte read from the command stream
+
+	writeMu sync.Mutex
+}
+
+// dialReplica connects to a master and starts replication with PSYNC. With a
+// replication ID and offset from an earlier connection the master may continue
+// where it stopped; otherwise, or when it cannot, fullSync is true and the RDB
+// transfer has to be read with loadRDB before the command stream.
+func dialReplica(ctx context.Context, opts *redis.Options, replID string, offset int64) (conn *replicaConn, fullSync bool, err error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var nc net.Conn
+	if opts.TLSConfig != nil {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: opts.TLSConfig}).DialContext(ctx, "tcp", opts.Addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", opts.Addr)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to connect to %s: %w", opts.Addr, err)
+	}
+
+	c := &replicaConn{addr: opts.Addr, conn: nc}
+	c.r = bufio.NewReaderSize(&deadlineReader{conn: nc}, 64<<10)
+	defer func() {
+		if err != nil {
+			nc.Close()
+		}
+	}()
+
+	if opts.Password != "" {
+		args := []string{"AUTH", opts.Password}
+		if opts.Username != "" {
+			args = []string{"AUTH", opts.Username, opts.Password}
+		}
+		if _, err := c.call(args...); err != nil {
+			return nil, false, fmt.Errorf("failed to authenticate on %s: %w", opts.Addr, err)
+		}
+	}
+	// Diskless transfers are delimited by a mark; PSYNC2 lets a promoted replica continue
+	if _, err := c.call("REPLCONF", "capa", "eof", "capa", "psync2"); err != nil {
+		return nil, false, fmt.Errorf("REPLCONF failed on %s: %w", opts.Addr, err)
+	}
+
+	// A replica asks for the byte after the last one it processed
+	psyncID, psyncOffset := replID, offset+1
+	if replID == "" {
+		psyncID, psyncOffset = "?", -1
+	}
+	reply, err := c.call("PSYNC", psyncID, strconv.FormatInt(psyncOffset, 10))
+	if err != nil {
+		return nil, false, fmt.Errorf("PSYNC failed on %s: %w", opts.Addr, err)
+	}
+
+	fields := strings.Fields(reply)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		c.replID = fields[1]
+		c.offset, err = strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid PSYNC reply from %s: %q", opts.Addr, reply)
+		}
+		return c, true, nil
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		c.replID, c.offset = replID, offset
+		if len(fields) == 2 {
+			// A promoted replica continues under its new replication ID
+			c.replID = fields[1]
+		}
+		return c, false, nil
+	default:
+		return nil, false, fmt.Errorf("unexpected PSYNC reply from %s: %q", opts.Addr, reply)
+	}
+}
+
+// loadRDB reads the RDB transfer that follows a full resync, calling fn with
+// every key
+func (c *replicaConn) loadRDB(fn func(entry *rdb.Entry, version int) error) error {
+	// Newlines keep the connection alive while the master prepares the transfer
+	var line string
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == '\n' {
+			continue
+		}
+		c.r.UnreadByte()
+		line, err = c.readLine()
+		if err != nil {
+			return err
+		}
+		break
+	}
+	if !strings.HasPrefix(line, "$") {
+		return fmt.Errorf("unexpected RDB transfer header from %s: %q", c.addr, line)
+	}
+
+	// Either a length or, for diskless transfers, a mark following the data
+	var source io.Reader = c.r
+	var limited *io.LimitedReader
+	mark := ""
+	if strings.HasPrefix(line, "$EOF:") {
+		mark = line[len("$EOF:"):]
+	} else {
+		size, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid RDB transfer header from %s: %q", c.addr, line)
+		}
+		limited = &io.LimitedReader{R: c.r, N: size}
+		source = bufio.NewReaderSize(limited, 64<<10)
+	}
+
+	reader, err := rdb.NewReader(source)
+	if err != nil {
+		return err
+	}
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(entry, reader.Version()); err != nil {
+			return err
+		}
+	}
+
+	if limited != nil {
+		_, err := io.Copy(io.Discard, source)
+		return err
+	}
+	end := make([]byte, len(mark))
+	if _, err := io.ReadFull(c.r, end); err != nil {
+		return err
+	}
+	if string(end) != mark {
+		return fmt.Errorf("RDB transfer from %s does not end with its mark", c.addr)
+	}
+	return nil
+}
+
+// readCommand reads the next command of the replication stream and advances
+// the offset past it
+func (c *replicaConn) readCommand() ([]string, error) {
+	var size int64
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	size += int64(len(line)) + 2
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected data in replication stream from %s: %q", c.addr, line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid array in replication stream from %s: %q", c.addr, line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		size += int64(len(line)) + 2
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("unexpected data in replication stream from %s: %q", c.addr, line)
+		}
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("invalid bulk string in replication stream from %s: %q", c.addr, line)
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		size += int64(len(data))
+		args[i] = string(data[:length])
+	}
+
+	c.offset += size
+	return args, nil
+}
+
+// ack reports the offset applied so far to the master
+func (c *replicaConn) ack(offset int64) error {
+	return c.send("REPLCONF", "ACK", strconv.FormatInt(offset, 10))
+}
+
+// call sends a command and reads a simple reply
+func (c *replicaConn) call(args ...string) (string, error) {
+	if err := c.send(args...); err != nil {
+		return "", err
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case strings.HasPrefix(line, "+"):
+		return line[1:], nil
+	case strings.HasPrefix(line, "-"):
+		return "", replyError(line[1:])
+	default:
+		return "", fmt.Errorf("unexpected reply %q", line)
+	}
+}
+
+// send writes a command
+func (c *replicaConn) send(args ...string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(replicationTimeout))
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+// readLine reads a line without its CRLF
+func (c *replicaConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// Close closes the connection
+func (c *replicaConn) Close() error {
+	return c.conn.Close()
+}
+
+// deadlineReader extends the read deadline of a connection before every read
+type deadlineReader struct {
+	conn net.Conn
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	d.conn.SetReadDeadline(time.Now().Add(replicationTimeout))
+	return d.conn.Read(p)
+}
@@ -0,0 +1,337 @@
+package squirrel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/seabfh/kv-squirrel/rdb"
+)
+
+// respBatch is the number of elements written per RPUSH, SADD, HSET or ZADD
+const respBatch = 1000
+
+// RESPWriter is a KeyWriter writing keys as Redis commands in the RESP
+// protocol, to be replayed with redis-cli --pipe or imported. Keys with a DUMP
+// payload become RESTORE ... REPLACE, keys exported by type are deleted and
+// rebuilt with SET, RPUSH, SADD, HSET or ZADD. Expiries are absolute, so the
+// file can be replayed later.
+type RESPWriter struct {
+	w *bufio.Writer
+}
+
+// NewRESPWriter returns a writer of commands to w
+func NewRESPWriter(w io.Writer) *RESPWriter {
+	return &RESPWriter{w: bufio.NewWriterSize(w, 64<<10)}
+}
+
+// Write writes the commands recreating a key
+func (r *RESPWriter) Write(keyData *KeyData) error {
+	key := keyData.Key
+	if keyData.Deleted {
+		return r.command("DEL", key)
+	}
+
+	var expireAt string
+	if keyData.TTL > 0 {
+		expireAt = strconv.FormatInt(time.Now().Add(keyData.TTL).UnixMilli(), 10)
+	}
+
+	if len(keyData.Dump) > 0 {
+		if expireAt != "" {
+			return r.command("RESTORE", key, expireAt, string(keyData.Dump), "REPLACE", "ABSTTL")
+		}
+		return r.command("RESTORE", key, "0", string(keyData.Dump), "REPLACE")
+	}
+
+	value, err := rdbValue(keyData)
+	if err != nil {
+		return err
+	}
+	if value.Type != "string" && keyData.Chunk == 0 {
+		// Later chunks of a key add to what the first one rebuilt
+		if err := r.command("DEL", key); err != nil {
+			return err
+		}
+	}
+	switch value.Type {
+	case "string":
+		err = r.command("SET", key, value.String)
+	case "list":
+		err = r.add("RPUSH", key, value.Elements, 1)
+	case "set":
+		err = r.add("SADD", key, value.Elements, 1)
+	case "hash":
+		args := make([]string, 0, 2*len(value.Fields))
+		for field, v := range value.Fields {
+			args = append(args, field, v)
+		}
+		err = r.add("HSET", key, args, 2)
+	case "zset":
+		args := make([]string, 0, 2*len(value.Members))
+		for _, m := range value.Members {
+			args = append(args, formatScore(m.Score), m.Member)
+		}
+		err = r.add("ZADD", key, args, 2)
+	}
+	if err != nil || expireAt == "" || keyData.More {
+		return err
+	}
+	return r.command("PEXPIREAT", key, expireAt)
+}
+
+// Close flushes the commands
+func (r *RESPWriter) Close() error {
+	return r.w.Flush()
+}
+
+// add writes elements, or field/value and score/member pairs when per is 2,
+// in batches of respBatch
+func (r *RESPWriter) add(name, key string, args []string, per int) error {
+	for start := 0; start < len(args); start += per * respBatch {
+		end := min(start+per*respBatch, len(args))
+		command := append([]string{name, key}, args[start:end]...)
+		if err := r.command(command...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// command writes one command as a RESP array of bulk strings
+func (r *RESPWriter) command(args ...string) error {
+	if _, err := fmt.Fprintf(r.w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := r.bulk(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulk writes a bulk string, returning the first write error
+func (r *RESPWriter) bulk(s string) error {
+	if _, err := fmt.Fprintf(r.w, "$%d\r\n", len(s)); err != nil {
+		return err
+	}
+	if _, err := r.w.WriteString(s); err != nil {
+		return err
+	}
+	_, err := r.w.WriteString("\r\n")
+	return err
+}
+
+// formatScore formats a sorted set score the way ZADD parses it
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "+inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	default:
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+}
+
+// RESPReader is a KeyReader over the commands written by a RESPWriter. The
+// commands of every key are read back into its value, so that the import
+// applies its retries, conflict policy and slot routing.
+type RESPReader struct {
+	r       *bufio.Reader
+	pending []string // First command of the next key
+}
+
+// NewRESPReader returns a reader of the commands in r
+func NewRESPReader(r io.Reader) *RESPReader {
+	return &RESPReader{r: bufio.NewReaderSize(r, 64<<10)}
+}
+
+// Next returns the next key, or io.EOF after the last one
+func (r *RESPReader) Next() (*KeyData, error) {
+	var keyData *KeyData
+	for {
+		args := r.pending
+		r.pending = nil
+		if args == nil {
+			var err error
+			args, err = r.readCommand()
+			if err == io.EOF && keyData != nil {
+				return keyData, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(args) < 2 {
+			return nil, fmt.Errorf("unsupported command in RESP stream: %q", args)
+		}
+
+		// The commands of a key follow each other
+		if keyData != nil && args[1] != keyData.Key {
+			r.pending = args
+			return keyData, nil
+		}
+		if keyData == nil {
+			keyData = &KeyData{Key: args[1], TTL: -1}
+		}
+		if err := applyCommand(keyData, args); err != nil {
+			return nil, fmt.Errorf("key %q: %w", keyData.Key, err)
+		}
+	}
+}
+
+// applyCommand applies a command written by a RESPWriter to keyData
+func applyCommand(keyData *KeyData, args []string) error {
+	values := args[2:]
+	switch name := strings.ToUpper(args[0]); name {
+	case "DEL":
+		// A DEL that is not followed by a rebuild is a tombstone
+		*keyData = KeyData{Key: keyData.Key, TTL: -1, Deleted: true}
+
+	case "RESTORE":
+		if len(values) < 2 || values[1] == "" {
+			return fmt.Errorf("invalid RESTORE")
+		}
+		ttl, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid RESTORE TTL %q", values[0])
+		}
+		keyData.Deleted = false
+		keyData.Dump = []byte(values[1])
+		keyData.Type = rdb.TypeName(keyData.Dump[0])
+		absolute := false
+		for _, option := range values[2:] {
+			absolute = absolute || strings.EqualFold(option, "ABSTTL")
+		}
+		switch {
+		case ttl == 0:
+			keyData.TTL = -1
+		case absolute:
+			keyData.TTL = time.Until(time.UnixMilli(ttl))
+		default:
+			keyData.TTL = time.Duration(ttl) * time.Millisecond
+		}
+
+	case "SET":
+		if len(values) != 1 {
+			return fmt.Errorf("invalid SET")
+		}
+		keyData.Deleted = false
+		keyData.Type, keyData.Value = "string", values[0]
+
+	case "RPUSH", "SADD":
+		keyType := map[string]string{"RPUSH": "list", "SADD": "set"}[name]
+		elements, _ := keyData.Value.([]string)
+		keyData.Deleted = false
+		keyData.Type, keyData.Value = keyType, append(elements, values...)
+
+	case "HSET":
+		if len(values)%2 != 0 {
+			return fmt.Errorf("invalid HSET")
+		}
+		fields, ok := keyData.Value.(map[string]string)
+		if !ok {
+			fields = make(map[string]string, len(values)/2)
+		}
+		for i := 0; i < len(values); i += 2 {
+			fields[values[i]] = values[i+1]
+		}
+		keyData.Deleted = false
+		keyData.Type, keyData.Value = "hash", fields
+
+	case "ZADD":
+		if len(values)%2 != 0 {
+			return fmt.Errorf("invalid ZADD")
+		}
+		members, _ := keyData.Value.([]redis.Z)
+		for i := 0; i < len(values); i += 2 {
+			score, err := strconv.ParseFloat(values[i], 64)
+			if err != nil {
+				return fmt.Errorf("invalid score %q", values[i])
+			}
+			members = append(members, redis.Z{Score: score, Member: values[i+1]})
+		}
+		keyData.Deleted = false
+		keyData.Type, keyData.Value = "zset", members
+
+	case "PEXPIREAT":
+		if len(values) != 1 {
+			return fmt.Errorf("invalid PEXPIREAT")
+		}
+		ms, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid PEXPIREAT time %q", values[0])
+		}
+		keyData.TTL = time.Until(time.UnixMilli(ms))
+
+	default:
+		return fmt.Errorf("unsupported command %s in RESP stream", name)
+	}
+	return nil
+}
+
+// readCommand reads an array of bulk strings
+func (r *RESPReader) readCommand() ([]string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[0] != '*' {
+		return nil, fmt.Errorf("invalid RESP stream: expected an array, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid RESP array length %q", line)
+	}
+
+	args := make([]string, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if len(line) < 2 || line[0] != '$' {
+			return nil, fmt.Errorf("invalid RESP stream: expected a bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid RESP bulk length %q", line)
+		}
+		var b strings.Builder
+		if _, err := io.CopyN(&b, r.r, int64(size)); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if _, err := r.r.Discard(2); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		args = append(args, b.String())
+	}
+	return args, nil
+}
+
+// readLine reads a line without its CRLF
+func (r *RESPReader) readLine() (string, error) {
+	line, err := r.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// unexpectedEOF turns the end of the input inside a command into an error
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package squirrel
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/seabfh/kv-squirrel/rdb"
+)
+
+// respCommands returns the names of the commands in a RESP stream
+func respCommands(t *testing.T, data []byte) []string {
+	t.Helper()
+	r := NewRESPReader(bytes.NewReader(data))
+	var names []string
+	for {
+		args, err := r.readCommand()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, args[0])
+	}
+}
+
+func TestRESPRoundTrip(t *testing.T) {
+	valueType, value, err := rdb.EncodeValue(&rdb.Value{Type: "string", String: "dumped"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := rdb.DumpPayload(valueType, value, rdb.EncodingVersion)
+
+	tests := []struct {
+		name     string
+		key      KeyData
+		commands string
+	}{
+		{"string", KeyData{Key: "s", Type: "string", TTL: -1, Value: "v"}, "SET"},
+		{"string with TTL", KeyData{Key: "s", Type: "string", TTL: time.Hour, Value: "v"}, "SET PEXPIREAT"},
+		{"list", KeyData{Key: "l", Type: "list", TTL: -1, Value: []string{"a", "b", "a"}}, "DEL RPUSH"},
+		{"set", KeyData{Key: "s", Type: "set", TTL: -1, Value: []string{"a", "b"}}, "DEL SADD"},
+		{"hash", KeyData{Key: "h", Type: "hash", TTL: time.Minute, Value: map[string]string{"f": "1", "g": "2"}},
+			"DEL HSET PEXPIREAT"},
+		{"zset", KeyData{Key: "z", Type: "zset", TTL: -1, Value: []redis.Z{
+			{Member: "a", Score: math.Inf(-1)}, {Member: "b", Score: 1.5}, {Member: "c", Score: math.Inf(1)}}},
+			"DEL ZADD"},
+		{"dump", KeyData{Key: "d", Type: "string", TTL: -1, Dump: payload}, "RESTORE"},
+		{"dump with TTL", KeyData{Key: "d", Type: "string", TTL: time.Hour, Dump: payload}, "RESTORE"},
+		{"tombstone", KeyData{Key: "x", TTL: -1, Deleted: true}, "DEL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewRESPWriter(&buf)
+			if err := w.Write(&tt.key); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if commands := strings.Join(respCommands(t, buf.Bytes()), " "); commands != tt.commands {
+				t.Errorf("wrote %s, want %s", commands, tt.commands)
+			}
+
+			r := NewRESPReader(&buf)
+			got, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Key != tt.key.Key || got.Type != tt.key.Type || got.Deleted != tt.key.Deleted ||
+				!reflect.DeepEqual(got.Value, tt.key.Value) || !bytes.Equal(got.Dump, tt.key.Dump) {
+				t.Errorf("read %+v, want %+v", got, tt.key)
+			}
+			checkTTL(t, got.TTL, tt.key.TTL)
+			if _, err := r.Next(); err != io.EOF {
+				t.Errorf("Next() after the last key = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+// checkTTL compares a TTL read back from an absolute expiry with the one written
+func checkTTL(t *testing.T, got, want time.Duration) {
+	t.Helper()
+	if want <= 0 {
+		if got != -1 {
+			t.Errorf("TTL = %v, want none", got)
+		}
+		return
+	}
+	if got > want || got < want-time.Second {
+		t.Errorf("TTL = %v, want about %v", got, want)
+	}
+}
+
+func TestRESPChunks(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewRESPWriter(&buf)
+	chunks := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	for i, chunk := range chunks {
+		record := &KeyData{Key: "l", Type: "list", TTL: time.Hour, Value: chunk, Chunk: i, More: i < len(chunks)-1}
+		if err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Write(&KeyData{Key: "x", Deleted: true}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// Only the first chunk deletes the key, only the last one sets its expiry
+	want := "DEL RPUSH RPUSH RPUSH PEXPIREAT DEL"
+	if commands := strings.Join(respCommands(t, buf.Bytes()), " "); commands != want {
+		t.Errorf("wrote %s, want %s", commands, want)
+	}
+
+	r := NewRESPReader(&buf)
+	got, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != "l" || !reflect.DeepEqual(got.Value, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("read %+v, want list l of a to e", got)
+	}
+	checkTTL(t, got.TTL, time.Hour)
+	if got, err := r.Next(); err != nil || got.Key != "x" || !got.Deleted {
+		t.Errorf("read %+v, %v, want tombstone of x", got, err)
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestRESPWriteError(t *testing.T) {
+	w := NewRESPWriter(failingWriter{})
+	// Larger than the buffer, so that the value itself is flushed
+	value := strings.Repeat("v", 128<<10)
+	if err := w.Write(&KeyData{Key: "s", Type: "string", Value: value}); err == nil {
+		t.Error("Write() to a failing writer succeeded")
+	}
+}
@@ -0,0 +1,68 @@
+package squirrel
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how transient Redis errors are retried
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts per key, including the first one
+	BaseDelay   time.Duration // Delay before the first retry
+	MaxDelay    time.Duration // Upper bound of the backoff delay
+}
+
+// IsRetryable reports whether err is transient and worth another attempt.
+// Errors such as BUSYKEY or a bad DUMP payload fail immediately.
+func IsRetryable(err error) bool {
+	switch ClassifyError(err) {
+	case ClassTimeout, ClassNetwork, ClassCluster, ClassLoading:
+		return true
+	default:
+		return false
+	}
+}
+
+// withRetry calls fn until it succeeds, fails with a non-retryable error or
+// runs out of attempts. onRetry, when set, is called before every retry.
+// It returns the number of retries that were made.
+func withRetry(ctx context.Context, policy RetryPolicy, fn func() error, onRetry func(err error)) (int, error) {
+	retries := 0
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !IsRetryable(err) || attempt >= policy.MaxAttempts {
+			return retries, err
+		}
+		if onRetry != nil {
+			onRetry(err)
+		}
+
+		timer := time.NewTimer(backoff(policy, attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return retries, err
+		case <-timer.C:
+		}
+		retries++
+	}
+}
+
+// backoff returns the exponential delay before retry number attempt, with jitter
+func backoff(policy RetryPolicy, attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	// Equal jitter: keep half of the delay and randomize the rest
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
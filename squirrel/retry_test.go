@@ -0,0 +1,92 @@
+package squirrel
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWithRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	tests := []struct {
+		name     string
+		errs     []error // Returned by the attempts in turn, nil after the last
+		attempts int
+		retries  int
+		failed   bool
+	}{
+		{"success", nil, 1, 0, false},
+		{"transient", []error{io.EOF, replyError("LOADING")}, 3, 2, false},
+		{"out of attempts", []error{io.EOF, io.EOF, io.EOF, io.EOF}, 3, 2, true},
+		{"not transient", []error{replyError("BUSYKEY Target key name already exists."), nil}, 1, 0, true},
+		{"invalid value", []error{errInvalid}, 1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts, retried := 0, 0
+			retries, err := withRetry(context.Background(), policy, func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			}, func(error) { retried++ })
+			if attempts != tt.attempts || retries != tt.retries || retried != tt.retries || (err != nil) != tt.failed {
+				t.Errorf("%d attempts, %d retries (%d reported), %v, want %d attempts, %d retries, error: %v",
+					attempts, retries, retried, err, tt.attempts, tt.retries, tt.failed)
+			}
+		})
+	}
+}
+
+func TestWithRetryCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	attempts := 0
+	done := make(chan error)
+	go func() {
+		_, err := withRetry(ctx, policy, func() error {
+			attempts++
+			return io.EOF
+		}, nil)
+		done <- err
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != io.EOF || attempts != 1 {
+			t.Errorf("cancelled retry = %v after %d attempts, want the last error after 1", err, attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("withRetry kept waiting after its context was cancelled")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		delay   time.Duration // Before jitter
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{50, time.Second},
+	}
+	for _, tt := range tests {
+		// Equal jitter keeps between half of the delay and all of it
+		for i := 0; i < 100; i++ {
+			if got := backoff(policy, tt.attempt); got < tt.delay/2 || got > tt.delay {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.delay/2, tt.delay)
+			}
+		}
+	}
+
+	if got := backoff(RetryPolicy{}, 3); got != 0 {
+		t.Errorf("backoff without delays = %v, want 0", got)
+	}
+}
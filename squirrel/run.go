@@ -0,0 +1,91 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+)
+
+// RunOptions are shared by exports, imports and migrations
+type RunOptions struct {
+	Limiter     Limiter        // Paces keys and bytes, nil for no limit
+	Throttle    Throttle       // Slows down or stops the run, nil for none
+	Retry       RetryPolicy    // Retries of transient errors per key
+	MaxFailures FailureLimit   // Aborts the run once too many keys failed
+	Report      *FailureReport // Receives every failed key, nil to discard
+	OnEvent     func(Event)    // Progress callback, called from the running goroutine
+	Logf        func(format string, args ...interface{})
+
+	priorFailures int // Failures before this run that count against MaxFailures
+}
+
+// keyTask is the key currently being processed by a run
+type keyTask struct {
+	Key   string
+	Node  string
+	Phase string
+}
+
+// wait applies the limiter and throttle before a key is processed
+func (o *RunOptions) wait(ctx context.Context, node string, keys, bytes int) error {
+	if err := waitLimiter(ctx, o.Limiter, node, keys, bytes); err != nil {
+		return interruptedOr(ctx, err)
+	}
+	if keys > 0 {
+		if err := waitThrottle(ctx, o.Throttle); err != nil {
+			return interruptedOr(ctx, err)
+		}
+	}
+	return nil
+}
+
+// runKey calls fn with retries and records its outcome in summary. It reports
+// whether the key succeeded, and returns an error only when the run has to stop.
+// The caller emits EventKeyDone once it is done with a successful key.
+func (o *RunOptions) runKey(ctx context.Context, summary *Summary, task *keyTask, fn func() error) (bool, error) {
+	retries, err := withRetry(ctx, o.Retry, fn, func(err error) {
+		notify(o.OnEvent, Event{Type: EventKeyRetry, Phase: task.Phase, Key: task.Key, Node: task.Node,
+			Err: err, Done: summary.Processed, Total: summary.Total})
+	})
+	if retries > 0 {
+		summary.Retries += retries
+		summary.RetriedKeys++
+	}
+	summary.Processed++
+
+	if err == nil {
+		return true, nil
+	}
+
+	if errors.Is(err, ErrKeyExists) {
+		// Left alone by the conflict policy, not a failure
+		summary.Skipped++
+		notify(o.OnEvent, Event{Type: EventKeySkipped, Phase: task.Phase, Key: task.Key, Node: task.Node,
+			Done: summary.Processed, Total: summary.Total})
+		return false, nil
+	}
+
+	if reportErr := o.Report.Record(task.Key, task.Phase, err); reportErr != nil {
+		return false, reportErr
+	}
+
+	event := Event{Phase: task.Phase, Key: task.Key, Node: task.Node, Err: err,
+		Done: summary.Processed, Total: summary.Total}
+	if errors.Is(err, ErrKeyExpired) {
+		summary.Expired++
+		event.Type = EventKeyExpired
+		notify(o.OnEvent, event)
+		return false, nil
+	}
+
+	summary.Failed++
+	event.Type = EventKeyFailed
+	notify(o.OnEvent, event)
+	return false, o.MaxFailures.Check(o.priorFailures+summary.Failed, summary.Total)
+}
+
+// keyDone records a successful key
+func (o *RunOptions) keyDone(summary *Summary, task *keyTask) {
+	summary.Succeeded++
+	notify(o.OnEvent, Event{Type: EventKeyDone, Phase: task.Phase, Key: task.Key, Node: task.Node,
+		Done: summary.Processed, Total: summary.Total})
+}
@@ -0,0 +1,308 @@
+package squirrel
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The logical schema is the JSON form of the values of keys exported by type:
+//
+//	string  "value"
+//	list    ["a", "b"]
+//	set     ["a", "b"]
+//	hash    {"field": "value"}
+//	zset    [{"member": "a", "score": "1.5"}, {"member": "b", "score": "+inf"}]
+//	stream  {"entries": [{"id": "1-0", "fields": ["f", "v"]}], "last_id": "1-0", "groups": [...]}
+//
+// Scores are strings so that +inf and -inf, which JSON numbers cannot hold,
+// survive. Strings, elements, hash values and members that are not valid
+// UTF-8 are written as {"b64": "..."}, since JSON strings cannot hold them.
+// Key names, hash fields and streams have no such form: keys with binary
+// ones fail to export as JSON. Dumps of earlier versions wrote sorted set
+// members as {"Member": "a", "Score": 1.5}; they are still read.
+
+// errNotUTF8 fails a key exported as JSON with binary data JSON cannot hold
+var errNotUTF8 = errors.New("not valid UTF-8, which JSON cannot hold")
+
+// ZMember is a sorted set member of the logical schema
+type ZMember struct {
+	Member string `json:"member"`
+	Score  string `json:"score"`
+}
+
+// MarshalJSON writes the member as a binary value when needed
+func (z ZMember) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Member interface{} `json:"member"`
+		Score  string      `json:"score"`
+	}{logicalString(z.Member), z.Score})
+}
+
+// binaryValue is a string of the logical schema that is not valid UTF-8
+type binaryValue struct {
+	B64 []byte `json:"b64"`
+}
+
+// logicalString returns s, or its binary form when it is not valid UTF-8
+func logicalString(s string) interface{} {
+	if utf8.ValidString(s) {
+		return s
+	}
+	return binaryValue{B64: []byte(s)}
+}
+
+// keyDataJSON is KeyData without its JSON methods
+type keyDataJSON KeyData
+
+// MarshalJSON writes a record with its value in the logical schema
+func (k KeyData) MarshalJSON() ([]byte, error) {
+	if !utf8.ValidString(k.Key) {
+		return nil, fmt.Errorf("key name: %w", errNotUTF8)
+	}
+	value, err := LogicalValue(k.Type, k.Value)
+	if err != nil {
+		return nil, err
+	}
+	record := keyDataJSON(k)
+	record.Value = value
+	return json.Marshal(record)
+}
+
+// UnmarshalJSON reads a record and checks its value against the logical
+// schema. A malformed record whose key can be read is not an error: it is kept
+// with the reason in Err, so that only that key fails to import.
+func (k *KeyData) UnmarshalJSON(data []byte) error {
+	var record struct {
+		keyDataJSON
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		var named struct {
+			Key string `json:"key"`
+		}
+		if json.Unmarshal(data, &named) != nil || named.Key == "" {
+			return err
+		}
+		*k = KeyData{Key: named.Key, invalid: fmt.Errorf("%w record: %v", errInvalid, err)}
+		return nil
+	}
+
+	*k = KeyData(record.keyDataJSON)
+	if len(record.Value) == 0 || string(record.Value) == "null" {
+		return nil
+	}
+	value, err := parseValue(k.Type, record.Value)
+	if err != nil {
+		k.invalid = fmt.Errorf("%w %s value: %w", errInvalid, k.Type, err)
+		return nil
+	}
+	k.Value = value
+	return nil
+}
+
+// Err returns why a record read from a dump does not match the logical schema
+func (k *KeyData) Err() error {
+	return k.invalid
+}
+
+// LogicalValue returns a value read by type in the shape of the logical
+// schema, as dumps write it. Values with binary names it cannot hold are an
+// error.
+func LogicalValue(keyType string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return logicalString(v), nil
+	case []string:
+		logical := make([]interface{}, len(v))
+		for i, element := range v {
+			logical[i] = logicalString(element)
+		}
+		return logical, nil
+	case map[string]string:
+		logical := make(map[string]interface{}, len(v))
+		for field, value := range v {
+			if !utf8.ValidString(field) {
+				return nil, fmt.Errorf("hash field %q: %w", field, errNotUTF8)
+			}
+			logical[field] = logicalString(value)
+		}
+		return logical, nil
+	case []redis.Z:
+		logical := make([]ZMember, len(v))
+		for i, z := range v {
+			logical[i] = ZMember{Member: fmt.Sprint(z.Member), Score: formatScore(z.Score)}
+		}
+		return logical, nil
+	case StreamValue:
+		if err := checkStreamUTF8(v); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// checkStreamUTF8 checks that JSON strings can hold the contents of a stream
+func checkStreamUTF8(stream StreamValue) error {
+	for _, entry := range stream.Entries {
+		for _, field := range entry.Fields {
+			if !utf8.ValidString(field) {
+				return fmt.Errorf("stream entry %s: %w", entry.ID, errNotUTF8)
+			}
+		}
+	}
+	for _, group := range stream.Groups {
+		if !utf8.ValidString(group.Name) {
+			return fmt.Errorf("stream group %q: %w", group.Name, errNotUTF8)
+		}
+		for _, consumer := range group.Consumers {
+			if !utf8.ValidString(consumer.Name) {
+				return fmt.Errorf("stream consumer %q: %w", consumer.Name, errNotUTF8)
+			}
+		}
+	}
+	return nil
+}
+
+// parseValue reads a value of the logical schema into the Go types values are
+// exported as. Values of other types are kept as plain JSON values.
+func parseValue(keyType string, raw json.RawMessage) (interface{}, error) {
+	switch keyType {
+	case "string":
+		s, ok := valueString(raw)
+		if !ok {
+			return nil, fmt.Errorf("not a string")
+		}
+		return s, nil
+
+	case "list", "set":
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, fmt.Errorf("not an array")
+		}
+		elements := make([]string, len(items))
+		for i, item := range items {
+			element, ok := valueString(item)
+			if !ok {
+				return nil, fmt.Errorf("element %d is not a string", i)
+			}
+			elements[i] = element
+		}
+		return elements, nil
+
+	case "hash":
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, fmt.Errorf("not an object")
+		}
+		fields := make(map[string]string, len(items))
+		for field, item := range items {
+			value, ok := valueString(item)
+			if !ok {
+				return nil, fmt.Errorf("field %q is not a string", field)
+			}
+			fields[field] = value
+		}
+		return fields, nil
+
+	case "zset":
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, fmt.Errorf("not an array of members")
+		}
+		members := make([]redis.Z, len(items))
+		for i, item := range items {
+			memberRaw, scoreRaw := item["member"], item["score"]
+			if memberRaw == nil && scoreRaw == nil {
+				// Written by earlier versions
+				memberRaw, scoreRaw = item["Member"], item["Score"]
+			}
+			member, ok := valueString(memberRaw)
+			if !ok {
+				return nil, fmt.Errorf("member %d is not a string", i)
+			}
+			score, err := parseScore(scoreRaw)
+			if err != nil {
+				return nil, fmt.Errorf("member %q: %w", member, err)
+			}
+			members[i] = redis.Z{Score: score, Member: member}
+		}
+		return members, nil
+
+	case "stream":
+		var stream StreamValue
+		if err := json.Unmarshal(raw, &stream); err != nil {
+			return nil, err
+		}
+		if err := checkStream(stream); err != nil {
+			return nil, err
+		}
+		return stream, nil
+
+	default:
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
+
+// parseScore reads a score written as a string, or as a number by earlier versions
+func parseScore(raw json.RawMessage) (float64, error) {
+	s, ok := jsonString(raw)
+	if !ok {
+		var score float64
+		if raw == nil || string(raw) == "null" || json.Unmarshal(raw, &score) != nil {
+			return 0, fmt.Errorf("score is not a number")
+		}
+		return score, nil
+	}
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, fmt.Errorf("invalid score %q", s)
+	}
+	return score, nil
+}
+
+// valueString reads a string of the logical schema, written as a JSON string
+// or in binary form
+func valueString(raw json.RawMessage) (string, bool) {
+	if s, ok := jsonString(raw); ok {
+		return s, true
+	}
+	if len(raw) == 0 || raw[0] != '{' {
+		return "", false
+	}
+	var binary map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &binary); err != nil || len(binary) != 1 {
+		return "", false
+	}
+	encoded, ok := jsonString(binary["b64"])
+	if !ok {
+		return "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// jsonString reads a JSON string; null and other values are not strings
+func jsonString(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 || raw[0] != '"' {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
@@ -0,0 +1,156 @@
+package squirrel
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseScore(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want float64
+		err  bool
+	}{
+		{`"1.5"`, 1.5, false},
+		{`"-3"`, -3, false},
+		{`"1e300"`, 1e300, false},
+		{`"+inf"`, math.Inf(1), false},
+		{`"-inf"`, math.Inf(-1), false},
+		{`"inf"`, math.Inf(1), false},
+		// Written as numbers by earlier versions
+		{`2.25`, 2.25, false},
+		{`0`, 0, false},
+		{`"nan"`, 0, true},
+		{`"high"`, 0, true},
+		{`null`, 0, true},
+		{`true`, 0, true},
+		{``, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			var raw json.RawMessage
+			if tt.raw != "" {
+				raw = json.RawMessage(tt.raw)
+			}
+			got, err := parseScore(raw)
+			if (err != nil) != tt.err || !tt.err && got != tt.want {
+				t.Errorf("parseScore(%s) = %v, %v, want %v (error: %v)", tt.raw, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		keyType string
+		raw     string
+		want    interface{}
+		err     bool
+	}{
+		{"string", `"v"`, "v", false},
+		{"string", `""`, "", false},
+		{"string", `1`, nil, true},
+		{"list", `["a","b","a"]`, []string{"a", "b", "a"}, false},
+		{"list", `[]`, []string{}, false},
+		{"list", `["a",1]`, nil, true},
+		{"set", `"a"`, nil, true},
+		{"hash", `{"f":"v"}`, map[string]string{"f": "v"}, false},
+		{"hash", `{"f":null}`, nil, true},
+		{"hash", `["f","v"]`, nil, true},
+		{"zset", `[{"member":"a","score":"1.5"},{"member":"b","score":"+inf"},{"member":"c","score":"-inf"}]`,
+			[]redis.Z{{Member: "a", Score: 1.5}, {Member: "b", Score: math.Inf(1)}, {Member: "c", Score: math.Inf(-1)}}, false},
+		{"zset", `[{"Member":"a","Score":1.5}]`, []redis.Z{{Member: "a", Score: 1.5}}, false},
+		{"zset", `[{"member":"a","score":"x"}]`, nil, true},
+		{"zset", `[{"member":1,"score":"1"}]`, nil, true},
+		{"zset", `[{"member":"a"}]`, nil, true},
+		{"zset", `{"a":1}`, nil, true},
+		{"stream", `{"entries":[{"id":"1-1","fields":["f","v"]}],"last_id":"1-1"}`,
+			StreamValue{Entries: []StreamEntry{{ID: "1-1", Fields: []string{"f", "v"}}}, LastID: "1-1"}, false},
+		{"stream", `{"entries":[{"id":"1-1","fields":["f"]}],"last_id":"1-1"}`, nil, true},
+		{"string", `{"b64":"/wA="}`, "\xff\x00", false},
+		{"string", `{"b64":"not base64"}`, nil, true},
+		{"string", `{"hex":"ff"}`, nil, true},
+		{"list", `["a",{"b64":"/w=="}]`, []string{"a", "\xff"}, false},
+		{"hash", `{"f":{"b64":"/w=="}}`, map[string]string{"f": "\xff"}, false},
+		{"zset", `[{"member":{"b64":"/w=="},"score":"1"}]`, []redis.Z{{Member: "\xff", Score: 1}}, false},
+		{"module", `{"any":[1]}`, map[string]interface{}{"any": []interface{}{1.0}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyType+" "+tt.raw, func(t *testing.T) {
+			got, err := parseValue(tt.keyType, json.RawMessage(tt.raw))
+			if (err != nil) != tt.err || !tt.err && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseValue = %#v, %v, want %#v (error: %v)", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestKeyDataJSON(t *testing.T) {
+	key := KeyData{Key: "z", Type: "zset", Value: []redis.Z{
+		{Member: "a", Score: math.Inf(-1)}, {Member: "b", Score: 0.1}, {Member: "c", Score: math.Inf(1)}}}
+	data, err := json.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"key":"z","type":"zset","ttl":0,"value":[{"member":"a","score":"-inf"},{"member":"b","score":"0.1"},{"member":"c","score":"+inf"}],"dump":null}`
+	if string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+
+	var read KeyData
+	if err := json.Unmarshal(data, &read); err != nil || read.Err() != nil || !reflect.DeepEqual(read.Value, key.Value) {
+		t.Errorf("Unmarshal = %+v, %v, %v, want %+v", read, err, read.Err(), key)
+	}
+
+	// A malformed value fails only its key
+	if err := json.Unmarshal([]byte(`{"key":"l","type":"list","value":"a"}`), &read); err != nil || read.Key != "l" || read.Err() == nil {
+		t.Errorf("Unmarshal of a malformed value = %+v, %v, want key l with Err set", read, err)
+	}
+	if err := json.Unmarshal([]byte(`{"key":"l","ttl":"soon"}`), &read); err != nil || read.Key != "l" || read.Err() == nil {
+		t.Errorf("Unmarshal of a malformed record = %+v, %v, want key l with Err set", read, err)
+	}
+	if err := json.Unmarshal([]byte(`{"ttl":"soon"}`), &read); err == nil {
+		t.Error("Unmarshal of a malformed record without a key succeeded, want an error")
+	}
+}
+
+func TestKeyDataJSONBinary(t *testing.T) {
+	keys := []KeyData{
+		{Key: "s", Type: "string", Value: "\xff\xfe"},
+		{Key: "l", Type: "list", Value: []string{"a", "\x80"}},
+		{Key: "h", Type: "hash", Value: map[string]string{"f": "\xc3\x28"}},
+		{Key: "z", Type: "zset", Value: []redis.Z{{Member: "\xff", Score: 2}}},
+	}
+	for _, key := range keys {
+		data, err := json.Marshal(key)
+		if err != nil {
+			t.Fatalf("Marshal(%s) = %v", key.Key, err)
+		}
+		var read KeyData
+		if err := json.Unmarshal(data, &read); err != nil || read.Err() != nil || !reflect.DeepEqual(read.Value, key.Value) {
+			t.Errorf("%s: Unmarshal(%s) = %#v, %v, %v, want %#v", key.Key, data, read.Value, err, read.Err(), key.Value)
+		}
+	}
+
+	data, _ := json.Marshal(KeyData{Key: "s", Type: "string", Value: "\xff"})
+	if want := `{"key":"s","type":"string","ttl":0,"value":{"b64":"/w=="},"dump":null}`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+
+	// Names have no binary form
+	unwritable := []KeyData{
+		{Key: "\xff", Type: "string", Value: "v"},
+		{Key: "h", Type: "hash", Value: map[string]string{"\xff": "v"}},
+		{Key: "x", Type: "stream", Value: StreamValue{Entries: []StreamEntry{{ID: "1-1", Fields: []string{"f", "\xff"}}}}},
+	}
+	for _, key := range unwritable {
+		if _, err := json.Marshal(key); !errors.Is(err, errNotUTF8) {
+			t.Errorf("Marshal(%q) = %v, want errNotUTF8", key.Key, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package squirrel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// slotCount is the number of hash slots of a Redis cluster
+const slotCount = 16384
+
+// keySlot returns the cluster hash slot of key, honouring {hash tags}
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % slotCount)
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum used by Redis cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// slotTags holds a short hash tag for every slot, found once by trying tags in turn
+var slotTags struct {
+	once sync.Once
+	tags [slotCount]string
+}
+
+// slotTag returns a hash tag whose keys map to slot
+func slotTag(slot int) string {
+	slotTags.once.Do(func() {
+		for i, found := int64(0), 0; found < slotCount; i++ {
+			tag := strconv.FormatInt(i, 36)
+			if s := crc16(tag) % slotCount; slotTags.tags[s] == "" {
+				slotTags.tags[s] = tag
+				found++
+			}
+		}
+	})
+	return slotTags.tags[slot]
+}
+
+// tempKey returns the key an import builds in chunks before renaming it to
+// key. It is in the same slot whatever key looks like, so that RENAME works
+// in a cluster.
+func tempKey(key string) string {
+	return "{" + slotTag(keySlot(key)) + "}kv-squirrel:tmp:" + key
+}
+
+// chunkMarker returns the key counting the chunks of key applied to its
+// tempKey, in the same slot
+func chunkMarker(key string) string {
+	return tempKey(key) + ":chunk"
+}
+
+// SlotRange is a range of hash slots, both ends included
+type SlotRange struct {
+	Start, End int
+}
+
+// ParseSlotLayout parses the slots of every shard: shards are separated by
+// commas and the ranges of one shard joined by +, e.g. "0-5460,5461-10922+16383"
+func ParseSlotLayout(layout string) ([][]SlotRange, error) {
+	var shards [][]SlotRange
+	for _, shard := range strings.Split(layout, ",") {
+		var ranges []SlotRange
+		for _, part := range strings.Split(shard, "+") {
+			part = strings.TrimSpace(part)
+			start, end, isRange := strings.Cut(part, "-")
+			if !isRange {
+				end = start
+			}
+			first, err1 := strconv.Atoi(start)
+			last, err2 := strconv.Atoi(end)
+			if err1 != nil || err2 != nil || first < 0 || last >= slotCount || first > last {
+				return nil, fmt.Errorf("invalid slot range %q", part)
+			}
+			ranges = append(ranges, SlotRange{Start: first, End: last})
+		}
+		shards = append(shards, ranges)
+	}
+	return shards, nil
+}
+
+// ShardWriter is a KeyWriter passing every key to the writer of the shard serving its slot
+type ShardWriter struct {
+	writers []KeyWriter
+	shardOf [slotCount]int
+}
+
+// NewShardWriter returns a writer routing keys by the layout, with one writer per shard
+func NewShardWriter(layout [][]SlotRange, writers []KeyWriter) (*ShardWriter, error) {
+	if len(layout) != len(writers) {
+		return nil, fmt.Errorf("%d shards but %d writers", len(layout), len(writers))
+	}
+	s := &ShardWriter{writers: writers}
+	for slot := range s.shardOf {
+		s.shardOf[slot] = -1
+	}
+	for shard, ranges := range layout {
+		for _, r := range ranges {
+			for slot := r.Start; slot <= r.End; slot++ {
+				if s.shardOf[slot] >= 0 {
+					return nil, fmt.Errorf("slot %d is in shards %d and %d", slot, s.shardOf[slot], shard)
+				}
+				s.shardOf[slot] = shard
+			}
+		}
+	}
+	return s, nil
+}
+
+// Write passes the key to the writer of its shard
+func (s *ShardWriter) Write(keyData *KeyData) error {
+	slot := keySlot(keyData.Key)
+	shard := s.shardOf[slot]
+	if shard < 0 {
+		return fmt.Errorf("slot %d of %q is not in the slot layout", slot, keyData.Key)
+	}
+	return s.writers[shard].Write(keyData)
+}
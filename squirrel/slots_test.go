@@ -0,0 +1,110 @@
+package squirrel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeySlot(t *testing.T) {
+	// Reference values of the cluster specification and CLUSTER KEYSLOT
+	if got := crc16("123456789"); got != 0x31C3 {
+		t.Errorf("crc16(123456789) = %#x, want 0x31c3", got)
+	}
+
+	tests := []struct {
+		key    string
+		hashed string // Part of the key that is hashed
+		slot   int
+	}{
+		{"foo", "foo", 12182},
+		{"bar", "bar", 5061},
+		{"hello", "hello", 866},
+		{"{user1000}.following", "user1000", -1},
+		{"{user1000}.followers", "user1000", -1},
+		{"foo{}{bar}", "foo{}{bar}", -1},
+		{"foo{{bar}}zap", "{bar", -1},
+		{"foo{bar}{zap}", "bar", -1},
+		{"{", "{", -1},
+	}
+	for _, tt := range tests {
+		got := keySlot(tt.key)
+		if want := int(crc16(tt.hashed) % slotCount); got != want {
+			t.Errorf("keySlot(%q) = %d, want the slot of %q, %d", tt.key, got, tt.hashed, want)
+		}
+		if tt.slot >= 0 && got != tt.slot {
+			t.Errorf("keySlot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+}
+
+func TestTempKey(t *testing.T) {
+	for _, key := range []string{"foo", "{user1000}.following", "a{b", ""} {
+		if keySlot(tempKey(key)) != keySlot(key) || keySlot(chunkMarker(key)) != keySlot(key) {
+			t.Errorf("temporary keys of %q are not in its slot %d", key, keySlot(key))
+		}
+	}
+}
+
+func TestParseSlotLayout(t *testing.T) {
+	tests := []struct {
+		layout string
+		want   [][]SlotRange
+		err    bool
+	}{
+		{"0-16383", [][]SlotRange{{{0, 16383}}}, false},
+		{"0-5460, 5461-10922,10923-16383", [][]SlotRange{{{0, 5460}}, {{5461, 10922}}, {{10923, 16383}}}, false},
+		{"0-100+200+300-400,101-199", [][]SlotRange{{{0, 100}, {200, 200}, {300, 400}}, {{101, 199}}}, false},
+		{"0-16384", nil, true},
+		{"10-5", nil, true},
+		{"-1", nil, true},
+		{"0-100,", nil, true},
+		{"a-b", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			got, err := ParseSlotLayout(tt.layout)
+			if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSlotLayout = %v, %v, want %v (error: %v)", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+type recordingWriter struct{ keys []string }
+
+func (w *recordingWriter) Write(keyData *KeyData) error {
+	w.keys = append(w.keys, keyData.Key)
+	return nil
+}
+
+func TestShardWriter(t *testing.T) {
+	layout, err := ParseSlotLayout("0-8191,8192-16382")
+	if err != nil {
+		t.Fatal(err)
+	}
+	low, high := &recordingWriter{}, &recordingWriter{}
+	writer, err := NewShardWriter(layout, []KeyWriter{low, high})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"hello", "foo", "bar"} {
+		if err := writer.Write(&KeyData{Key: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(low.keys, []string{"hello", "bar"}) || !reflect.DeepEqual(high.keys, []string{"foo"}) {
+		t.Errorf("shards got %q and %q, want [hello bar] and [foo]", low.keys, high.keys)
+	}
+	// Slot 16383 is not in the layout
+	if err := writer.Write(&KeyData{Key: "{" + slotTag(16383) + "}"}); err == nil {
+		t.Error("Write of a key outside the layout succeeded, want an error")
+	}
+
+	if _, err := NewShardWriter(layout, []KeyWriter{low}); err == nil {
+		t.Error("NewShardWriter with a writer missing succeeded, want an error")
+	}
+	overlap, _ := ParseSlotLayout("0-100,100-200")
+	if _, err := NewShardWriter(overlap, []KeyWriter{low, high}); err == nil {
+		t.Error("NewShardWriter with overlapping shards succeeded, want an error")
+	}
+}
@@ -0,0 +1,212 @@
+// Package squirrel exports, imports and migrates keys between Redis clusters.
+//
+// The Exporter scans a source and reads keys with DUMP (or by type), the
+// Importer writes them with RESTORE (or by type) and the Migrator does both
+// without an intermediate file. All of them take a redis.UniversalClient so
+// callers can inject their own cluster or standalone clients.
+package squirrel
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/seabfh/kv-squirrel/internal/runstatus"
+)
+
+// KeyData represents a Redis key with all its metadata
+type KeyData struct {
+	Key   string        `json:"key"`
+	Type  string        `json:"type"`
+	TTL   time.Duration `json:"ttl"`
+	Value interface{}   `json:"value"`
+	Dump  []byte        `json:"dump"` // Using DUMP for complex types
+
+	// Deleted marks a tombstone: the key was deleted during a consistent
+	// export, or failed after some of its chunks were written
+	Deleted bool `json:"deleted,omitempty"`
+
+	// Chunk numbers the records of a collection exported by type in pieces,
+	// from 0. More is set on every record of the key but the last; the
+	// importer builds the key aside and renames it into place after the last one.
+	Chunk int  `json:"chunk,omitempty"`
+	More  bool `json:"more,omitempty"`
+
+	invalid error // Why the record read from a dump is malformed, see Err
+}
+
+// Size estimates the number of bytes transferred for a key
+func (k *KeyData) Size() int {
+	if len(k.Dump) > 0 {
+		return len(k.Key) + len(k.Dump)
+	}
+	logical, err := LogicalValue(k.Type, k.Value)
+	if err != nil {
+		return len(k.Key)
+	}
+	value, err := json.Marshal(logical)
+	if err != nil {
+		return len(k.Key)
+	}
+	return len(k.Key) + len(value)
+}
+
+// KeyReader yields the keys to import. Next returns io.EOF after the last key.
+type KeyReader interface {
+	Next() (*KeyData, error)
+}
+
+// KeyWriter receives exported keys
+type KeyWriter interface {
+	Write(keyData *KeyData) error
+}
+
+// Limiter paces work in keys and bytes, globally and per node
+type Limiter interface {
+	Wait(ctx context.Context, node string, keys, bytes int) error
+}
+
+// Throttle delays or stops work, for example based on server health
+type Throttle interface {
+	Wait(ctx context.Context) error
+}
+
+// FailureLimit aborts a run once too many keys failed: an absolute count or a
+// percentage of all keys. The zero value never aborts.
+type FailureLimit = runstatus.FailureLimit
+
+var (
+	// ErrPartial is returned when a run finished but some keys failed
+	ErrPartial = runstatus.ErrPartial
+
+	// ErrAborted is returned when a run was stopped by the failure limit or a throttle
+	ErrAborted = runstatus.ErrAborted
+
+	// ErrInterrupted is returned when the context was cancelled during a run
+	ErrInterrupted = runstatus.ErrInterrupted
+)
+
+// Summary counts what happened to the keys of a run
+type Summary struct {
+	Total       int // Keys selected for the run
+	Processed   int // Keys handled, including failed and expired ones; an import counts every chunk
+	Succeeded   int
+	Expired     int // Keys that disappeared between SCAN and DUMP
+	Failed      int
+	RetriedKeys int // Keys that needed more than one attempt
+	Retries     int
+	Changed     int // Keys re-exported because they changed during a consistent export
+	Deleted     int // Tombstones written or applied
+	Skipped     int // Keys left alone because they already existed on the target
+	Fallback    int // Keys written by type because the target rejected their DUMP payload
+	Chunked     int // Keys too big for DUMP/RESTORE, moved by type in chunks
+	Duration    time.Duration
+
+	Native  TransportStats // Keys moved by MIGRATE between the servers
+	Restore TransportStats // Keys moved through this process with DUMP/RESTORE
+
+	Consistent   bool      // The export captured every change until ConsistentAt
+	ConsistentAt time.Time // When a consistent export saw no further changes
+}
+
+// TransportStats measures the keys moved by one transport of a migration
+type TransportStats struct {
+	Keys     int
+	Bytes    int64 // Only known for keys passing through this process
+	Duration time.Duration
+}
+
+// KeysPerSec returns the throughput in keys per second
+func (t TransportStats) KeysPerSec() float64 {
+	if t.Duration <= 0 {
+		return 0
+	}
+	return float64(t.Keys) / t.Duration.Seconds()
+}
+
+// add records keys moved in d
+func (t *TransportStats) add(keys int, bytes int64, d time.Duration) {
+	t.Keys += keys
+	t.Bytes += bytes
+	t.Duration += d
+}
+
+// EventType identifies an Event
+type EventType int
+
+const (
+	EventScanNode   EventType = iota // Scanning of Node started
+	EventScanDone                    // Scanning finished, Total keys found
+	EventKeyDone                     // Key was processed successfully
+	EventKeyExpired                  // Key expired before it could be read
+	EventKeyFailed                   // Key failed permanently with Err
+	EventKeyRetry                    // Key is retried after Err
+	EventKeySkipped                  // Key already existed on the target and was left alone
+)
+
+// Event reports the progress of a run to the OnEvent callback
+type Event struct {
+	Type  EventType
+	Phase string // PhaseExport or PhaseImport
+	Key   string
+	Node  string
+	Err   error
+	Done  int // Keys processed so far
+	Total int // Keys selected for the run, 0 when unknown
+}
+
+// notify calls fn when it is set
+func notify(fn func(Event), event Event) {
+	if fn != nil {
+		fn(event)
+	}
+}
+
+// logger returns logf, or a function that discards its arguments
+func logger(logf func(format string, args ...interface{})) func(format string, args ...interface{}) {
+	if logf != nil {
+		return logf
+	}
+	return func(string, ...interface{}) {}
+}
+
+// nodeForKey returns the address of the master serving key, used for per-node rate limits
+func nodeForKey(ctx context.Context, client redis.UniversalClient, key string) string {
+	switch c := client.(type) {
+	case *redis.ClusterClient:
+		master, err := c.MasterForKey(ctx, key)
+		if err != nil {
+			return ""
+		}
+		return master.Options().Addr
+	case *redis.Client:
+		return c.Options().Addr
+	default:
+		return ""
+	}
+}
+
+// waitLimiter waits on limiter when it is set
+func waitLimiter(ctx context.Context, limiter Limiter, node string, keys, bytes int) error {
+	if limiter == nil {
+		return nil
+	}
+	return limiter.Wait(ctx, node, keys, bytes)
+}
+
+// waitThrottle waits on throttle when it is set
+func waitThrottle(ctx context.Context, throttle Throttle) error {
+	if throttle == nil {
+		return nil
+	}
+	return throttle.Wait(ctx)
+}
+
+// interruptedOr returns ErrInterrupted when err was caused by ctx being cancelled
+func interruptedOr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	return err
+}
@@ -0,0 +1,253 @@
+package squirrel
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// StreamValue is a stream of the logical schema. A stream exported in chunks
+// has entries in every record, and its last ID and groups in the last one.
+type StreamValue struct {
+	Entries []StreamEntry `json:"entries"`
+	LastID  string        `json:"last_id,omitempty"` // Last generated ID, restored with XSETID
+	Groups  []StreamGroup `json:"groups,omitempty"`
+}
+
+// StreamEntry is a stream entry with its field/value pairs in order
+type StreamEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+// StreamGroup is a consumer group and the last entry delivered to it
+type StreamGroup struct {
+	Name            string           `json:"name"`
+	LastDeliveredID string           `json:"last_delivered_id"`
+	Consumers       []StreamConsumer `json:"consumers,omitempty"`
+}
+
+// StreamConsumer is a consumer with its pending entries
+type StreamConsumer struct {
+	Name    string          `json:"name"`
+	Pending []StreamPending `json:"pending,omitempty"`
+}
+
+// StreamPending is an entry delivered to a consumer but not acknowledged
+type StreamPending struct {
+	ID         string `json:"id"`
+	Idle       int64  `json:"idle_ms"`
+	Deliveries int64  `json:"deliveries"`
+}
+
+// readStream reads the entries of a stream with XRANGE, size at a time, and
+// passes them to fn. The last chunk also has the last ID and the consumer
+// groups with their pending entries.
+func readStream(ctx context.Context, client redis.UniversalClient, key string, size int64, fn func(value interface{}) error) error {
+	var page *StreamValue
+	for start := "-"; ; {
+		entries, err := xrange(ctx, client, key, start, size)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			if page != nil {
+				if err := fn(*page); err != nil {
+					return err
+				}
+			}
+			page = &StreamValue{Entries: entries}
+			start = nextStreamID(entries[len(entries)-1].ID)
+		}
+		if int64(len(entries)) < size {
+			break
+		}
+	}
+
+	if page == nil {
+		page = &StreamValue{}
+	}
+	if err := readStreamGroups(ctx, client, key, size, page); err != nil {
+		return err
+	}
+	return fn(*page)
+}
+
+// xrange reads up to count entries from start on, keeping the order of their fields
+func xrange(ctx context.Context, client redis.UniversalClient, key, start string, count int64) ([]StreamEntry, error) {
+	reply, err := client.Do(ctx, "XRANGE", key, start, "+", "COUNT", count).Slice()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]StreamEntry, 0, len(reply))
+	for _, item := range reply {
+		entry, ok := item.([]interface{})
+		if !ok || len(entry) != 2 {
+			return nil, fmt.Errorf("unexpected XRANGE reply %v", item)
+		}
+		pairs, _ := entry[1].([]interface{})
+		fields := make([]string, len(pairs))
+		for i, pair := range pairs {
+			fields[i] = fmt.Sprint(pair)
+		}
+		entries = append(entries, StreamEntry{ID: fmt.Sprint(entry[0]), Fields: fields})
+	}
+	return entries, nil
+}
+
+// readStreamGroups reads the last ID of a stream, its consumer groups and
+// their pending entries, size at a time
+func readStreamGroups(ctx context.Context, client redis.UniversalClient, key string, size int64, stream *StreamValue) error {
+	info, err := client.XInfoStream(ctx, key).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return ErrKeyExpired
+		}
+		return fmt.Errorf("failed to read stream info: %w", err)
+	}
+	stream.LastID = info.LastGeneratedID
+
+	groups, err := client.XInfoGroups(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read consumer groups: %w", err)
+	}
+	for _, info := range groups {
+		group := StreamGroup{Name: info.Name, LastDeliveredID: info.LastDeliveredID}
+
+		consumers, err := client.XInfoConsumers(ctx, key, info.Name).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read consumers of group %s: %w", info.Name, err)
+		}
+		index := make(map[string]int, len(consumers))
+		for _, consumer := range consumers {
+			index[consumer.Name] = len(group.Consumers)
+			group.Consumers = append(group.Consumers, StreamConsumer{Name: consumer.Name})
+		}
+
+		for start := "-"; ; {
+			pending, err := client.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: key, Group: info.Name, Start: start, End: "+", Count: size,
+			}).Result()
+			if err != nil {
+				return fmt.Errorf("failed to read pending entries of group %s: %w", info.Name, err)
+			}
+			for _, p := range pending {
+				i, ok := index[p.Consumer]
+				if !ok {
+					i = len(group.Consumers)
+					index[p.Consumer] = i
+					group.Consumers = append(group.Consumers, StreamConsumer{Name: p.Consumer})
+				}
+				group.Consumers[i].Pending = append(group.Consumers[i].Pending, StreamPending{
+					ID: p.ID, Idle: p.Idle.Milliseconds(), Deliveries: p.RetryCount,
+				})
+			}
+			if int64(len(pending)) < size {
+				break
+			}
+			start = nextStreamID(pending[len(pending)-1].ID)
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return nil
+}
+
+// writeStream queues the commands recreating a stream record in key: XADD
+// with the original IDs, XSETID, XGROUP CREATE at the last delivered ID, and
+// XCLAIM of the pending entries of every consumer. Pending entries that were
+// deleted from the stream cannot be claimed and are lost.
+func writeStream(ctx context.Context, pipe redis.Pipeliner, key string, keyData *KeyData) error {
+	stream, ok := keyData.Value.(StreamValue)
+	if !ok {
+		return fmt.Errorf("%w stream value", errInvalid)
+	}
+
+	for _, entry := range stream.Entries {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: key, ID: entry.ID, Values: entry.Fields})
+	}
+	if keyData.More {
+		return nil
+	}
+
+	if keyData.Chunk == 0 && len(stream.Entries) == 0 && len(stream.Groups) == 0 {
+		// An empty stream without groups is created with a group that is dropped again
+		pipe.XGroupCreateMkStream(ctx, key, "kv-squirrel", "$")
+		pipe.XGroupDestroy(ctx, key, "kv-squirrel")
+	}
+	for _, group := range stream.Groups {
+		pipe.XGroupCreateMkStream(ctx, key, group.Name, group.LastDeliveredID)
+	}
+	if stream.LastID != "" {
+		pipe.Do(ctx, "XSETID", key, stream.LastID)
+	}
+	for _, group := range stream.Groups {
+		for _, consumer := range group.Consumers {
+			if len(consumer.Pending) == 0 {
+				pipe.XGroupCreateConsumer(ctx, key, group.Name, consumer.Name)
+			}
+			for _, p := range consumer.Pending {
+				pipe.Do(ctx, "XCLAIM", key, group.Name, consumer.Name, 0, p.ID,
+					"IDLE", p.Idle, "RETRYCOUNT", p.Deliveries, "FORCE", "JUSTID")
+			}
+		}
+	}
+	return nil
+}
+
+// checkStream validates the IDs and fields of a stream read from a dump
+func checkStream(stream StreamValue) error {
+	for _, entry := range stream.Entries {
+		if !validStreamID(entry.ID) {
+			return fmt.Errorf("%w entry ID %q", errInvalid, entry.ID)
+		}
+		if len(entry.Fields)%2 != 0 {
+			return fmt.Errorf("entry %s has a field without value", entry.ID)
+		}
+	}
+	if stream.LastID != "" && !validStreamID(stream.LastID) {
+		return fmt.Errorf("%w last ID %q", errInvalid, stream.LastID)
+	}
+	for _, group := range stream.Groups {
+		if group.Name == "" || !validStreamID(group.LastDeliveredID) {
+			return fmt.Errorf("%w group %q at %q", errInvalid, group.Name, group.LastDeliveredID)
+		}
+		for _, consumer := range group.Consumers {
+			if consumer.Name == "" {
+				return fmt.Errorf("consumer without name in group %s", group.Name)
+			}
+			for _, p := range consumer.Pending {
+				if !validStreamID(p.ID) || p.Idle < 0 || p.Deliveries < 0 {
+					return fmt.Errorf("%w pending entry %q of consumer %s", errInvalid, p.ID, consumer.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// nextStreamID returns the ID after id, so that ranges continue after the
+// last entry read without the exclusive ranges of Redis 6.2
+func nextStreamID(id string) string {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseUint(msPart, 10, 64)
+	seq, _ := strconv.ParseUint(seqPart, 10, 64)
+	if seq == math.MaxUint64 {
+		return strconv.FormatUint(ms+1, 10) + "-0"
+	}
+	return strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(seq+1, 10)
+}
+
+// validStreamID reports whether id has the <ms>-<seq> form of stream IDs
+func validStreamID(id string) bool {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return false
+	}
+	_, msErr := strconv.ParseUint(ms, 10, 64)
+	_, seqErr := strconv.ParseUint(seq, 10, 64)
+	return msErr == nil && seqErr == nil
+}
@@ -0,0 +1,57 @@
+package squirrel
+
+import "testing"
+
+func TestNextStreamID(t *testing.T) {
+	tests := []struct {
+		id, want string
+	}{
+		{"0-0", "0-1"},
+		{"1700000000000-5", "1700000000000-6"},
+		{"5-18446744073709551615", "6-0"},
+	}
+	for _, tt := range tests {
+		if got := nextStreamID(tt.id); got != tt.want {
+			t.Errorf("nextStreamID(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestCheckStream(t *testing.T) {
+	valid := func() StreamValue {
+		return StreamValue{
+			Entries: []StreamEntry{{ID: "1-0", Fields: []string{"f", "v"}}},
+			LastID:  "1-0",
+			Groups: []StreamGroup{{Name: "g", LastDeliveredID: "0-0", Consumers: []StreamConsumer{
+				{Name: "c", Pending: []StreamPending{{ID: "1-0", Idle: 10, Deliveries: 1}}},
+			}}},
+		}
+	}
+	tests := []struct {
+		name   string
+		change func(*StreamValue)
+		err    bool
+	}{
+		{"valid", func(*StreamValue) {}, false},
+		{"empty", func(s *StreamValue) { *s = StreamValue{} }, false},
+		{"entry ID", func(s *StreamValue) { s.Entries[0].ID = "1" }, true},
+		{"negative entry ID", func(s *StreamValue) { s.Entries[0].ID = "-1-0" }, true},
+		{"field without value", func(s *StreamValue) { s.Entries[0].Fields = []string{"f"} }, true},
+		{"last ID", func(s *StreamValue) { s.LastID = "last" }, true},
+		{"group name", func(s *StreamValue) { s.Groups[0].Name = "" }, true},
+		{"group ID", func(s *StreamValue) { s.Groups[0].LastDeliveredID = "$" }, true},
+		{"consumer name", func(s *StreamValue) { s.Groups[0].Consumers[0].Name = "" }, true},
+		{"pending ID", func(s *StreamValue) { s.Groups[0].Consumers[0].Pending[0].ID = "x-1" }, true},
+		{"pending idle", func(s *StreamValue) { s.Groups[0].Consumers[0].Pending[0].Idle = -1 }, true},
+		{"pending deliveries", func(s *StreamValue) { s.Groups[0].Consumers[0].Pending[0].Deliveries = -1 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := valid()
+			tt.change(&stream)
+			if err := checkStream(stream); (err != nil) != tt.err {
+				t.Errorf("checkStream = %v, want error: %v", err, tt.err)
+			}
+		})
+	}
+}
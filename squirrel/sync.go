@@ -0,0 +1,470 @@
+package squirrel
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SyncOptions configures a Syncer
+type SyncOptions struct {
+	MigrateOptions
+
+	// ConfigureNotifications enables keyspace notifications on masters that
+	// do not publish them while the sync runs
+	ConfigureNotifications bool
+	// Workers apply changed keys concurrently, a key is never applied by two at once
+	Workers int
+	// TopologyInterval is how often masters are rediscovered to resubscribe after a failover
+	TopologyInterval time.Duration
+}
+
+// Sync phases reported by SyncStatus
+const (
+	SyncPhaseInitial   = "initial"   // Copying every key
+	SyncPhaseStreaming = "streaming" // Applying changes as they happen
+	SyncPhaseStopped   = "stopped"
+)
+
+// SyncStatus is a snapshot of a running sync
+type SyncStatus struct {
+	Phase         string    `json:"phase"`
+	Queued        int       `json:"queued"`         // Changed keys waiting to be applied
+	InFlight      int       `json:"in_flight"`      // Changed keys being applied
+	OldestAge     float64   `json:"oldest_age_sec"` // Age of the oldest queued change
+	LagBytes      int64     `json:"lag_bytes"`      // Replication stream not applied yet
+	Applied       int       `json:"applied"`        // Changes applied since streaming started
+	Deleted       int       `json:"deleted"`        // Deletions and expirations propagated
+	Failed        int       `json:"failed"`         // Keys that failed, initial copy included
+	Subscriptions int       `json:"subscriptions"`  // Masters whose changes are followed
+	Gaps          int       `json:"gaps"`           // Times changes may have been missed
+	StartedAt     time.Time `json:"started_at"`
+	LastAppliedAt time.Time `json:"last_applied_at,omitzero"`
+}
+
+// Syncer copies every key and then keeps the target up to date with the
+// changes on the source, using keyspace notifications of every master
+type Syncer struct {
+	syncState
+	source redis.UniversalClient
+	target redis.UniversalClient
+	opts   SyncOptions
+	queue  *syncQueue
+
+	// Reads changed keys from the masters, in chunks when they are too big for DUMP
+	exporter *Exporter
+
+	watcher *keyspaceWatcher // Guarded by mu
+}
+
+// syncState is the progress shared by the sync engines
+type syncState struct {
+	runOpts *RunOptions
+
+	mu      sync.Mutex
+	summary *Summary
+	status  SyncStatus
+}
+
+// NewSyncer returns a syncer copying keys from source to target
+func NewSyncer(source, target redis.UniversalClient, opts SyncOptions) *Syncer {
+	if opts.Pattern == "" {
+		opts.Pattern = "*"
+	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.TopologyInterval <= 0 {
+		opts.TopologyInterval = 30 * time.Second
+	}
+	s := &Syncer{
+		source: source,
+		target: target,
+		opts:   opts,
+		queue:  newSyncQueue(),
+		exporter: NewExporter(source, ExportOptions{
+			Pattern:     opts.Pattern,
+			UseDump:     opts.UseDump,
+			ChunkSize:   opts.ChunkSize,
+			MaxDumpSize: opts.MaxDumpSize,
+		}),
+	}
+	s.runOpts = &s.opts.RunOptions
+	return s
+}
+
+// Run copies every selected key and then applies changes until ctx is
+// cancelled. Changes during the initial copy are queued and applied after it.
+// Stopping after the initial copy is not an error; stopping during it returns
+// ErrInterrupted.
+func (s *Syncer) Run(ctx context.Context) (*Summary, error) {
+	logf := logger(s.opts.Logf)
+	s.start(&Summary{})
+	defer s.setPhase(SyncPhaseStopped)
+
+	match := func(key string) bool { return matchPattern(s.opts.Pattern, key) }
+	watcher, err := watchKeyspace(ctx, s.source, s.opts.ConfigureNotifications, match, s.queue.Push, logf)
+	if err != nil {
+		return s.summary, err
+	}
+	defer watcher.Close()
+	s.mu.Lock()
+	s.watcher = watcher
+	s.mu.Unlock()
+	logf("✓ Watching keyspace events on %d masters\n", watcher.Subscriptions())
+
+	// Initial copy; keys changed meanwhile are applied once it is done
+	summary, err := NewMigrator(s.source, s.target, s.opts.MigrateOptions).Migrate(ctx)
+	if err != nil && !errors.Is(err, ErrPartial) {
+		return summary, err
+	}
+	s.mu.Lock()
+	s.summary = summary
+	s.status.Failed = summary.Failed
+	s.mu.Unlock()
+	logf("✓ Initial copy done: %d keys, streaming changes\n", summary.Succeeded)
+	s.setPhase(SyncPhaseStreaming)
+
+	go s.refreshTopology(ctx, watcher)
+
+	// Workers finish the key in flight after ctx is cancelled
+	keyCtx := context.WithoutCancel(ctx)
+	var wg sync.WaitGroup
+	errCh := make(chan error, s.opts.Workers)
+	for i := 0; i < s.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.work(ctx, keyCtx); err != nil {
+				errCh <- err
+			}
+		}()
+	}
+
+	// The queue wakes the workers up once ctx is cancelled
+	go func() {
+		<-ctx.Done()
+		s.queue.Close()
+	}()
+	wg.Wait()
+	s.queue.Close()
+
+	select {
+	case err := <-errCh:
+		return summary, err
+	default:
+	}
+	return summary, s.result()
+}
+
+// work applies changed keys until the queue is closed
+func (s *Syncer) work(ctx, keyCtx context.Context) error {
+	for {
+		key, ok := s.queue.Take()
+		if !ok {
+			return nil
+		}
+		err := s.apply(ctx, keyCtx, key)
+		s.queue.Done(key)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// apply copies the current state of key to the target, deleting it there
+// when it no longer exists on the source. Keys too big for DUMP are copied by
+// type in chunks, each chunk imported as soon as it is read.
+func (s *Syncer) apply(ctx, keyCtx context.Context, key string) error {
+	task := &keyTask{Key: key, Node: nodeForKey(ctx, s.source, key), Phase: PhaseExport}
+	if err := s.opts.wait(ctx, task.Node, 1, 0); err != nil {
+		if errors.Is(err, ErrInterrupted) {
+			// Stopped while waiting, the key is not applied
+			return nil
+		}
+		return err
+	}
+
+	var keyData *KeyData
+	var fallback bool
+	size := 0
+	apply := func() error {
+		task.Phase = PhaseExport
+		keyData, size = nil, 0
+		var single *KeyData
+		err := s.exporter.exportRecords(keyCtx, s.source, key, func(record *KeyData) error {
+			keyData = record
+			size += record.Size()
+			if record.Chunk == 0 && !record.More {
+				single = record
+				return nil
+			}
+			task.Phase = PhaseImport
+			if _, err := importKey(keyCtx, s.target, record, s.opts.UseDump); err != nil {
+				return err
+			}
+			task.Phase = PhaseExport
+			return nil
+		})
+		if errors.Is(err, ErrKeyExpired) {
+			// Deleted, expired or evicted on the source, possibly after some of its chunks
+			task.Phase = PhaseImport
+			keyData = &KeyData{Key: key, Deleted: true}
+			_, err = importKey(keyCtx, s.target, keyData, false)
+			return err
+		}
+		if err != nil || single == nil {
+			return err
+		}
+		task.Phase = PhaseImport
+		fallback, err = importKey(keyCtx, s.target, single, s.opts.UseDump)
+		return err
+	}
+
+	retries, err := withRetry(keyCtx, s.opts.Retry, apply, func(err error) {
+		notify(s.opts.OnEvent, Event{Type: EventKeyRetry, Phase: task.Phase, Key: key, Node: task.Node, Err: err})
+	})
+
+	if err := s.record(task, keyData != nil && keyData.Deleted, retries, err); err != nil || keyData == nil {
+		return err
+	}
+	if fallback && err == nil {
+		s.fellBack()
+	}
+	if s.opts.UseDump && err == nil && !keyData.Deleted && keyData.Dump == nil {
+		s.chunked()
+	}
+
+	if err := s.opts.wait(ctx, task.Node, 0, size); err != nil && !errors.Is(err, ErrInterrupted) {
+		return err
+	}
+	return nil
+}
+
+// start resets the state for a new run
+func (st *syncState) start(summary *Summary) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.summary = summary
+	st.status = SyncStatus{Phase: SyncPhaseInitial, StartedAt: time.Now()}
+}
+
+// fellBack counts a key written by type because the target rejected its payload
+func (st *syncState) fellBack() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.summary.Fallback++
+}
+
+// chunked counts a key moved by type in chunks because it was too big for DUMP
+func (st *syncState) chunked() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.summary.Chunked++
+}
+
+// record counts the outcome of applying a change. It returns an error only
+// when the sync has to stop.
+func (st *syncState) record(task *keyTask, deleted bool, retries int, err error) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	summary := st.summary
+	summary.Total++
+	summary.Processed++
+	if retries > 0 {
+		summary.Retries += retries
+		summary.RetriedKeys++
+	}
+
+	if err != nil {
+		summary.Failed++
+		st.status.Failed++
+		if reportErr := st.runOpts.Report.Record(task.Key, task.Phase, err); reportErr != nil {
+			return reportErr
+		}
+		notify(st.runOpts.OnEvent, Event{Type: EventKeyFailed, Phase: task.Phase, Key: task.Key, Node: task.Node, Err: err,
+			Done: summary.Processed, Total: summary.Total})
+		return st.runOpts.MaxFailures.Check(summary.Failed, summary.Total)
+	}
+
+	summary.Succeeded++
+	st.status.Applied++
+	st.status.LastAppliedAt = time.Now()
+	if deleted {
+		summary.Deleted++
+		st.status.Deleted++
+	}
+	notify(st.runOpts.OnEvent, Event{Type: EventKeyDone, Phase: task.Phase, Key: task.Key, Node: task.Node,
+		Done: summary.Processed, Total: summary.Total})
+	return nil
+}
+
+// result returns ErrPartial when changes failed to apply
+func (st *syncState) result() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.summary.Failed > 0 {
+		return fmt.Errorf("%d keys failed to sync: %w", st.summary.Failed, ErrPartial)
+	}
+	return nil
+}
+
+// setPhase records the phase of the sync
+func (st *syncState) setPhase(phase string) {
+	st.mu.Lock()
+	st.status.Phase = phase
+	st.mu.Unlock()
+}
+
+// refreshTopology resubscribes to the masters of the source, for example
+// after a failover promoted a replica, until ctx is cancelled
+func (s *Syncer) refreshTopology(ctx context.Context, watcher *keyspaceWatcher) {
+	logf := logger(s.opts.Logf)
+	ticker := time.NewTicker(s.opts.TopologyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := watcher.Subscriptions()
+			if err := watcher.Refresh(ctx); err != nil && ctx.Err() == nil {
+				logf("⚠ Failed to refresh source masters: %v\n", err)
+			}
+			if after := watcher.Subscriptions(); after != before {
+				logf("  Watching keyspace events on %d masters (was %d)\n", after, before)
+			}
+		}
+	}
+}
+
+// Status returns the current state of the sync. It is safe to call from any goroutine.
+func (s *Syncer) Status() SyncStatus {
+	s.mu.Lock()
+	status := s.status
+	watcher := s.watcher
+	s.mu.Unlock()
+
+	status.Queued, status.InFlight, status.OldestAge = s.queue.Stats()
+	if watcher != nil {
+		status.Subscriptions = watcher.Subscriptions()
+		status.Gaps = watcher.Lost()
+	}
+	return status
+}
+
+// syncQueue holds changed keys in the order of their first change. A key is
+// queued once however often it changes, and is not handed out again while it
+// is being applied: a change arriving meanwhile parks it until Done.
+type syncQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	ready    *list.List               // Queued keys that can be taken, oldest first
+	queued   map[string]*list.Element // Elements of ready by key
+	parked   map[string]time.Time     // Keys changed while in flight, with their first change
+	inFlight map[string]bool
+	closed   bool
+}
+
+// queuedKey is an element of syncQueue.ready
+type queuedKey struct {
+	key      string
+	queuedAt time.Time
+}
+
+func newSyncQueue() *syncQueue {
+	q := &syncQueue{
+		ready:    list.New(),
+		queued:   make(map[string]*list.Element),
+		parked:   make(map[string]time.Time),
+		inFlight: make(map[string]bool),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push queues a changed key
+func (q *syncQueue) Push(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, queued := q.queued[key]; queued {
+		return
+	}
+	if q.inFlight[key] {
+		if _, parked := q.parked[key]; !parked {
+			q.parked[key] = time.Now()
+		}
+		return
+	}
+	q.enqueue(key, time.Now())
+}
+
+// enqueue appends a key to the keys that can be taken and wakes up a worker
+func (q *syncQueue) enqueue(key string, queuedAt time.Time) {
+	q.queued[key] = q.ready.PushBack(queuedKey{key: key, queuedAt: queuedAt})
+	q.cond.Signal()
+}
+
+// Take waits for the oldest key that is not being applied. It returns false
+// once the queue is closed.
+func (q *syncQueue) Take() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.ready.Len() == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return "", false
+	}
+	key := q.ready.Remove(q.ready.Front()).(queuedKey).key
+	delete(q.queued, key)
+	q.inFlight[key] = true
+	return key, true
+}
+
+// Done marks a key taken from the queue as applied
+func (q *syncQueue) Done(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inFlight, key)
+	// A change that arrived while the key was applied can be taken now
+	if queuedAt, parked := q.parked[key]; parked {
+		delete(q.parked, key)
+		q.enqueue(key, queuedAt)
+	}
+}
+
+// Close wakes up and stops every worker waiting in Take
+func (q *syncQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Stats returns the queued and in-flight keys and the age of the oldest
+// queued change in seconds
+func (q *syncQueue) Stats() (queued, inFlight int, oldestAge float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var oldest time.Time
+	if front := q.ready.Front(); front != nil {
+		oldest = front.Value.(queuedKey).queuedAt
+	}
+	// At most one parked key per worker
+	for _, queuedAt := range q.parked {
+		if oldest.IsZero() || queuedAt.Before(oldest) {
+			oldest = queuedAt
+		}
+	}
+	if !oldest.IsZero() {
+		oldestAge = time.Since(oldest).Seconds()
+	}
+	return q.ready.Len() + len(q.parked), len(q.inFlight), oldestAge
+}
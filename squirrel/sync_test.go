@@ -0,0 +1,37 @@
+package squirrel
+
+import "testing"
+
+func TestSyncQueue(t *testing.T) {
+	q := newSyncQueue()
+	for _, key := range []string{"a", "b", "a", "c"} {
+		q.Push(key)
+	}
+	if queued, inFlight, _ := q.Stats(); queued != 3 || inFlight != 0 {
+		t.Fatalf("Stats() = %d queued, %d in flight, want 3, 0", queued, inFlight)
+	}
+
+	take := func(want string) {
+		t.Helper()
+		if key, ok := q.Take(); !ok || key != want {
+			t.Fatalf("Take() = %q, %v, want %q", key, ok, want)
+		}
+	}
+	take("a")
+
+	// A change of a key in flight waits until the key is done
+	q.Push("a")
+	take("b")
+	take("c")
+	if queued, inFlight, _ := q.Stats(); queued != 1 || inFlight != 3 {
+		t.Fatalf("Stats() = %d queued, %d in flight, want 1, 3", queued, inFlight)
+	}
+	q.Done("b")
+	q.Done("a")
+	take("a")
+
+	q.Close()
+	if key, ok := q.Take(); ok {
+		t.Fatalf("Take() after Close = %q, want none", key)
+	}
+}
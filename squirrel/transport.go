@@ -0,0 +1,228 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultMigrateBatch   = 100
+	defaultMigrateTimeout = 5 * time.Second
+)
+
+// nativeBatch is a group of keys that one MIGRATE command can move: they live
+// in the same slot of the source and belong to the same target master
+type nativeBatch struct {
+	source *redis.Client // Source master holding the keys
+	target string        // Address of the target master
+	keys   []string
+}
+
+// migrateNative moves keys with MIGRATE, sent by the source masters straight
+// to the target masters. Keys of a batch that MIGRATE failed to move are
+// copied with DUMP/RESTORE instead, and so are all later keys of a source
+// master that rejects MIGRATE, for example because of ACLs or because the
+// target cannot read its payloads.
+func (m *Migrator) migrateNative(ctx, keyCtx context.Context, summary *Summary, keys []string) error {
+	logf := logger(m.opts.Logf)
+
+	batches, err := m.nativeBatches(ctx, keys)
+	if err != nil {
+		logf("⚠ Cannot use MIGRATE, falling back to DUMP/RESTORE: %v\n", err)
+		batches = []nativeBatch{{keys: keys}}
+	}
+
+	rejected := make(map[string]bool)
+	for _, batch := range batches {
+		if ctx.Err() != nil {
+			return ErrInterrupted
+		}
+
+		if batch.source != nil && !rejected[batch.source.Options().Addr] {
+			node := batch.source.Options().Addr
+			failed, err := m.migrateBatch(ctx, keyCtx, summary, batch)
+			if err != nil {
+				return err
+			}
+			if failed == nil {
+				continue
+			}
+			if migrateRejected(failed) {
+				rejected[node] = true
+				logf("⚠ MIGRATE from %s to %s is rejected, using DUMP/RESTORE for the keys of %s: %v\n",
+					node, batch.target, node, failed)
+			} else {
+				logf("⚠ MIGRATE from %s to %s failed, using DUMP/RESTORE for %d keys: %v\n",
+					node, batch.target, len(batch.keys), failed)
+			}
+		}
+
+		for _, key := range batch.keys {
+			if ctx.Err() != nil {
+				return ErrInterrupted
+			}
+			if err := m.migrateKey(ctx, keyCtx, summary, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateBatch moves a batch with MIGRATE, retrying transient errors. It
+// returns why the batch was not moved as failed, and an error only when the
+// run has to stop.
+func (m *Migrator) migrateBatch(ctx, keyCtx context.Context, summary *Summary, batch nativeBatch) (failed, err error) {
+	node := batch.source.Options().Addr
+	if err := m.opts.wait(ctx, node, len(batch.keys), 0); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	var reply string
+	_, failed = withRetry(ctx, m.opts.Retry, func() error {
+		var err error
+		reply, err = batch.source.Do(keyCtx, m.migrateArgs(batch)...).Text()
+		return err
+	}, nil)
+	if failed != nil {
+		return failed, nil
+	}
+
+	// NOKEY: every key of the batch expired since it was scanned. Keys that
+	// expired in a batch that moved others cannot be told apart.
+	if reply == "NOKEY" {
+		for _, key := range batch.keys {
+			summary.Processed++
+			summary.Expired++
+			notify(m.opts.OnEvent, Event{Type: EventKeyExpired, Phase: PhaseExport, Key: key, Node: node,
+				Done: summary.Processed, Total: summary.Total})
+		}
+		return nil, nil
+	}
+
+	summary.Native.add(len(batch.keys), 0, time.Since(start))
+	for _, key := range batch.keys {
+		summary.Processed++
+		m.opts.keyDone(summary, &keyTask{Key: key, Node: node, Phase: PhaseImport})
+	}
+	return nil, nil
+}
+
+// migrateRejected reports whether a MIGRATE error repeats for every batch of
+// a source: the command or its arguments are refused, or the target refuses
+// what the source sends, such as payloads of a newer Redis version
+func migrateRejected(err error) bool {
+	var reply redis.Error
+	if !errors.As(err, &reply) {
+		return false
+	}
+	if ClassifyError(err) == ClassAuth {
+		return true
+	}
+	for _, prefix := range []string{
+		"ERR syntax", "ERR unknown command", "ERR wrong number of arguments", "ERR Target instance replied with error",
+	} {
+		if strings.HasPrefix(reply.Error(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateArgs builds MIGRATE host port "" 0 timeout COPY REPLACE [AUTH2 user pass] KEYS ...
+func (m *Migrator) migrateArgs(batch nativeBatch) []interface{} {
+	host, port, _ := net.SplitHostPort(batch.target)
+
+	timeout := m.opts.MigrateTimeout
+	if timeout <= 0 {
+		timeout = defaultMigrateTimeout
+	}
+
+	args := []interface{}{"MIGRATE", host, port, "", 0, timeout.Milliseconds(), "COPY", "REPLACE"}
+	switch user, pass := clientAuth(m.target); {
+	case user != "":
+		args = append(args, "AUTH2", user, pass)
+	case pass != "":
+		args = append(args, "AUTH", pass)
+	}
+
+	args = append(args, "KEYS")
+	for _, key := range batch.keys {
+		args = append(args, key)
+	}
+	return args
+}
+
+// nativeBatches groups keys by source master, source slot and target master,
+// in the order the groups are first seen, split into batches of MigrateBatch
+func (m *Migrator) nativeBatches(ctx context.Context, keys []string) ([]nativeBatch, error) {
+	size := m.opts.MigrateBatch
+	if size <= 0 {
+		size = defaultMigrateBatch
+	}
+
+	type group struct {
+		source string
+		slot   int
+		target string
+	}
+	index := make(map[group]int)
+	var batches []nativeBatch
+
+	for _, key := range keys {
+		source, err := masterForKey(ctx, m.source, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the source master of %s: %w", key, err)
+		}
+		target, err := masterForKey(ctx, m.target, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the target master of %s: %w", key, err)
+		}
+
+		// Multi-key commands on a cluster need every key in the same slot
+		g := group{source: source.Options().Addr, slot: -1, target: target.Options().Addr}
+		if _, ok := m.source.(*redis.ClusterClient); ok {
+			g.slot = keySlot(key)
+		}
+
+		i, ok := index[g]
+		if !ok || len(batches[i].keys) >= size {
+			batches = append(batches, nativeBatch{source: source, target: g.target})
+			i = len(batches) - 1
+			index[g] = i
+		}
+		batches[i].keys = append(batches[i].keys, key)
+	}
+	return batches, nil
+}
+
+// masterForKey returns the master serving key. A standalone client is its own master.
+func masterForKey(ctx context.Context, client redis.UniversalClient, key string) (*redis.Client, error) {
+	switch c := client.(type) {
+	case *redis.ClusterClient:
+		return c.MasterForKey(ctx, key)
+	case *redis.Client:
+		return c, nil
+	default:
+		return nil, fmt.Errorf("unsupported client type %T", client)
+	}
+}
+
+// clientAuth returns the credentials client connects with
+func clientAuth(client redis.UniversalClient) (user, pass string) {
+	switch c := client.(type) {
+	case *redis.ClusterClient:
+		return c.Options().Username, c.Options().Password
+	case *redis.Client:
+		return c.Options().Username, c.Options().Password
+	default:
+		return "", ""
+	}
+}
@@ -0,0 +1,74 @@
+package squirrel
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateNative(t *testing.T) {
+	ioErr := errors.New("IOERR error or timeout reading to target instance")
+	tests := []struct {
+		name     string
+		replies  []interface{} // MIGRATE replies in turn, OK after the last
+		migrates int
+		restored []string // Copied key by key, the others moved by MIGRATE
+	}{
+		{"moved", nil, 2, nil},
+		{"transient error retried", []interface{}{ioErr}, 3, nil},
+		// Out of attempts: only that batch falls back
+		{"failed batch", []interface{}{ioErr, ioErr, ioErr}, 4, []string{"a", "b"}},
+		// Every later batch of the source falls back
+		{"rejected", []interface{}{errors.New("ERR Target instance replied with error: ERR DUMP payload version or checksum are wrong")},
+			1, []string{"a", "b", "c", "d"}},
+		{"no permission", []interface{}{errors.New("NOPERM this user has no permissions to run the 'migrate' command")},
+			1, []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, target := newFakeRedis(), newFakeRedis()
+			keys := []string{"a", "b", "c", "d"}
+			for _, key := range keys {
+				source.data[key] = "v" + key
+			}
+			migrates := 0
+			sourceClient := fakeClient(t, func(args []string) interface{} {
+				if !strings.EqualFold(args[0], "migrate") {
+					return source.handle(args)
+				}
+				migrates++
+				if migrates <= len(tt.replies) {
+					return tt.replies[migrates-1]
+				}
+				return "OK"
+			})
+
+			m := NewMigrator(sourceClient, fakeClient(t, target.handle), MigrateOptions{
+				RunOptions:   RunOptions{Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}},
+				Keys:         keys,
+				Transport:    TransportMigrate,
+				MigrateBatch: 2,
+			})
+			summary, err := m.Migrate(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			native := len(keys) - len(tt.restored)
+			if migrates != tt.migrates || summary.Native.Keys != native || summary.Restore.Keys != len(tt.restored) {
+				t.Errorf("%d MIGRATE calls, %d keys moved, %d copied, want %d calls, %d moved, %d copied",
+					migrates, summary.Native.Keys, summary.Restore.Keys, tt.migrates, native, len(tt.restored))
+			}
+			// The fake MIGRATE leaves the target alone
+			restored := make(map[string]interface{})
+			for _, key := range tt.restored {
+				restored[key] = "v" + key
+			}
+			if !reflect.DeepEqual(target.data, restored) {
+				t.Errorf("target holds %v, want %v", target.data, restored)
+			}
+		})
+	}
+}
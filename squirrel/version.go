@@ -0,0 +1,80 @@
+package squirrel
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/seabfh/kv-squirrel/rdb"
+)
+
+// rdbVersions lists the first release of every RDB version, newest first.
+// Valkey and KeyDB report the Redis release they are compatible with.
+var rdbVersions = []struct {
+	major, minor int
+	version      int
+}{
+	{7, 4, 12},
+	{7, 2, 11},
+	{7, 0, 10},
+	{5, 0, 9},
+	{4, 0, 8},
+	{3, 2, 7},
+	{2, 6, 6},
+}
+
+// ServerRDBVersion returns the newest RDB version a server reads, derived
+// from the redis_version of INFO server. For a cluster it is the lowest
+// version of its masters.
+func ServerRDBVersion(ctx context.Context, client redis.UniversalClient) (int, error) {
+	var mu sync.Mutex
+	lowest := 0
+	err := forEachMaster(ctx, client, func(ctx context.Context, master *redis.Client) error {
+		info, err := master.Info(ctx, "server").Result()
+		if err != nil {
+			return err
+		}
+		release := parseInfo(info)["redis_version"]
+		version, err := rdbVersionOf(release)
+		if err != nil {
+			return fmt.Errorf("%s: %w", master.Options().Addr, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if lowest == 0 || version < lowest {
+			lowest = version
+		}
+		return nil
+	})
+	return lowest, err
+}
+
+// rdbVersionOf returns the RDB version of a Redis release such as 7.2.4
+func rdbVersionOf(release string) (int, error) {
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return 0, fmt.Errorf("unknown redis_version %q", release)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("unknown redis_version %q", release)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("unknown redis_version %q", release)
+	}
+
+	if major > rdbVersions[0].major {
+		// Newer releases read at least what this tool decodes
+		return rdb.MaxVersion, nil
+	}
+	for _, v := range rdbVersions {
+		if major > v.major || major == v.major && minor >= v.minor {
+			return v.version, nil
+		}
+	}
+	return 0, fmt.Errorf("redis_version %s is too old", release)
+}
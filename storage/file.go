@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+)
+
+// stdin and stdout back the "-" location
+var (
+	stdin  io.Reader = os.Stdin
+	stdout io.Writer = os.Stdout
+)
+
+// fileBackend stores dumps on the local file system
+type fileBackend struct{}
+
+// Create creates or truncates the file at location
+func (fileBackend) Create(ctx context.Context, location *url.URL) (io.WriteCloser, error) {
+	file, err := os.Create(filePath(location))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	return fileWriter{file}, nil
+}
+
+// Open opens the file at location
+func (fileBackend) Open(ctx context.Context, location *url.URL) (io.ReadCloser, error) {
+	file, err := os.Open(filePath(location))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	return file, nil
+}
+
+// filePath returns the path of a file location, relative paths included
+func filePath(location *url.URL) string {
+	if location.Scheme == "" {
+		return location.Path
+	}
+	// file://relative/path puts the first element in the host
+	return location.Host + location.Path
+}
+
+// fileWriter is a file that is removed when its dump is aborted
+type fileWriter struct {
+	*os.File
+}
+
+// Abort closes and removes the file
+func (w fileWriter) Abort(error) {
+	w.Close()
+	os.Remove(w.Name())
+}
+
+// nopWriteCloser leaves the underlying writer open, so that stdout is not closed
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
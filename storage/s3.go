@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// defaultPartSize is the size of every multipart upload part. Up to this many
+// bytes are buffered in memory while uploading.
+const defaultPartSize = 16 << 20
+
+// s3Backend stores dumps in S3-compatible object storage:
+//
+//	s3://bucket/path/dump.json?endpoint=localhost:9000&insecure=true
+//
+// The endpoint, region and TLS setting default to S3_ENDPOINT, AWS_REGION and
+// S3_INSECURE. Credentials come from the AWS or MinIO environment variables,
+// ~/.aws/credentials or the EC2 instance role.
+type s3Backend struct{}
+
+// Create streams the dump to the bucket with a multipart upload
+func (s3Backend) Create(ctx context.Context, location *url.URL) (io.WriteCloser, error) {
+	client, bucket, object, err := s3Client(location)
+	if err != nil {
+		return nil, err
+	}
+
+	partSize := uint64(defaultPartSize)
+	if value := location.Query().Get("part-size"); value != "" {
+		mib, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || mib < 5 {
+			return nil, fmt.Errorf("invalid part-size %q in %s: must be at least 5 (MiB)", value, location.Redacted())
+		}
+		partSize = mib << 20
+	}
+
+	reader, writer := io.Pipe()
+	upload := &s3Writer{pipe: writer, done: make(chan error, 1)}
+	go func() {
+		// An unknown size makes PutObject upload one part at a time as data arrives
+		_, err := client.PutObject(ctx, bucket, object, reader, -1, minio.PutObjectOptions{
+			ContentType: contentType(object),
+			PartSize:    partSize,
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to upload %s: %w", location.Redacted(), err)
+		}
+		// Unblock the writer if the upload stopped early
+		reader.CloseWithError(err)
+		upload.done <- err
+	}()
+	return upload, nil
+}
+
+// Open streams the dump from the bucket
+func (s3Backend) Open(ctx context.Context, location *url.URL) (io.ReadCloser, error) {
+	client, bucket, object, err := s3Client(location)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := client.GetObject(ctx, bucket, object, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", location.Redacted(), err)
+	}
+	// GetObject is lazy, Stat reports a missing object or bucket right away
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, fmt.Errorf("failed to open %s: %w", location.Redacted(), err)
+	}
+	return obj, nil
+}
+
+// contentType returns the Content-Type of an object from its extension:
+// application/json for JSON dumps, application/octet-stream for RDB and RESP
+// files and anything unknown
+func contentType(object string) string {
+	if t := mime.TypeByExtension(path.Ext(object)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// s3Writer feeds an upload running in the background
+type s3Writer struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+// Close completes the upload and waits for it to finish
+func (w *s3Writer) Close() error {
+	w.pipe.Close()
+	return <-w.done
+}
+
+// Abort fails the upload with err and waits for it to stop: the object is
+// not created and the parts uploaded so far are removed
+func (w *s3Writer) Abort(err error) {
+	w.pipe.CloseWithError(err)
+	<-w.done
+}
+
+// s3Client returns a client for the endpoint of location and the bucket and
+// object name it refers to
+func s3Client(location *url.URL) (*minio.Client, string, string, error) {
+	bucket := location.Host
+	object := strings.TrimPrefix(location.Path, "/")
+	if bucket == "" || object == "" {
+		return nil, "", "", fmt.Errorf("invalid location %s: expected s3://bucket/object", location.Redacted())
+	}
+
+	query := location.Query()
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = os.Getenv("S3_ENDPOINT")
+	}
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	region := query.Get("region")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	insecure := query.Get("insecure")
+	if insecure == "" {
+		insecure = os.Getenv("S3_INSECURE")
+	}
+
+	// Accept endpoints given as URLs, e.g. http://localhost:9000
+	secure := true
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		secure = endpoint[:i] != "http"
+		endpoint = endpoint[i+3:]
+	}
+	if insecure != "" {
+		value, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("invalid insecure setting %q: %w", insecure, err)
+		}
+		secure = !value
+	}
+
+	client, err := minio.New(strings.TrimSuffix(endpoint, "/"), &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		}),
+		Secure: secure,
+		Region: region,
+	})
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to create S3 client for %s: %w", endpoint, err)
+	}
+	return client, bucket, object, nil
+}
@@ -0,0 +1,120 @@
+// Package storage reads and writes dumps on local disk, stdin/stdout or
+// S3-compatible object storage. The backend is selected by the URL scheme of
+// the location:
+//
+//	users.json                  local file
+//	file:///var/dumps/users.json
+//	-                           stdin when reading, stdout when writing
+//	s3://bucket/path/users.json S3-compatible object storage
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Backend stores dumps under a location URL
+type Backend interface {
+	// Create returns a writer for a new dump at location. The dump is only
+	// complete once Close returned without an error. Writers that can discard
+	// an unfinished dump implement Aborter.
+	Create(ctx context.Context, location *url.URL) (io.WriteCloser, error)
+
+	// Open returns a reader for the dump at location
+	Open(ctx context.Context, location *url.URL) (io.ReadCloser, error)
+}
+
+// Aborter is implemented by writers that can discard an unfinished dump, so
+// that it does not appear under its final name
+type Aborter interface {
+	Abort(err error)
+}
+
+var (
+	mu       sync.RWMutex
+	backends = map[string]Backend{
+		"":     fileBackend{},
+		"file": fileBackend{},
+		"s3":   s3Backend{},
+	}
+)
+
+// Register makes a backend available for a URL scheme, replacing any backend
+// registered for it before
+func Register(scheme string, backend Backend) {
+	mu.Lock()
+	defer mu.Unlock()
+	backends[strings.ToLower(scheme)] = backend
+}
+
+// Create returns a writer for a new dump at location
+func Create(ctx context.Context, location string) (io.WriteCloser, error) {
+	if location == "-" {
+		return nopWriteCloser{stdout}, nil
+	}
+	backend, u, err := resolve(location)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Create(ctx, u)
+}
+
+// Abort discards the unfinished dump written to w because of err. Writers
+// that cannot discard it are closed.
+func Abort(w io.WriteCloser, err error) {
+	if aborter, ok := w.(Aborter); ok {
+		aborter.Abort(err)
+		return
+	}
+	w.Close()
+}
+
+// Open returns a reader for the dump at location
+func Open(ctx context.Context, location string) (io.ReadCloser, error) {
+	if location == "-" {
+		return io.NopCloser(stdin), nil
+	}
+	backend, u, err := resolve(location)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Open(ctx, u)
+}
+
+// resolve parses location and looks up the backend of its scheme. Locations
+// without a scheme, including Windows paths such as C:\dumps, are local files.
+func resolve(location string) (Backend, *url.URL, error) {
+	u := &url.URL{Path: location}
+	if i := strings.Index(location, "://"); i > 1 {
+		parsed, err := url.Parse(location)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid location %s: %w", location, err)
+		}
+		u = parsed
+	}
+
+	mu.RLock()
+	backend, ok := backends[strings.ToLower(u.Scheme)]
+	mu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported storage scheme %q in %s", u.Scheme, location)
+	}
+	return backend, u, nil
+}
+
+// ShardLocation names the dump of a shard by inserting its index before the
+// extension, keeping any query
+func ShardLocation(location string, shard int) string {
+	base, query, hasQuery := strings.Cut(location, "?")
+	ext := path.Ext(base)
+	base = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, ext), shard, ext)
+	if hasQuery {
+		return base + "?" + query
+	}
+	return base
+}
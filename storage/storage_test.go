@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		location string
+		backend  Backend
+		path     string
+		err      bool
+	}{
+		{"users.json", fileBackend{}, "users.json", false},
+		{"dumps/users.json", fileBackend{}, "dumps/users.json", false},
+		{`C:\dumps\users.json`, fileBackend{}, `C:\dumps\users.json`, false},
+		{"file:///var/dumps/users.json", fileBackend{}, "/var/dumps/users.json", false},
+		{"file://dumps/users.json", fileBackend{}, "dumps/users.json", false},
+		{"s3://bucket/path/users.json", s3Backend{}, "", false},
+		{"S3://bucket/users.json", s3Backend{}, "", false},
+		{"ftp://host/users.json", nil, "", true},
+		{"s3://bucket/%zz", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			backend, u, err := resolve(tt.location)
+			if (err != nil) != tt.err {
+				t.Fatalf("resolve(%q) error = %v, want error: %v", tt.location, err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if backend != tt.backend {
+				t.Errorf("resolve(%q) backend = %T, want %T", tt.location, backend, tt.backend)
+			}
+			if tt.path != "" && filePath(u) != tt.path {
+				t.Errorf("resolve(%q) path = %q, want %q", tt.location, filePath(u), tt.path)
+			}
+		})
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := map[string]string{
+		"dumps/users.json": "application/json",
+		"dump.rdb":         "application/octet-stream",
+		"dump.resp":        "application/octet-stream",
+		"dump":             "application/octet-stream",
+	}
+	for object, want := range tests {
+		if got := contentType(object); got != want {
+			t.Errorf("contentType(%q) = %q, want %q", object, got, want)
+		}
+	}
+}
+
+func TestShardLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"dump.rdb", "dump-2.rdb"},
+		{"dumps/nightly", "dumps/nightly-2"},
+		{"s3://bucket/dump.rdb?endpoint=localhost:9000", "s3://bucket/dump-2.rdb?endpoint=localhost:9000"},
+	}
+	for _, tt := range tests {
+		if got := ShardLocation(tt.location, 2); got != tt.want {
+			t.Errorf("ShardLocation(%q, 2) = %q, want %q", tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestFileBackend(t *testing.T) {
+	ctx := context.Background()
+	location := filepath.Join(t.TempDir(), "users.json")
+
+	w, err := Create(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "dump"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(ctx, "file://"+location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "dump" {
+		t.Errorf("read back %q, %v, want %q", data, err, "dump")
+	}
+
+	// An aborted dump is removed
+	w, err = Create(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "trunc")
+	Abort(w, errors.New("export failed"))
+	if _, err := os.Stat(location); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("aborted dump still exists: %v", err)
+	}
+
+	if _, err := Open(ctx, location); err == nil {
+		t.Error("Open of a missing file succeeded")
+	}
+}
+
+func TestStdio(t *testing.T) {
+	var out bytes.Buffer
+	stdin, stdout = bytes.NewBufferString("in"), &out
+	defer func() { stdin, stdout = os.Stdin, os.Stdout }()
+
+	w, err := Create(context.Background(), "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "out")
+	// Aborting stdout only closes it, which leaves it open
+	Abort(w, errors.New("export failed"))
+	if out.String() != "out" {
+		t.Errorf("stdout = %q, want %q", out.String(), "out")
+	}
+
+	r, err := Open(context.Background(), "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(r); string(data) != "in" {
+		t.Errorf("stdin = %q, want %q", data, "in")
+	}
+}
+
+func TestS3Writer(t *testing.T) {
+	// upload stands in for PutObject, reading until the pipe is closed
+	type result struct {
+		data []byte
+		err  error
+	}
+	upload := func() (*s3Writer, chan result) {
+		reader, writer := io.Pipe()
+		w := &s3Writer{pipe: writer, done: make(chan error, 1)}
+		uploaded := make(chan result, 1)
+		go func() {
+			data, err := io.ReadAll(reader)
+			uploaded <- result{data, err}
+			w.done <- err
+		}()
+		return w, uploaded
+	}
+
+	w, uploaded := upload()
+	io.WriteString(w, "dump")
+	if err := w.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	if got := <-uploaded; string(got.data) != "dump" || got.err != nil {
+		t.Errorf("uploaded %q, %v, want %q", got.data, got.err, "dump")
+	}
+
+	// The upload fails instead of completing
+	w, uploaded = upload()
+	io.WriteString(w, "trunc")
+	failed := errors.New("export failed")
+	Abort(w, failed)
+	if got := <-uploaded; !errors.Is(got.err, failed) {
+		t.Errorf("aborted upload read %q, %v, want %v", got.data, got.err, failed)
+	}
+}
+
+func TestS3Location(t *testing.T) {
+	for _, location := range []string{
+		"s3://bucket",
+		"s3:///object",
+		"s3://bucket/dump.json?insecure=maybe",
+	} {
+		u, err := url.Parse(location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, err := s3Client(u); err == nil {
+			t.Errorf("s3Client(%q) succeeded, want an error", location)
+		}
+	}
+
+	u, _ := url.Parse("s3://bucket/dump.json?part-size=4")
+	if _, err := (s3Backend{}).Create(context.Background(), u); err == nil {
+		t.Error("Create with a part size below 5 MiB succeeded")
+	}
+}